@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jesseward/impulse/internal/player"
+	"github.com/jesseward/impulse/pkg/flac"
+	"github.com/urfave/cli/v2"
+)
+
+func analyzeAction(c *cli.Context) error {
+	log.SetOutput(io.Discard)
+	mod, err := loadModule(c.String("file"))
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	a, err := player.Analyze(mod)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	fmt.Printf("Duration: %v\n", a.Duration.Round(time.Second))
+	if a.Loops {
+		fmt.Printf("Loops back to order %d, row %d at %v\n", a.LoopOrder, a.LoopRow, a.LoopStart.Round(time.Second))
+	}
+	if !c.Bool("loudness") {
+		if c.IsSet("output") {
+			return cli.Exit("Tagging an output file requires --loudness", 1)
+		}
+		return nil
+	}
+
+	opts, err := playerOptions(c)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	muted, err := mutedChannels(c, mod.NumChannels())
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	loudness, err := measureLoudness(c, mod, opts, muteChannels(muted))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Integrated loudness: %.1f LUFS\n", loudness.Integrated)
+	fmt.Printf("Loudness range: %.1f LU\n", loudness.Range)
+	fmt.Printf("True peak: %.1f dBTP\n", loudness.TruePeak)
+	fmt.Printf("ReplayGain: %+.2f dB, peak %.6f\n", loudness.ReplayGain(), loudness.ReplayGainPeak())
+
+	if output := c.String("output"); output != "" {
+		if err := tagReplayGain(output, loudness); err != nil {
+			return cli.Exit(fmt.Sprintf("Failed to tag %s: %v", output, err), 1)
+		}
+		fmt.Printf("Tagged %s\n", output)
+	}
+	return nil
+}
+
+// replayGainComments returns the comments of the ReplayGain 2.0 track gain and
+// peak.
+func replayGainComments(l player.Loudness) []string {
+	return []string{
+		fmt.Sprintf("REPLAYGAIN_TRACK_GAIN=%.2f dB", l.ReplayGain()),
+		fmt.Sprintf("REPLAYGAIN_TRACK_PEAK=%.6f", l.ReplayGainPeak()),
+		fmt.Sprintf("REPLAYGAIN_REFERENCE_LOUDNESS=%.2f LUFS", player.ReplayGainReference),
+	}
+}
+
+// tagReplayGain replaces the ReplayGain comments of the FLAC, WAV or AIFF file
+// at path. The file is rewritten to a temporary file next to it, which then
+// replaces it, so that it is left intact on errors.
+func tagReplayGain(path string, l player.Loudness) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	marker := make([]byte, 4)
+	if _, err := io.ReadFull(in, marker); err != nil {
+		return err
+	}
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	updateComments := player.UpdateComments
+	switch string(marker) {
+	case "fLaC":
+		updateComments = func(r io.ReadSeeker, w io.Writer, update func([]string) []string) error {
+			return flac.UpdateComments(r, w, update)
+		}
+	case "RIFF", "FORM":
+	default:
+		return errors.New("not a FLAC, WAV or AIFF file")
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(path), ".impulse-*"+filepath.Ext(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	if err := out.Chmod(info.Mode().Perm()); err != nil {
+		out.Close()
+		return err
+	}
+
+	err = updateComments(in, out, func(comments []string) []string {
+		comments = slices.DeleteFunc(comments, func(c string) bool {
+			return strings.HasPrefix(strings.ToUpper(c), "REPLAYGAIN_")
+		})
+		return append(comments, replayGainComments(l)...)
+	})
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), path)
+}
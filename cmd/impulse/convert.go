@@ -1,19 +1,45 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/jesseward/impulse/internal/player"
-	"github.com/jesseward/impulse/pkg/protracker"
-	"github.com/jesseward/impulse/pkg/s3m"
-	"github.com/jesseward/impulse/pkg/xm"
+	"github.com/jesseward/impulse/pkg/module"
 	"github.com/urfave/cli/v2"
 )
 
+var (
+	// errInterrupted is returned by render when an interrupt stopped it.
+	errInterrupted = errors.New("interrupted")
+	// errMeasureInterrupted is returned by measureLoudness when an interrupt
+	// stopped it, before any output was created.
+	errMeasureInterrupted = errors.New("interrupted while measuring the loudness")
+)
+
 func convertAction(c *cli.Context) error {
+	err := convert(c)
+	if errors.Is(err, errMeasureInterrupted) {
+		return cli.Exit("Interrupted while measuring the loudness, no audio was written.", 1)
+	}
+	if errors.Is(err, errInterrupted) {
+		// The outputs were closed with the audio rendered so far.
+		fmt.Fprintln(os.Stderr, "Interrupted, the output holds the audio rendered so far.")
+		return nil
+	}
+	return err
+}
+
+func convert(c *cli.Context) error {
 	filePath := c.String("file")
 	format := c.String("format")
 	output := c.String("output")
@@ -25,11 +51,54 @@ func convertAction(c *cli.Context) error {
 	defer logFile.Close()
 	log.SetOutput(logFile)
 
-	module, err := loadModule(filePath)
+	mod, err := loadModule(filePath)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	if !player.Playable(mod) {
+		return cli.Exit(fmt.Sprintf("ERROR: %s modules are not supported for conversion.", mod.Type()), 1)
+	}
+
+	opts, err := playerOptions(c)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	if err := sampleFormat(c, &opts); err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	muted, err := mutedChannels(c, mod.NumChannels())
 	if err != nil {
 		return cli.Exit(err.Error(), 1)
 	}
 
+	comments := moduleComments(mod)
+	if c.Bool("normalize") {
+		// The loudness is measured without the limiter, so that the gain
+		// scales it linearly. The output is only created once it is
+		// measured, so that an interrupt leaves none behind.
+		measureOpts := opts
+		measureOpts.NoLimiter = true
+		loudness, err := measureLoudness(c, mod, measureOpts, muteChannels(muted))
+		if err != nil {
+			return err
+		}
+		gain := loudness.NormalizationGain(c.Float64("target"), truePeakCeiling)
+		log.Printf("Measured %.1f LUFS with a true peak of %.1f dBTP, applying %+.2f dB", loudness.Integrated, loudness.TruePeak, gain)
+		opts.MasterGain += gain
+
+		// The ReplayGain tags describe the normalized output.
+		loudness.Integrated += gain
+		loudness.TruePeak += gain
+		comments = append(comments, replayGainComments(loudness)...)
+	}
+
+	if c.Bool("stems") {
+		if output == "" {
+			return cli.Exit("Converting to stems requires an output directory", 1)
+		}
+		return convertStems(c, mod, opts, muted, output)
+	}
+
 	var audioPlayer player.AudioPlayer
 	var writer io.WriteCloser
 	if output != "" {
@@ -43,20 +112,184 @@ func convertAction(c *cli.Context) error {
 	}
 	defer writer.Close()
 
-	opts := player.DefaultPlayerOptions()
-	if format == "wav" {
-		audioPlayer = player.NewWavPlayer(writer, opts)
-	} else {
+	switch format {
+	case "wav":
+		wav := player.NewWavPlayer(writer, opts)
+		wav.SetComments(comments)
+		audioPlayer = wav
+	case "aiff":
+		aiff := player.NewAiffPlayer(writer, opts)
+		aiff.SetComments(comments)
+		audioPlayer = aiff
+	case "flac":
+		if audioPlayer, err = player.NewFlacPlayer(writer, opts, comments); err != nil {
+			return cli.Exit(fmt.Sprintf("Failed to create FLAC encoder: %v", err), 1)
+		}
+	default:
 		audioPlayer = player.NewStreamPlayer(writer, opts)
 	}
 	defer audioPlayer.Close()
 
-	switch m := module.(type) {
-	case *protracker.ModFile, *s3m.S3M, *xm.Module:
-		p := player.NewPlayer(m, log.Printf, nil, opts)
-		if err := p.WriteRaw(audioPlayer, nil); err != nil {
-			return cli.Exit(fmt.Sprintf("Failed to render audio file: %v", err), 1)
+	return render(c, mod, opts, audioPlayer, muteChannels(muted))
+}
+
+// muteChannels returns a function that mutes the given channels of a player.
+func muteChannels(muted []int) func(p *player.Player) {
+	return func(p *player.Player) {
+		for _, ch := range muted {
+			p.SetChannelMute(ch, true)
+		}
+	}
+}
+
+// convertStems renders every channel, or every instrument with
+// --instrument-stems, to its own WAV file in dir. The stems are all rendered
+// from the same position for the same length without the limiter or dither,
+// so that they add up to the full mix to within the rounding of their samples.
+func convertStems(c *cli.Context, m module.Module, opts player.PlayerOptions, muted []int, dir string) error {
+	opts.NoLimiter = true
+	opts.Dither = false
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return cli.Exit(fmt.Sprintf("Failed to create output directory %s : %v", dir, err), 1)
+	}
+
+	type stem struct {
+		name string
+		mute func(p *player.Player)
+	}
+	var stems []stem
+	if c.Bool("instrument-stems") {
+		instruments := usedInstruments(m)
+		for _, ins := range instruments {
+			stems = append(stems, stem{
+				name: fmt.Sprintf("instrument-%02d.wav", ins),
+				mute: func(p *player.Player) {
+					for _, other := range instruments {
+						p.SetInstrumentMute(other, other != ins)
+					}
+					muteChannels(muted)(p)
+				},
+			})
+		}
+	} else {
+		for ch := range m.NumChannels() {
+			if slices.Contains(muted, ch) {
+				continue
+			}
+			stems = append(stems, stem{
+				name: fmt.Sprintf("channel-%02d.wav", ch+1),
+				mute: func(p *player.Player) { p.Solo(ch) },
+			})
+		}
+	}
+
+	for _, s := range stems {
+		path := filepath.Join(dir, s.name)
+		writer, err := os.Create(path)
+		if err != nil {
+			return cli.Exit(fmt.Sprintf("Failed to create output file %s : %v", path, err), 1)
+		}
+		audioPlayer := player.NewWavPlayer(writer, opts)
+		err = render(c, m, opts, audioPlayer, s.mute)
+		audioPlayer.Close()
+		writer.Close()
+		if err != nil {
+			return err
+		}
+		fmt.Println(path)
+	}
+	return nil
+}
+
+// usedInstruments returns the instrument numbers that appear in the patterns
+// of m, in ascending order.
+func usedInstruments(m module.Module) []int {
+	used := make(map[int]bool)
+	for _, pattern := range m.PatternOrder()[:m.SongLength()] {
+		for row := range m.NumRows(pattern) {
+			for ch := range m.NumChannels() {
+				// MOD files number their instruments as samples.
+				cell := m.PatternCell(pattern, row, ch)
+				if ins := max(int(cell.Instrument), int(cell.SampleNumber)); ins > 0 {
+					used[ins] = true
+				}
+			}
+		}
+	}
+	return slices.Sorted(maps.Keys(used))
+}
+
+// moduleComments returns the comments of a module, of the form NAME=value:
+// its title, the tracker it was made with, and its sample names, where
+// trackers keep the message of the song. FLAC files store them as Vorbis
+// comments, and WAV and AIFF files in an ID3 tag.
+func moduleComments(m module.Module) []string {
+	comments := []string{"TITLE=" + m.Name(), "TRACKER=" + m.Type()}
+	var names []string
+	for _, s := range m.Samples() {
+		names = append(names, strings.TrimRight(s.Name(), " \x00"))
+	}
+	if message := strings.TrimRight(strings.Join(names, "\n"), "\n"); message != "" {
+		comments = append(comments, "COMMENT="+message)
+	}
+	return comments
+}
+
+// truePeakCeiling is the highest true peak, in dBTP, that EBU R128 allows
+// normalized audio.
+const truePeakCeiling = -1.0
+
+// measureLoudness renders m to a loudness meter. The samples are rendered in
+// floating point without dither, so that the measure does not depend on the
+// output format.
+func measureLoudness(c *cli.Context, m module.Module, opts player.PlayerOptions, mute func(p *player.Player)) (player.Loudness, error) {
+	opts.BitDepth, opts.Float, opts.Dither = 4, true, false
+	meter := player.NewLoudnessMeter(opts)
+	if err := render(c, m, opts, meter, mute); err != nil {
+		if errors.Is(err, errInterrupted) {
+			err = errMeasureInterrupted
+		}
+		return player.Loudness{}, err
+	}
+	return meter.Loudness(), nil
+}
+
+// render plays m to audioPlayer with the options of the convert command, after
+// mute has muted the channels that are not rendered. The part of the song that
+// is rendered is selected by the flags of renderFlags.
+func render(c *cli.Context, m module.Module, opts player.PlayerOptions, audioPlayer player.AudioPlayer, mute func(p *player.Player)) error {
+	p := player.NewPlayer(m, log.Printf, nil, opts)
+	mute(p)
+	if err := p.SetLoops(c.Int("loops")); err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	p.SetMaxDuration(c.Duration("max-duration"))
+	p.SetFadeOut(c.Duration("fade-out"))
+	if err := p.SetEndOrder(c.Int("end-order")); err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	if c.IsSet("start-order") && c.IsSet("start") {
+		return cli.Exit("Only one of --start and --start-order can be given", 1)
+	}
+	if c.IsSet("start-order") {
+		if err := p.Seek(c.Int("start-order"), 0); err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+	}
+	if start := c.Duration("start"); start > 0 {
+		if err := p.SeekTime(start); err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+	}
+	// An interrupt stops rendering with errInterrupted, and the caller
+	// closes the output with the audio rendered so far.
+	ctx, stop := signal.NotifyContext(c.Context, os.Interrupt)
+	defer stop()
+	if err := p.Render(ctx, audioPlayer); err != nil {
+		if errors.Is(err, context.Canceled) && c.Context.Err() == nil {
+			return errInterrupted
 		}
+		return cli.Exit(fmt.Sprintf("Failed to render audio file: %v", err), 1)
 	}
 	return nil
 }
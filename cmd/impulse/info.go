@@ -1,16 +1,20 @@
 package main
 
 import (
-	"errors"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/jesseward/impulse/internal/player"
+	"github.com/jesseward/impulse/pkg/loader"
 	"github.com/jesseward/impulse/pkg/module"
 	"github.com/urfave/cli/v2"
 )
 
 func infoAction(c *cli.Context) error {
 	if c.NArg() == 0 {
-		return cli.Exit(errors.New("no file specified"), 1)
+		printFormats()
+		return nil
 	}
 	filePath := c.Args().Get(0)
 	module, err := loadModule(filePath)
@@ -28,6 +32,13 @@ func printModuleInfo(module module.Module) {
 	fmt.Printf("Song Length: %d\n", module.SongLength())
 	fmt.Printf("Song BPM: %d\n", module.DefaultBPM())
 	fmt.Printf("Song Speed: %d\n", module.DefaultSpeed())
+	if a, err := player.Analyze(module); err == nil {
+		if a.Loops {
+			fmt.Printf("Duration: %v (loops back to order %d, row %d at %v)\n", a.Duration.Round(time.Second), a.LoopOrder, a.LoopRow, a.LoopStart.Round(time.Second))
+		} else {
+			fmt.Printf("Duration: %v\n", a.Duration.Round(time.Second))
+		}
+	}
 
 	fmt.Printf("Number of channels: %d\n", module.NumChannels())
 	fmt.Printf("Number of patterns: %d\n", module.NumPatterns())
@@ -36,3 +47,10 @@ func printModuleInfo(module module.Module) {
 		fmt.Printf("Sample %d: %s\n", i+1, sample.Name())
 	}
 }
+
+func printFormats() {
+	fmt.Println("Supported formats:")
+	for _, format := range loader.Formats() {
+		fmt.Printf("  %-32s %s\n", format.Name, strings.Join(format.Extensions, ", "))
+	}
+}
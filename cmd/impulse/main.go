@@ -5,6 +5,7 @@ import (
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"slices"
 
 	"github.com/urfave/cli/v2"
 )
@@ -12,7 +13,7 @@ import (
 func main() {
 	app := &cli.App{
 		Name:  "impulse",
-		Usage: "A command-line MOD and S3M player",
+		Usage: "A command-line player for MOD, S3M, XM and IT modules",
 		Flags: []cli.Flag{
 			&cli.BoolFlag{
 				Name:  "prof",
@@ -33,13 +34,13 @@ func main() {
 		Commands: []*cli.Command{
 			{
 				Name:   "play",
-				Usage:  "Play a MOD or S3M file",
+				Usage:  "Play a MOD, S3M, XM or IT file",
 				Action: playAction,
-				Flags: []cli.Flag{
+				Flags: slices.Concat([]cli.Flag{
 					&cli.StringFlag{
 						Name:     "file",
 						Aliases:  []string{"f"},
-						Usage:    "path to the MOD or S3M file",
+						Usage:    "path to the MOD, S3M, XM or IT file",
 						Required: true,
 					},
 					&cli.BoolFlag{
@@ -50,33 +51,96 @@ func main() {
 						Name:  "v2",
 						Usage: "start the new v2 terminal UI",
 					},
-				},
+				}, mixFlags(), sampleFormatFlags()),
 			},
 			{
 				Name:   "convert",
-				Usage:  "Convert a MOD or S3M file to WAV or RAW format",
+				Usage:  "Convert a MOD, S3M, XM or IT file to WAV, AIFF, FLAC or RAW format",
 				Action: convertAction,
-				Flags: []cli.Flag{
+				Flags: slices.Concat([]cli.Flag{
 					&cli.StringFlag{
 						Name:     "file",
 						Aliases:  []string{"f"},
-						Usage:    "path to the MOD or S3M file",
+						Usage:    "path to the MOD, S3M, XM or IT file",
 						Required: true,
 					},
 					&cli.StringFlag{
 						Name:  "format",
 						Value: "wav",
-						Usage: "output format (wav or raw)",
+						Usage: "output format (wav, aiff, flac or raw)",
+					},
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Usage:   "path to the output file, or the output directory with --stems",
+					},
+					&cli.BoolFlag{
+						Name:  "stems",
+						Usage: "render every channel to its own WAV file in the output directory",
+					},
+					&cli.BoolFlag{
+						Name:  "instrument-stems",
+						Usage: "with --stems, render every instrument instead of every channel",
 					},
+					&cli.BoolFlag{
+						Name:  "normalize",
+						Usage: "render the song twice, measuring its loudness and then normalizing it to --target, and tag the output with its ReplayGain",
+					},
+					&cli.Float64Flag{
+						Name:  "target",
+						Value: -23,
+						Usage: "loudness to normalize to in LUFS, with the true peak kept below -1 dBTP",
+					},
+				}, mixFlags(), renderFlags(), sampleFormatFlags()),
+			},
+			{
+				Name:   "analyze",
+				Usage:  "Analyze the length and loudness of a song",
+				Action: analyzeAction,
+				Flags: slices.Concat([]cli.Flag{
 					&cli.StringFlag{
-						Name:  "output",
-						Usage: "path to the output file",
+						Name:     "file",
+						Aliases:  []string{"f"},
+						Usage:    "path to the MOD, S3M, XM or IT file",
+						Required: true,
+					},
+					&cli.BoolFlag{
+						Name:  "loudness",
+						Usage: "render the song to measure its EBU R128 loudness and ReplayGain",
+					},
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Usage:   "with --loudness, a FLAC, WAV or AIFF file converted from the song with the same options to tag with the ReplayGain",
+					},
+				}, mixFlags(), renderFlags()),
+			},
+			{
+				Name:   "transcode",
+				Usage:  "Convert a module between the MOD, S3M and XM formats",
+				Action: transcodeAction,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "file",
+						Aliases:  []string{"f"},
+						Usage:    "path to the MOD, S3M or XM file",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "output",
+						Aliases:  []string{"o"},
+						Usage:    "path to the output file",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "format",
+						Usage: "output format (mod, s3m or xm), taken from the output file extension by default",
 					},
 				},
 			},
 			{
 				Name:   "info",
-				Usage:  "Display information about a MOD or S3M file",
+				Usage:  "Display information about a MOD, S3M, XM or IT file, or list the supported formats",
 				Action: infoAction,
 			},
 		},
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jesseward/impulse/internal/player"
+	"github.com/urfave/cli/v2"
+)
+
+// mixFlags returns the flags that select how a song is mixed, read by
+// playerOptions and mutedChannels.
+func mixFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.DurationFlag{
+			Name:  "start",
+			Usage: "start at the given time into the song, such as 1m30s",
+		},
+		&cli.StringFlag{
+			Name:  "interpolation",
+			Value: "linear",
+			Usage: "sample interpolation (nearest, linear, cubic or sinc)",
+		},
+		&cli.StringFlag{
+			Name:  "amiga",
+			Value: "off",
+			Usage: "emulate the audio output of an Amiga for MOD files (off, a500 or a1200)",
+		},
+		&cli.IntFlag{
+			Name:  "volume-ramp",
+			Usage: "number of samples over which volume changes are smoothed to avoid clicks, 0 to disable (default 1 ms)",
+		},
+		&cli.StringFlag{
+			Name:  "mute",
+			Usage: "comma separated channels to mute, such as 1,3",
+		},
+		&cli.Float64Flag{
+			Name:  "gain",
+			Usage: "master gain in dB, on top of the automatic headroom for modules with many channels",
+		},
+		&cli.BoolFlag{
+			Name:  "no-limiter",
+			Usage: "disable the soft limiter, so that loud passages clip",
+		},
+	}
+}
+
+// renderFlags returns the flags that select the part of a song that is
+// rendered to a file, read by render.
+func renderFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.IntFlag{
+			Name:  "loops",
+			Value: 1,
+			Usage: "number of times to play a song that loops back, 0 to play it forever",
+		},
+		&cli.DurationFlag{
+			Name:  "max-duration",
+			Usage: "stop rendering after the given time, such as 3m30s",
+		},
+		&cli.DurationFlag{
+			Name:  "fade-out",
+			Usage: "fade out over the given time before rendering stops, such as 10s",
+		},
+		&cli.IntFlag{
+			Name:  "start-order",
+			Usage: "start at the given order, counted from 0",
+		},
+		&cli.IntFlag{
+			Name:  "end-order",
+			Value: -1,
+			Usage: "stop when the song moves past the given order, counted from 0",
+		},
+	}
+}
+
+// sampleFormatFlags returns the flags that select the format of the output
+// samples, read by sampleFormat.
+func sampleFormatFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.IntFlag{
+			Name:  "bits",
+			Value: 16,
+			Usage: "bits per sample: 8, 16 or 24 for integer samples, or 32 for floating point samples",
+		},
+		&cli.BoolFlag{
+			Name:  "no-dither",
+			Usage: "disable the dither of integer samples",
+		},
+	}
+}
+
+// playerOptions returns the player options selected by the flags of
+// mixFlags, in the default sample format.
+func playerOptions(c *cli.Context) (player.PlayerOptions, error) {
+	opts := player.DefaultPlayerOptions()
+	var err error
+	if opts.Interpolation, err = player.ParseInterpolation(c.String("interpolation")); err != nil {
+		return opts, err
+	}
+	if opts.Amiga, err = player.ParseAmigaModel(c.String("amiga")); err != nil {
+		return opts, err
+	}
+	if c.IsSet("volume-ramp") {
+		if opts.VolumeRamp = c.Int("volume-ramp"); opts.VolumeRamp < 0 {
+			return opts, fmt.Errorf("invalid volume ramp %d", opts.VolumeRamp)
+		}
+	}
+	opts.MasterGain = c.Float64("gain")
+	opts.NoLimiter = c.Bool("no-limiter")
+	return opts, nil
+}
+
+// sampleFormat sets the sample format of opts selected by the flags of
+// sampleFormatFlags.
+func sampleFormat(c *cli.Context, opts *player.PlayerOptions) error {
+	switch bits := c.Int("bits"); bits {
+	case 8, 16, 24:
+		opts.BitDepth = bits / 8
+	case 32:
+		opts.BitDepth, opts.Float = 4, true
+	default:
+		return fmt.Errorf("unsupported sample size of %d bits, want 8, 16, 24 or 32", bits)
+	}
+	opts.Dither = !c.Bool("no-dither")
+	return nil
+}
+
+// mutedChannels returns the zero based channels listed by the --mute flag as
+// comma separated channel numbers counted from 1.
+func mutedChannels(c *cli.Context, numChannels int) ([]int, error) {
+	var channels []int
+	if !c.IsSet("mute") {
+		return channels, nil
+	}
+	for _, field := range strings.Split(c.String("mute"), ",") {
+		ch, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || ch < 1 || ch > numChannels {
+			return nil, fmt.Errorf("invalid channel %q to mute, want 1 to %d", field, numChannels)
+		}
+		channels = append(channels, ch-1)
+	}
+	return channels, nil
+}
@@ -8,9 +8,6 @@ import (
 	"github.com/jesseward/impulse/internal/player"
 	"github.com/jesseward/impulse/internal/ui"
 	"github.com/jesseward/impulse/pkg/module"
-	"github.com/jesseward/impulse/pkg/protracker"
-	"github.com/jesseward/impulse/pkg/s3m"
-	"github.com/jesseward/impulse/pkg/xm"
 	"github.com/urfave/cli/v2"
 )
 
@@ -30,29 +27,47 @@ func playAction(c *cli.Context) error {
 	if err != nil {
 		return cli.Exit(err.Error(), 1)
 	}
+	if !player.Playable(m) {
+		return cli.Exit("ERROR: Module file type not supported for playback.", 1)
+	}
+
+	opts, err := playerOptions(c)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	if err := sampleFormat(c, &opts); err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	muted, err := mutedChannels(c, m.NumChannels())
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
 
 	if startUI {
-		ui.New(m)
+		ui.New(m, opts, muted)
 		return nil
 	}
 
 	printModuleInfo(m)
 
-	opts := player.DefaultPlayerOptions()
 	audioPlayer, err := player.NewOtoPlayer(opts)
 	if err != nil {
 		return cli.Exit(fmt.Sprintf("Failed to create OtoPlayer: %v", err), 1)
 	}
 	defer audioPlayer.Close()
 
-	switch mod := m.(type) {
-	case *protracker.ModFile, *s3m.S3M, *xm.Module:
-		p := player.NewPlayer(mod, log.Printf, nil, opts)
-		if err := p.WriteRaw(audioPlayer, nil); err != nil {
-			return cli.Exit(fmt.Sprintf("Failed to render audio file: %v", err), 1)
+	p := player.NewPlayer(m, log.Printf, nil, opts)
+	for _, ch := range muted {
+		p.SetChannelMute(ch, true)
+	}
+	if start := c.Duration("start"); start > 0 {
+		if err := p.SeekTime(start); err != nil {
+			return cli.Exit(err.Error(), 1)
 		}
-	default:
-		return cli.Exit("ERROR: Module file type not supported for playback.", 1)
+	}
+	if err := p.Render(c.Context, audioPlayer); err != nil {
+		return cli.Exit(fmt.Sprintf("Failed to render audio file: %v", err), 1)
 	}
 	return nil
 }
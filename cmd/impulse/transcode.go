@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jesseward/impulse/pkg/transcode"
+	"github.com/urfave/cli/v2"
+)
+
+func transcodeAction(c *cli.Context) error {
+	filePath := c.String("file")
+	output := c.String("output")
+	format := c.String("format")
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(output), ".")
+	}
+
+	module, err := loadModule(filePath)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	writer, err := os.Create(output)
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("Failed to create output file %s : %v", output, err), 1)
+	}
+	defer writer.Close()
+
+	warnings, err := transcode.Write(writer, module, format)
+	if err != nil {
+		os.Remove(output)
+		return cli.Exit(fmt.Sprintf("Failed to transcode module: %v", err), 1)
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package player
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jesseward/impulse/pkg/module"
+)
+
+// Analysis describes the length of a song, as found by Analyze.
+type Analysis struct {
+	// Duration is the playing time of the song until it ends, or until it
+	// loops back to a position it already played.
+	Duration time.Duration
+	// Loops reports whether the song loops back instead of ending.
+	Loops bool
+	// LoopOrder and LoopRow are the position the song loops back to, and
+	// LoopStart is the time at which that position is first played.
+	LoopOrder int
+	LoopRow   int
+	LoopStart time.Duration
+
+	frames     int
+	loopFrames int
+}
+
+// LoopDuration returns the playing time of one loop of a looping song.
+func (a Analysis) LoopDuration() time.Duration {
+	if !a.Loops {
+		return 0
+	}
+	return a.Duration - a.LoopStart
+}
+
+// loopKey identifies a row together with the player state that decides how
+// the song continues from it. The song loops when a key repeats.
+type loopKey struct {
+	order, row                       int
+	speed, bpm                       int
+	globalVolume                     float64
+	patternLoopRow, patternLoopCount int
+}
+
+// Analyze plays m without mixing it to find its duration and whether, and
+// where, it loops back.
+func Analyze(m module.Module) (Analysis, error) {
+	p := NewPlayer(m, func(string, ...interface{}) {}, nil, DefaultPlayerOptions())
+	if p.ticker == nil {
+		return Analysis{}, fmt.Errorf("playback of %s modules is not supported", m.Type())
+	}
+	return p.analyze()
+}
+
+// analyze plays the song from the start without mixing it and restores the
+// position afterwards.
+func (p *Player) analyze() (a Analysis, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	saved := p.state
+	defer func() { p.state = saved }()
+	defer recoverFault(&err)
+
+	seen := make(map[loopKey]int)
+	p.reset()
+	for p.nextPlayableRow(p.state) {
+		s := p.state
+		key := loopKey{s.order, s.row, s.speed, s.bpm, s.globalVolume, s.patternLoopRow, s.patternLoopCount}
+		if frames, ok := seen[key]; ok {
+			a.Loops = true
+			a.LoopOrder, a.LoopRow = s.order, s.row
+			a.loopFrames = frames
+			break
+		}
+		seen[key] = s.frames
+		p.step(false)
+	}
+	a.frames = p.state.frames
+	a.Duration = p.framesToDuration(a.frames)
+	a.LoopStart = p.framesToDuration(a.loopFrames)
+	return a, nil
+}
+
+// SetLoops makes playback of a looping song stop after it has been played n
+// times. Songs that do not loop always end by themselves. Zero, the default,
+// plays a looping song forever. Finding the loop plays the song without
+// mixing it, which returns a *RenderError on a fault.
+func (p *Player) SetLoops(n int) error {
+	var endFrame int
+	if n > 0 {
+		a, err := p.analyze()
+		if err != nil {
+			return err
+		}
+		if a.Loops {
+			endFrame = a.frames + (n-1)*(a.frames-a.loopFrames)
+		}
+	}
+	p.mu.Lock()
+	p.endFrame = endFrame
+	p.mu.Unlock()
+	return nil
+}
+
+// SetMaxDuration makes every call to WriteRaw stop after it has rendered d of
+// audio, if the song does not end sooner. Zero, the default, does not limit
+// the duration.
+func (p *Player) SetMaxDuration(d time.Duration) {
+	p.mu.Lock()
+	p.maxFrames = p.durationToFrames(d)
+	p.mu.Unlock()
+}
+
+// SetEndOrder makes playback stop when the song moves past order. A negative
+// order, the default, plays the song to its end.
+func (p *Player) SetEndOrder(order int) error {
+	if order >= p.module.SongLength() {
+		return fmt.Errorf("order %d is out of range", order)
+	}
+	p.mu.Lock()
+	p.stopOrder = max(order+1, 0)
+	p.mu.Unlock()
+	return nil
+}
+
+// SetFadeOut fades out the last d of playback before it stops, at the end of
+// the song or at the limit of SetLoops, SetEndOrder or SetMaxDuration. A song
+// that plays forever is not faded out.
+func (p *Player) SetFadeOut(d time.Duration) {
+	p.mu.Lock()
+	p.fadeFrames = p.durationToFrames(d)
+	p.mu.Unlock()
+}
+
+func (p *Player) durationToFrames(d time.Duration) int {
+	return max(int(d.Seconds()*float64(p.opts.SampleRate)), 0)
+}
+
+func (p *Player) framesToDuration(frames int) time.Duration {
+	return time.Duration(frames) * time.Second / time.Duration(p.opts.SampleRate)
+}
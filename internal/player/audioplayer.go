@@ -1,10 +1,15 @@
 package player
 
 import (
+	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
+	"math"
+	"slices"
 
 	"github.com/ebitengine/oto/v3"
+	"github.com/jesseward/impulse/pkg/flac"
 )
 
 // AudioPlayer defines the interface for writing audio data to a destination.
@@ -13,6 +18,13 @@ type AudioPlayer interface {
 	GetSampleRate() int
 }
 
+// Pauser is implemented by audio players that can suspend their output in
+// place, keeping the audio they have buffered.
+type Pauser interface {
+	Pause()
+	Resume()
+}
+
 // --- OtoPlayer ---
 
 type OtoPlayer struct {
@@ -24,10 +36,21 @@ type OtoPlayer struct {
 }
 
 func NewOtoPlayer(opts PlayerOptions) (*OtoPlayer, error) {
+	var format oto.Format
+	switch {
+	case opts.Float && opts.BitDepth == 4:
+		format = oto.FormatFloat32LE
+	case !opts.Float && opts.BitDepth == 1:
+		format = oto.FormatUnsignedInt8
+	case !opts.Float && opts.BitDepth == 2:
+		format = oto.FormatSignedInt16LE
+	default:
+		return nil, fmt.Errorf("audio output does not support %d-bit samples", opts.BitDepth*8)
+	}
 	c, ready, err := oto.NewContext(&oto.NewContextOptions{
 		SampleRate:   opts.SampleRate,
 		ChannelCount: opts.NumChannels,
-		Format:       oto.FormatSignedInt16LE,
+		Format:       format,
 	})
 	if err != nil {
 		return nil, err
@@ -57,10 +80,56 @@ func (o *OtoPlayer) Close() error {
 	return o.pr.Close()
 }
 
+// Pause stops the output, keeping the buffered audio.
+func (o *OtoPlayer) Pause() {
+	o.player.Pause()
+}
+
+// Resume continues the output after Pause.
+func (o *OtoPlayer) Resume() {
+	o.player.Play()
+}
+
 func (o *OtoPlayer) GetSampleRate() int {
 	return o.sampleRate
 }
 
+// --- TeePlayer ---
+
+// TeePlayer plays to an audio player and copies what it plays to a writer,
+// such as a SpectrumAnalyzer. It pauses the audio player if that implements
+// Pauser.
+type TeePlayer struct {
+	AudioPlayer
+	writer io.Writer
+}
+
+func NewTeePlayer(player AudioPlayer, writer io.Writer) *TeePlayer {
+	return &TeePlayer{AudioPlayer: player, writer: writer}
+}
+
+func (t *TeePlayer) Write(data []byte) (int, error) {
+	n, err := t.AudioPlayer.Write(data)
+	if n > 0 {
+		if _, werr := t.writer.Write(data[:n]); err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+func (t *TeePlayer) Pause() {
+	if pauser, ok := t.AudioPlayer.(Pauser); ok {
+		pauser.Pause()
+	}
+}
+
+func (t *TeePlayer) Resume() {
+	if pauser, ok := t.AudioPlayer.(Pauser); ok {
+		pauser.Resume()
+	}
+}
+
 // --- StreamPlayer ---
 
 type StreamPlayer struct {
@@ -86,19 +155,28 @@ func (s *StreamPlayer) GetSampleRate() int {
 
 // --- WavPlayer ---
 
+// WavPlayer writes a WAV file. Float samples, samples of more than 16 bits and
+// more than two channels use the WAVE_FORMAT_EXTENSIBLE header.
 type WavPlayer struct {
-	writer    io.WriteCloser
-	opts      PlayerOptions
-	dataSize  uint32
-	chunkSize uint32
+	writer     io.WriteCloser
+	opts       PlayerOptions
+	headerSize uint32
+	dataSize   uint32
+	comments   []string
 }
 
 func NewWavPlayer(writer io.WriteCloser, opts PlayerOptions) *WavPlayer {
 	return &WavPlayer{writer: writer, opts: opts}
 }
 
+// SetComments sets the comments, of the form NAME=value, that Close writes to
+// an ID3 chunk after the samples.
+func (w *WavPlayer) SetComments(comments []string) {
+	w.comments = comments
+}
+
 func (w *WavPlayer) Write(data []byte) (int, error) {
-	if w.dataSize == 0 {
+	if w.headerSize == 0 {
 		w.writeWavHeader()
 	}
 	n, err := w.writer.Write(data)
@@ -107,12 +185,29 @@ func (w *WavPlayer) Write(data []byte) (int, error) {
 }
 
 func (w *WavPlayer) Close() error {
-	w.chunkSize = 36 + w.dataSize
+	if w.headerSize == 0 {
+		w.writeWavHeader()
+	}
+	// Chunks are padded to an even size.
+	riffSize := w.headerSize - 8 + w.dataSize
+	if w.dataSize%2 != 0 {
+		w.writer.Write([]byte{0})
+		riffSize++
+	}
+	if len(w.comments) > 0 {
+		tag := id3Tag(commentFrames(w.comments))
+		writeID3Chunk(w.writer, binary.LittleEndian, "id3 ", tag)
+		riffSize += uint32(8 + len(tag) + len(tag)%2)
+	}
 	// It's a bit of a hack to seek back to the beginning of the file to write the final chunk and data sizes
 	if seeker, ok := w.writer.(io.Seeker); ok {
 		seeker.Seek(4, io.SeekStart)
-		binary.Write(w.writer, binary.LittleEndian, w.chunkSize)
-		seeker.Seek(40, io.SeekStart)
+		binary.Write(w.writer, binary.LittleEndian, riffSize)
+		if w.extensible() && w.opts.Float {
+			seeker.Seek(int64(w.headerSize-12), io.SeekStart)
+			binary.Write(w.writer, binary.LittleEndian, w.dataSize/uint32(w.blockAlign())) // Sample frames
+		}
+		seeker.Seek(int64(w.headerSize-4), io.SeekStart)
 		binary.Write(w.writer, binary.LittleEndian, w.dataSize)
 	}
 	return w.writer.Close()
@@ -122,23 +217,265 @@ func (w *WavPlayer) GetSampleRate() int {
 	return w.opts.SampleRate
 }
 
+func (w *WavPlayer) extensible() bool {
+	return w.opts.Float || w.opts.BitDepth > 2 || w.opts.NumChannels > 2
+}
+
+func (w *WavPlayer) blockAlign() int {
+	return w.opts.NumChannels * w.opts.BitDepth
+}
+
+// The sub-formats of WAVE_FORMAT_EXTENSIBLE, which are GUIDs that differ in
+// their first byte.
+var (
+	wavSubFormatPCM   = [16]byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xaa, 0x00, 0x38, 0x9b, 0x71}
+	wavSubFormatFloat = [16]byte{0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xaa, 0x00, 0x38, 0x9b, 0x71}
+)
+
 func (w *WavPlayer) writeWavHeader() {
+	var header bytes.Buffer
+
 	// RIFF header
-	w.writer.Write([]byte("RIFF"))
-	binary.Write(w.writer, binary.LittleEndian, uint32(0)) // Placeholder for chunk size
-	w.writer.Write([]byte("WAVE"))
+	header.WriteString("RIFF")
+	binary.Write(&header, binary.LittleEndian, uint32(0)) // Placeholder for chunk size
+	header.WriteString("WAVE")
 
 	// "fmt " sub-chunk
-	w.writer.Write([]byte("fmt "))
-	binary.Write(w.writer, binary.LittleEndian, uint32(16))                                                   // Sub-chunk size
-	binary.Write(w.writer, binary.LittleEndian, uint16(1))                                                    // Audio format (PCM)
-	binary.Write(w.writer, binary.LittleEndian, uint16(w.opts.NumChannels))                                   // Num channels
-	binary.Write(w.writer, binary.LittleEndian, uint32(w.opts.SampleRate))                                    // Sample rate
-	binary.Write(w.writer, binary.LittleEndian, uint32(w.opts.SampleRate*w.opts.NumChannels*w.opts.BitDepth)) // Byte rate
-	binary.Write(w.writer, binary.LittleEndian, uint16(w.opts.NumChannels*w.opts.BitDepth))                   // Block align
-	binary.Write(w.writer, binary.LittleEndian, uint16(w.opts.BitDepth*8))                                    // Bits per sample
+	header.WriteString("fmt ")
+	if w.extensible() {
+		binary.Write(&header, binary.LittleEndian, uint32(40))     // Sub-chunk size
+		binary.Write(&header, binary.LittleEndian, uint16(0xFFFE)) // Audio format (WAVE_FORMAT_EXTENSIBLE)
+	} else {
+		binary.Write(&header, binary.LittleEndian, uint32(16)) // Sub-chunk size
+		binary.Write(&header, binary.LittleEndian, uint16(1))  // Audio format (PCM)
+	}
+	binary.Write(&header, binary.LittleEndian, uint16(w.opts.NumChannels))               // Num channels
+	binary.Write(&header, binary.LittleEndian, uint32(w.opts.SampleRate))                // Sample rate
+	binary.Write(&header, binary.LittleEndian, uint32(w.opts.SampleRate*w.blockAlign())) // Byte rate
+	binary.Write(&header, binary.LittleEndian, uint16(w.blockAlign()))                   // Block align
+	binary.Write(&header, binary.LittleEndian, uint16(w.opts.BitDepth*8))                // Bits per sample
+	if w.extensible() {
+		var channelMask uint32
+		switch w.opts.NumChannels {
+		case 1:
+			channelMask = 0x4 // Front center
+		case 2:
+			channelMask = 0x3 // Front left and right
+		}
+		subFormat := wavSubFormatPCM
+		if w.opts.Float {
+			subFormat = wavSubFormatFloat
+		}
+		binary.Write(&header, binary.LittleEndian, uint16(22))                // Extension size
+		binary.Write(&header, binary.LittleEndian, uint16(w.opts.BitDepth*8)) // Valid bits per sample
+		binary.Write(&header, binary.LittleEndian, channelMask)
+		header.Write(subFormat[:])
+	}
+
+	// "fact" sub-chunk, which is required for formats other than PCM
+	if w.extensible() && w.opts.Float {
+		header.WriteString("fact")
+		binary.Write(&header, binary.LittleEndian, uint32(4))
+		binary.Write(&header, binary.LittleEndian, uint32(0)) // Placeholder for sample frames
+	}
 
 	// "data" sub-chunk
-	w.writer.Write([]byte("data"))
-	binary.Write(w.writer, binary.LittleEndian, uint32(0)) // Placeholder for data size
+	header.WriteString("data")
+	binary.Write(&header, binary.LittleEndian, uint32(0)) // Placeholder for data size
+
+	w.headerSize = uint32(header.Len())
+	w.writer.Write(header.Bytes())
+}
+
+// --- FlacPlayer ---
+
+// FlacPlayer writes a FLAC file of integer samples.
+type FlacPlayer struct {
+	writer  io.WriteCloser
+	opts    PlayerOptions
+	encoder *flac.Encoder
+	samples []int32
+}
+
+// NewFlacPlayer writes the header of a FLAC file with the given Vorbis
+// comments, of the form NAME=value.
+func NewFlacPlayer(writer io.WriteCloser, opts PlayerOptions, comments []string) (*FlacPlayer, error) {
+	if opts.Float {
+		return nil, fmt.Errorf("FLAC does not support floating point samples")
+	}
+	encoder, err := flac.NewEncoder(writer, opts.SampleRate, opts.NumChannels, opts.BitDepth*8, comments)
+	if err != nil {
+		return nil, err
+	}
+	return &FlacPlayer{writer: writer, opts: opts, encoder: encoder}, nil
+}
+
+func (f *FlacPlayer) Write(data []byte) (int, error) {
+	size := f.opts.BitDepth
+	f.samples = f.samples[:0]
+	for i := 0; i+size <= len(data); i += size {
+		var v int32
+		switch size {
+		case 1:
+			v = int32(data[i]) - 128
+		case 2:
+			v = int32(int16(binary.LittleEndian.Uint16(data[i:])))
+		case 3:
+			v = int32(uint32(data[i])<<8|uint32(data[i+1])<<16|uint32(data[i+2])<<24) >> 8
+		}
+		f.samples = append(f.samples, v)
+	}
+	if err := f.encoder.Write(f.samples); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (f *FlacPlayer) Close() error {
+	err := f.encoder.Close()
+	if cerr := f.writer.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (f *FlacPlayer) GetSampleRate() int {
+	return f.opts.SampleRate
+}
+
+// --- AiffPlayer ---
+
+// AiffPlayer writes an AIFF file, or an AIFF-C file for float samples. The
+// little-endian samples of the player are converted to big-endian, and 8-bit
+// samples to signed.
+type AiffPlayer struct {
+	writer     io.WriteCloser
+	opts       PlayerOptions
+	headerSize uint32
+	dataSize   uint32
+	buf        []byte
+	comments   []string
+}
+
+func NewAiffPlayer(writer io.WriteCloser, opts PlayerOptions) *AiffPlayer {
+	return &AiffPlayer{writer: writer, opts: opts}
+}
+
+// SetComments sets the comments, of the form NAME=value, that Close writes to
+// an ID3 chunk after the samples.
+func (a *AiffPlayer) SetComments(comments []string) {
+	a.comments = comments
+}
+
+func (a *AiffPlayer) Write(data []byte) (int, error) {
+	if a.headerSize == 0 {
+		a.writeAiffHeader()
+	}
+	a.buf = append(a.buf[:0], data...)
+	size := a.opts.BitDepth
+	for i := 0; i+size <= len(a.buf); i += size {
+		if size == 1 {
+			a.buf[i] ^= 0x80
+		}
+		slices.Reverse(a.buf[i : i+size])
+	}
+	n, err := a.writer.Write(a.buf)
+	a.dataSize += uint32(n)
+	return n, err
+}
+
+func (a *AiffPlayer) Close() error {
+	if a.headerSize == 0 {
+		a.writeAiffHeader()
+	}
+	// Chunks are padded to an even size.
+	formSize := a.headerSize - 8 + a.dataSize
+	if a.dataSize%2 != 0 {
+		a.writer.Write([]byte{0})
+		formSize++
+	}
+	if len(a.comments) > 0 {
+		tag := id3Tag(commentFrames(a.comments))
+		writeID3Chunk(a.writer, binary.BigEndian, "ID3 ", tag)
+		formSize += uint32(8 + len(tag) + len(tag)%2)
+	}
+	if seeker, ok := a.writer.(io.Seeker); ok {
+		seeker.Seek(4, io.SeekStart)
+		binary.Write(a.writer, binary.BigEndian, formSize)
+		seeker.Seek(int64(a.commOffset()+10), io.SeekStart)
+		binary.Write(a.writer, binary.BigEndian, a.dataSize/uint32(a.opts.NumChannels*a.opts.BitDepth)) // Sample frames
+		seeker.Seek(int64(a.headerSize-12), io.SeekStart)
+		binary.Write(a.writer, binary.BigEndian, a.dataSize+8)
+	}
+	return a.writer.Close()
+}
+
+func (a *AiffPlayer) GetSampleRate() int {
+	return a.opts.SampleRate
+}
+
+// commOffset returns the offset of the "COMM" chunk, which follows the "FVER"
+// chunk of AIFF-C files.
+func (a *AiffPlayer) commOffset() int {
+	if a.opts.Float {
+		return 24
+	}
+	return 12
+}
+
+// aifcVersion is the timestamp of the AIFF-C specification.
+const aifcVersion = 0xA2805140
+
+func (a *AiffPlayer) writeAiffHeader() {
+	var header bytes.Buffer
+
+	header.WriteString("FORM")
+	binary.Write(&header, binary.BigEndian, uint32(0)) // Placeholder for chunk size
+	if a.opts.Float {
+		header.WriteString("AIFC")
+		header.WriteString("FVER")
+		binary.Write(&header, binary.BigEndian, uint32(4))
+		binary.Write(&header, binary.BigEndian, uint32(aifcVersion))
+	} else {
+		header.WriteString("AIFF")
+	}
+
+	// "COMM" chunk
+	const compressionName = "\x0e32-bit float\x00" // Pascal string padded to an even size
+	header.WriteString("COMM")
+	if a.opts.Float {
+		binary.Write(&header, binary.BigEndian, uint32(18+4+len(compressionName)))
+	} else {
+		binary.Write(&header, binary.BigEndian, uint32(18))
+	}
+	binary.Write(&header, binary.BigEndian, uint16(a.opts.NumChannels))
+	binary.Write(&header, binary.BigEndian, uint32(0)) // Placeholder for sample frames
+	binary.Write(&header, binary.BigEndian, uint16(a.opts.BitDepth*8))
+	header.Write(extendedFloat(float64(a.opts.SampleRate)))
+	if a.opts.Float {
+		header.WriteString("fl32")
+		header.WriteString(compressionName)
+	}
+
+	// "SSND" chunk
+	header.WriteString("SSND")
+	binary.Write(&header, binary.BigEndian, uint32(8)) // Placeholder for chunk size
+	binary.Write(&header, binary.BigEndian, uint32(0)) // Offset
+	binary.Write(&header, binary.BigEndian, uint32(0)) // Block size
+
+	a.headerSize = uint32(header.Len())
+	a.writer.Write(header.Bytes())
+}
+
+// extendedFloat encodes a positive number as an 80-bit IEEE 754 extended
+// precision float, which AIFF uses for the sample rate.
+func extendedFloat(f float64) []byte {
+	b := make([]byte, 10)
+	if f <= 0 {
+		return b
+	}
+	frac, exp := math.Frexp(f) // f = frac * 2^exp with frac in [0.5, 1)
+	binary.BigEndian.PutUint16(b, uint16(16383+exp-1))
+	binary.BigEndian.PutUint64(b[2:], uint64(frac*(1<<64)))
+	return b
 }
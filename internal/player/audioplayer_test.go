@@ -2,6 +2,7 @@ package player
 
 import (
 	"bytes"
+	"encoding/binary"
 	"io"
 	"testing"
 )
@@ -41,3 +42,105 @@ func TestWavPlayer_writeWavHeader(t *testing.T) {
 		t.Errorf("expected 'data' sub-chunk, got %v", buf.Bytes()[36:40])
 	}
 }
+
+func TestWavPlayer_extensible(t *testing.T) {
+	buf := &bytes.Buffer{}
+	opts := DefaultPlayerOptions()
+	opts.BitDepth, opts.Float = 4, true
+	player := NewWavPlayer(nopCloser{buf}, opts)
+	player.Write(make([]byte, 16))
+	player.Close()
+
+	header := buf.Bytes()
+	if format := binary.LittleEndian.Uint16(header[20:]); format != 0xFFFE {
+		t.Errorf("expected WAVE_FORMAT_EXTENSIBLE, got format %#x", format)
+	}
+	if !bytes.Equal(header[44:60], wavSubFormatFloat[:]) {
+		t.Errorf("expected the float sub-format, got %v", header[44:60])
+	}
+	if !bytes.Equal(header[60:64], []byte("fact")) {
+		t.Errorf("expected 'fact' sub-chunk, got %v", header[60:64])
+	}
+	if !bytes.Equal(header[72:76], []byte("data")) {
+		t.Errorf("expected 'data' sub-chunk, got %v", header[72:76])
+	}
+}
+
+func TestAiffPlayer(t *testing.T) {
+	buf := &bytes.Buffer{}
+	player := NewAiffPlayer(nopCloser{buf}, DefaultPlayerOptions())
+	player.Write([]byte{0x01, 0x02, 0x03, 0x04})
+	player.Close()
+
+	data := buf.Bytes()
+	if !bytes.Equal(data[:4], []byte("FORM")) || !bytes.Equal(data[8:12], []byte("AIFF")) {
+		t.Errorf("expected FORM AIFF header, got %v", data[:12])
+	}
+	if !bytes.Equal(data[12:16], []byte("COMM")) {
+		t.Errorf("expected 'COMM' chunk, got %v", data[12:16])
+	}
+	if rate := data[28:38]; !bytes.Equal(rate, []byte{0x40, 0x0e, 0xac, 0x44, 0, 0, 0, 0, 0, 0}) {
+		t.Errorf("expected the sample rate 44100, got %v", rate)
+	}
+	if !bytes.Equal(data[38:42], []byte("SSND")) {
+		t.Errorf("expected 'SSND' chunk, got %v", data[38:42])
+	}
+	if samples := data[54:]; !bytes.Equal(samples, []byte{0x02, 0x01, 0x04, 0x03}) {
+		t.Errorf("expected big-endian samples, got %v", samples)
+	}
+}
+
+func TestFlacPlayer(t *testing.T) {
+	opts := DefaultPlayerOptions()
+	opts.Float = true
+	if _, err := NewFlacPlayer(nopCloser{&bytes.Buffer{}}, opts, nil); err == nil {
+		t.Error("NewFlacPlayer() accepted float samples")
+	}
+
+	buf := &bytes.Buffer{}
+	player, err := NewFlacPlayer(nopCloser{buf}, DefaultPlayerOptions(), []string{"TITLE=test"})
+	if err != nil {
+		t.Fatalf("NewFlacPlayer() failed: %v", err)
+	}
+	if n, err := player.Write(make([]byte, 400)); n != 400 || err != nil {
+		t.Errorf("Write() = %d, %v, want 400, nil", n, err)
+	}
+	if err := player.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("fLaC")) {
+		t.Errorf("expected fLaC marker, got %v", buf.Bytes()[:4])
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("TITLE=test")) {
+		t.Error("expected the TITLE comment")
+	}
+}
+
+// pausingPlayer is an audio player that records Pause and Resume.
+type pausingPlayer struct {
+	*StreamPlayer
+	paused bool
+}
+
+func (p *pausingPlayer) Pause()  { p.paused = true }
+func (p *pausingPlayer) Resume() { p.paused = false }
+
+func TestTeePlayer(t *testing.T) {
+	played, copied := &bytes.Buffer{}, &bytes.Buffer{}
+	sink := &pausingPlayer{StreamPlayer: NewStreamPlayer(nopCloser{played}, DefaultPlayerOptions())}
+	tee := NewTeePlayer(sink, copied)
+	if _, err := tee.Write([]byte("samples")); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	if played.String() != "samples" || copied.String() != "samples" {
+		t.Errorf("played %q and copied %q, want both to be the samples", played, copied)
+	}
+
+	var pauser Pauser = tee
+	if pauser.Pause(); !sink.paused {
+		t.Error("Pause() did not pause the audio player")
+	}
+	if pauser.Resume(); sink.paused {
+		t.Error("Resume() did not resume the audio player")
+	}
+}
@@ -208,3 +208,43 @@ func handleSampleOffset(state *channelState, param byte) {
 	}
 	state.samplePos = float64(state.lastSampleOffset * 256)
 }
+
+// applyRetrigVolume applies the volume change of a multi retrig (Rxy/Qxy) command.
+func applyRetrigVolume(state *channelState, x byte) {
+	switch x {
+	case 1:
+		state.volume -= 1.0 / 64.0
+	case 2:
+		state.volume -= 2.0 / 64.0
+	case 3:
+		state.volume -= 4.0 / 64.0
+	case 4:
+		state.volume -= 8.0 / 64.0
+	case 5:
+		state.volume -= 16.0 / 64.0
+	case 6:
+		state.volume = state.volume * 2 / 3
+	case 7:
+		state.volume = state.volume / 2
+	case 9:
+		state.volume += 1.0 / 64.0
+	case 0xA:
+		state.volume += 2.0 / 64.0
+	case 0xB:
+		state.volume += 4.0 / 64.0
+	case 0xC:
+		state.volume += 8.0 / 64.0
+	case 0xD:
+		state.volume += 16.0 / 64.0
+	case 0xE:
+		state.volume = state.volume * 3 / 2
+	case 0xF:
+		state.volume = state.volume * 2
+	}
+	if state.volume < 0 {
+		state.volume = 0
+	}
+	if state.volume > 1.0 {
+		state.volume = 1.0
+	}
+}
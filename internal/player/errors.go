@@ -0,0 +1,62 @@
+package player
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// RenderError reports a fault of a ticker while it played a row of the song,
+// such as a module that refers to a missing sample.
+type RenderError struct {
+	Order   int
+	Row     int
+	Channel int // zero based, or -1 if the fault is outside of a channel
+	Err     error
+}
+
+// Error describes the fault and its position. The message numbers channels
+// from one, as trackers show them, so it names channel Channel+1.
+func (e *RenderError) Error() string {
+	if e.Channel < 0 {
+		return fmt.Sprintf("playing order %d, row %d: %v", e.Order, e.Row, e.Err)
+	}
+	return fmt.Sprintf("playing order %d, row %d, channel %d: %v", e.Order, e.Row, e.Channel+1, e.Err)
+}
+
+func (e *RenderError) Unwrap() error {
+	return e.Err
+}
+
+// catchFault is deferred by step to turn a panic of the ticker into a panic
+// of a *RenderError with the position of the row, which recoverFault returns
+// as an error. The stack of the fault is logged, as the error does not carry
+// it.
+func (p *Player) catchFault(state *playerState, order, row int) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if _, ok := r.(*RenderError); ok {
+		panic(r)
+	}
+	err, ok := r.(error)
+	if !ok {
+		err = fmt.Errorf("%v", r)
+	}
+	p.log("Fault playing order %d, row %d: %v\n%s", order, row, err, debug.Stack())
+	panic(&RenderError{Order: order, Row: row, Channel: state.channel, Err: err})
+}
+
+// recoverFault is deferred by the functions that play the song to return the
+// *RenderError of a fault in *err. Other panics are not recovered.
+func recoverFault(err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	renderErr, ok := r.(*RenderError)
+	if !ok {
+		panic(r)
+	}
+	*err = renderErr
+}
@@ -0,0 +1,226 @@
+package player
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+)
+
+// WAV and AIFF files carry their tags in an ID3v2 tag, stored in a chunk of
+// its own. The comments of the form NAME=value, as the Vorbis comments of FLAC
+// files, are stored as the title frame for TITLE and as user defined text
+// frames for the others, which is where ReplayGain readers look for them.
+
+// id3Frame is a frame of an ID3v2.4 tag, without its header.
+type id3Frame struct {
+	id   string
+	body []byte
+}
+
+// id3Tag returns an ID3v2.4 tag of frames.
+func id3Tag(frames []id3Frame) []byte {
+	var body bytes.Buffer
+	for _, f := range frames {
+		body.WriteString(f.id)
+		body.Write(synchsafe(len(f.body)))
+		body.Write([]byte{0, 0}) // Flags
+		body.Write(f.body)
+	}
+	var tag bytes.Buffer
+	tag.WriteString("ID3")
+	tag.Write([]byte{4, 0, 0}) // Version 2.4.0, no flags
+	tag.Write(synchsafe(body.Len()))
+	tag.Write(body.Bytes())
+	return tag.Bytes()
+}
+
+// parseID3Tag returns the frames of an ID3v2.3 or ID3v2.4 tag.
+func parseID3Tag(tag []byte) ([]id3Frame, error) {
+	if len(tag) < 10 || string(tag[:3]) != "ID3" || tag[3] < 3 || tag[3] > 4 {
+		return nil, errors.New("unsupported ID3 tag")
+	}
+	version, flags := tag[3], tag[5]
+	if flags&0x80 != 0 {
+		return nil, errors.New("unsynchronised ID3 tags are not supported")
+	}
+	data := tag[10:min(10+unsynchsafe(tag[6:10]), len(tag))]
+	if flags&0x40 != 0 && len(data) >= 4 { // Extended header
+		size := int(binary.BigEndian.Uint32(data))
+		if version == 4 {
+			size = unsynchsafe(data[:4])
+		} else {
+			size += 4
+		}
+		data = data[min(size, len(data)):]
+	}
+
+	var frames []id3Frame
+	for len(data) >= 10 && data[0] != 0 { // Padding follows the frames
+		size := int(binary.BigEndian.Uint32(data[4:8]))
+		if version == 4 {
+			size = unsynchsafe(data[4:8])
+		}
+		if 10+size > len(data) {
+			return nil, errors.New("truncated ID3 frame")
+		}
+		frames = append(frames, id3Frame{id: string(data[:4]), body: data[10 : 10+size]})
+		data = data[10+size:]
+	}
+	return frames, nil
+}
+
+// id3Comments splits frames into the comments of the title and the user
+// defined text frames in UTF-8 or ISO-8859-1, and the other frames.
+func id3Comments(frames []id3Frame) (comments []string, others []id3Frame) {
+	for _, f := range frames {
+		if len(f.body) == 0 || f.body[0] != 0 && f.body[0] != 3 {
+			others = append(others, f)
+			continue
+		}
+		text := f.body[1:]
+		if f.body[0] == 0 {
+			runes := make([]rune, len(text))
+			for i, b := range text {
+				runes[i] = rune(b)
+			}
+			text = []byte(string(runes))
+		}
+		switch f.id {
+		case "TIT2":
+			comments = append(comments, "TITLE="+strings.TrimRight(string(text), "\x00"))
+		case "TXXX":
+			name, value, _ := strings.Cut(string(text), "\x00")
+			comments = append(comments, name+"="+strings.TrimRight(value, "\x00"))
+		default:
+			others = append(others, f)
+		}
+	}
+	return comments, others
+}
+
+// commentFrames returns the frames of comments, in UTF-8.
+func commentFrames(comments []string) []id3Frame {
+	var frames []id3Frame
+	for _, c := range comments {
+		name, value, _ := strings.Cut(c, "=")
+		if strings.EqualFold(name, "TITLE") {
+			frames = append(frames, id3Frame{"TIT2", append([]byte{3}, value...)})
+			continue
+		}
+		frames = append(frames, id3Frame{"TXXX", []byte("\x03" + name + "\x00" + value)})
+	}
+	return frames
+}
+
+func synchsafe(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7F), byte(n >> 14 & 0x7F), byte(n >> 7 & 0x7F), byte(n & 0x7F)}
+}
+
+func unsynchsafe(b []byte) int {
+	return int(b[0]&0x7F)<<21 | int(b[1]&0x7F)<<14 | int(b[2]&0x7F)<<7 | int(b[3]&0x7F)
+}
+
+// UpdateComments copies the WAV or AIFF file of r to w with its comments
+// replaced by the result of update, which is given the current comments. The
+// comments are kept in an ID3 chunk, which is added after the other chunks if
+// the file has none. The frames of the tag that are not comments, and the
+// other chunks, are copied unchanged.
+func UpdateComments(r io.ReadSeeker, w io.Writer, update func(comments []string) []string) error {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+	var order binary.ByteOrder
+	var id3ChunkID string
+	switch string(header[:4]) {
+	case "RIFF":
+		order, id3ChunkID = binary.LittleEndian, "id3 "
+	case "FORM":
+		order, id3ChunkID = binary.BigEndian, "ID3 "
+	default:
+		return errors.New("not a WAV or AIFF file")
+	}
+
+	// Find the chunks, and read the tag.
+	type chunk struct {
+		offset int64 // of the chunk header
+		size   int64 // of the chunk with its header and padding
+	}
+	var chunks []chunk
+	var tag []byte
+	formEnd := 8 + int64(order.Uint32(header[4:8]))
+	for offset := int64(12); offset+8 <= formEnd; {
+		chunkHeader := make([]byte, 8)
+		if _, err := io.ReadFull(r, chunkHeader); err != nil {
+			return err
+		}
+		size := int64(order.Uint32(chunkHeader[4:]))
+		if string(chunkHeader[:4]) == id3ChunkID {
+			tag = make([]byte, size)
+			if _, err := io.ReadFull(r, tag); err != nil {
+				return err
+			}
+			if _, err := r.Seek(size%2, io.SeekCurrent); err != nil {
+				return err
+			}
+		} else {
+			chunks = append(chunks, chunk{offset, 8 + size + size%2})
+			if _, err := r.Seek(offset+8+size+size%2, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		offset += 8 + size + size%2
+	}
+
+	if len(chunks) == 0 {
+		return errors.New("file has no chunks, or its sizes were never written")
+	}
+
+	var comments []string
+	var frames []id3Frame
+	if tag != nil {
+		tagFrames, err := parseID3Tag(tag)
+		if err != nil {
+			return err
+		}
+		comments, frames = id3Comments(tagFrames)
+	}
+	tag = id3Tag(append(frames, commentFrames(update(comments))...))
+
+	formSize := int64(4 + 8 + len(tag) + len(tag)%2)
+	for _, c := range chunks {
+		formSize += c.size
+	}
+	if formSize > 1<<32-1 {
+		return errors.New("file too large to tag")
+	}
+	order.PutUint32(header[4:8], uint32(formSize))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		if _, err := r.Seek(c.offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(w, r, c.size); err != nil {
+			return err
+		}
+	}
+	return writeID3Chunk(w, order, id3ChunkID, tag)
+}
+
+// writeID3Chunk writes a chunk holding tag, padded to an even size, and
+// returns the error of w.
+func writeID3Chunk(w io.Writer, order binary.ByteOrder, id string, tag []byte) error {
+	chunk := make([]byte, 8, 8+len(tag)+1)
+	copy(chunk, id)
+	order.PutUint32(chunk[4:], uint32(len(tag)))
+	chunk = append(chunk, tag...)
+	if len(tag)%2 != 0 {
+		chunk = append(chunk, 0)
+	}
+	_, err := w.Write(chunk)
+	return err
+}
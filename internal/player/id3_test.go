@@ -0,0 +1,121 @@
+package player
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// seekBuffer is an in-memory file, which players seek in to write their sizes.
+type seekBuffer struct {
+	data []byte
+	pos  int
+}
+
+func (b *seekBuffer) Write(p []byte) (int, error) {
+	if end := b.pos + len(p); end > len(b.data) {
+		b.data = append(b.data, make([]byte, end-len(b.data))...)
+	}
+	b.pos += copy(b.data[b.pos:], p)
+	return len(p), nil
+}
+
+func (b *seekBuffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += int64(b.pos)
+	case io.SeekEnd:
+		offset += int64(len(b.data))
+	}
+	b.pos = int(offset)
+	return offset, nil
+}
+
+func (b *seekBuffer) Close() error { return nil }
+
+// readComments returns the comments of a WAV or AIFF file.
+func readComments(t *testing.T, file []byte) []string {
+	t.Helper()
+	var comments []string
+	err := UpdateComments(bytes.NewReader(file), io.Discard, func(c []string) []string {
+		comments = c
+		return c
+	})
+	if err != nil {
+		t.Fatalf("UpdateComments() error = %v", err)
+	}
+	return comments
+}
+
+func TestUpdateComments(t *testing.T) {
+	tests := []struct {
+		name  string
+		order binary.ByteOrder
+		write func(w io.WriteCloser, opts PlayerOptions, comments []string) AudioPlayer
+	}{
+		{"WAV", binary.LittleEndian, func(w io.WriteCloser, opts PlayerOptions, comments []string) AudioPlayer {
+			p := NewWavPlayer(w, opts)
+			p.SetComments(comments)
+			return p
+		}},
+		{"AIFF", binary.BigEndian, func(w io.WriteCloser, opts PlayerOptions, comments []string) AudioPlayer {
+			p := NewAiffPlayer(w, opts)
+			p.SetComments(comments)
+			return p
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultPlayerOptions()
+			opts.BitDepth, opts.NumChannels = 1, 1
+			comments := []string{"TITLE=space débris", "TRACKER=Protracker", "REPLAYGAIN_TRACK_GAIN=-1.00 dB"}
+			file := &seekBuffer{}
+			p := tt.write(file, opts, comments)
+			p.Write([]byte{1, 2, 3}) // An odd size, which is padded
+			p.Close()
+			if size := tt.order.Uint32(file.data[4:]); int(size) != len(file.data)-8 {
+				t.Fatalf("form size = %d, want %d", size, len(file.data)-8)
+			}
+			if got := readComments(t, file.data); !slices.Equal(got, comments) {
+				t.Fatalf("comments = %q, want %q", got, comments)
+			}
+
+			var tagged bytes.Buffer
+			err := UpdateComments(bytes.NewReader(file.data), &tagged, func(c []string) []string {
+				c = slices.DeleteFunc(c, func(c string) bool { return strings.HasPrefix(c, "REPLAYGAIN_") })
+				return append(c, "REPLAYGAIN_TRACK_GAIN=+2.50 dB")
+			})
+			if err != nil {
+				t.Fatalf("UpdateComments() error = %v", err)
+			}
+			want := []string{"TITLE=space débris", "TRACKER=Protracker", "REPLAYGAIN_TRACK_GAIN=+2.50 dB"}
+			if got := readComments(t, tagged.Bytes()); !slices.Equal(got, want) {
+				t.Errorf("updated comments = %q, want %q", got, want)
+			}
+			if size := tt.order.Uint32(tagged.Bytes()[4:]); int(size) != tagged.Len()-8 {
+				t.Errorf("updated form size = %d, want %d", size, tagged.Len()-8)
+			}
+			// The chunks before the tag, with the samples, are unchanged.
+			tagAt := bytes.Index(file.data, []byte("ID3\x04"))
+			if !bytes.Equal(tagged.Bytes()[8:tagAt-8], file.data[8:tagAt-8]) {
+				t.Error("updated file changed the chunks of the samples")
+			}
+		})
+	}
+}
+
+func TestUpdateComments_untagged(t *testing.T) {
+	file := &seekBuffer{}
+	p := NewWavPlayer(file, DefaultPlayerOptions())
+	p.Write(make([]byte, 8))
+	p.Close()
+	if got := readComments(t, file.data); len(got) != 0 {
+		t.Errorf("comments of an untagged file = %q, want none", got)
+	}
+	if err := UpdateComments(bytes.NewReader([]byte("fLaC\x00\x00\x00\x00\x00\x00\x00\x00")), io.Discard, nil); err == nil {
+		t.Error("UpdateComments() of a FLAC file = nil, want an error")
+	}
+}
@@ -0,0 +1,142 @@
+package player
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Interpolation selects how sample data is resampled to the output rate.
+type Interpolation int
+
+const (
+	// InterpolationNearest plays the nearest sample point, which gives the
+	// gritty sound of early trackers and the Amiga.
+	InterpolationNearest Interpolation = iota
+	// InterpolationLinear blends the two surrounding sample points.
+	InterpolationLinear
+	// InterpolationCubic uses a cubic Hermite spline through four points.
+	InterpolationCubic
+	// InterpolationSinc uses a windowed sinc filter of sincTaps points.
+	InterpolationSinc
+)
+
+var interpolationNames = []string{"nearest", "linear", "cubic", "sinc"}
+
+func (m Interpolation) String() string {
+	if m < 0 || int(m) >= len(interpolationNames) {
+		return fmt.Sprintf("Interpolation(%d)", int(m))
+	}
+	return interpolationNames[m]
+}
+
+// ParseInterpolation returns the interpolation mode with the given name.
+func ParseInterpolation(name string) (Interpolation, error) {
+	for i, n := range interpolationNames {
+		if strings.EqualFold(name, n) {
+			return Interpolation(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown interpolation %q, want one of %s", name, strings.Join(interpolationNames, ", "))
+}
+
+// sampleReader reads the points of one channel of sample data, following the
+// sample loop so that interpolation across the loop end uses the points that
+// are played next.
+type sampleReader struct {
+	data     []int16
+	stride   int // number of interleaved channels in data
+	offset   int // channel read from data
+	length   int // in sample points
+	loopBeg  int
+	loopEnd  int // greater than loopBeg when the sample loops
+	pingPong bool
+}
+
+func newSampleReader(data []int16, length int) sampleReader {
+	return sampleReader{data: data, stride: 1, length: min(length, len(data))}
+}
+
+// withLoop returns r looping from start to end.
+func (r sampleReader) withLoop(start, end int, pingPong bool) sampleReader {
+	r.loopBeg, r.loopEnd, r.pingPong = start, min(end, r.length), pingPong
+	return r
+}
+
+// at returns the sample point at index i, or silence outside the sample.
+func (r *sampleReader) at(i int) float64 {
+	if n := r.loopEnd - r.loopBeg; n > 0 && i >= r.loopEnd {
+		k := i - r.loopBeg
+		if r.pingPong {
+			k %= 2 * n
+			if k >= n {
+				k = 2*n - 1 - k
+			}
+		} else {
+			k %= n
+		}
+		i = r.loopBeg + k
+	}
+	if i < 0 || i >= r.length {
+		return 0
+	}
+	return float64(r.data[i*r.stride+r.offset])
+}
+
+// interpolate returns the value of the sample at the fractional position pos.
+func (m Interpolation) interpolate(r *sampleReader, pos float64) float64 {
+	i := int(math.Floor(pos))
+	frac := pos - float64(i)
+	switch m {
+	case InterpolationNearest:
+		return r.at(i)
+	case InterpolationCubic:
+		y0, y1, y2, y3 := r.at(i-1), r.at(i), r.at(i+1), r.at(i+2)
+		c1 := 0.5 * (y2 - y0)
+		c2 := y0 - 2.5*y1 + 2*y2 - 0.5*y3
+		c3 := 0.5*(y3-y0) + 1.5*(y1-y2)
+		return ((c3*frac+c2)*frac+c1)*frac + y1
+	case InterpolationSinc:
+		kernel := sincTable[int(frac*sincPhases)]
+		var sum float64
+		for k, w := range kernel {
+			sum += w * r.at(i+k-sincTaps/2+1)
+		}
+		return sum
+	}
+	y1 := r.at(i)
+	return y1 + (r.at(i+1)-y1)*frac
+}
+
+const (
+	// sincTaps is the number of sample points used by the sinc filter.
+	sincTaps = 16
+	// sincPhases is the number of fractional positions of sincTable.
+	sincPhases = 1024
+)
+
+// sincTable holds the sinc filter for each fractional position, windowed by a
+// Blackman window and normalized to unity gain. The filter does not adapt its
+// cutoff when samples play above the output rate.
+var sincTable = func() [][sincTaps]float64 {
+	table := make([][sincTaps]float64, sincPhases)
+	for phase := range table {
+		frac := float64(phase) / sincPhases
+		var sum float64
+		for k := range sincTaps {
+			x := float64(k-sincTaps/2+1) - frac
+			w := 1.0
+			if x != 0 {
+				w = math.Sin(math.Pi*x) / (math.Pi * x)
+			}
+			n := (x + sincTaps/2) / sincTaps
+			w *= 0.42 - 0.5*math.Cos(2*math.Pi*n) + 0.08*math.Cos(4*math.Pi*n)
+			table[phase][k] = w
+			sum += w
+		}
+		for k := range sincTaps {
+			table[phase][k] /= sum
+		}
+	}
+	return table
+}()
@@ -0,0 +1,49 @@
+package player
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseInterpolation(t *testing.T) {
+	for _, m := range []Interpolation{InterpolationNearest, InterpolationLinear, InterpolationCubic, InterpolationSinc} {
+		got, err := ParseInterpolation(m.String())
+		if err != nil || got != m {
+			t.Errorf("ParseInterpolation(%q) = %v, %v, want %v", m.String(), got, err, m)
+		}
+	}
+	if _, err := ParseInterpolation("fft"); err == nil {
+		t.Error("ParseInterpolation(\"fft\") succeeded")
+	}
+}
+
+func TestInterpolation_interpolate(t *testing.T) {
+	data := []int16{0, 100, 200, 300, 400, 500, 600, 700}
+	reader := newSampleReader(data, len(data))
+
+	for _, m := range []Interpolation{InterpolationNearest, InterpolationLinear, InterpolationCubic, InterpolationSinc} {
+		if got := m.interpolate(&reader, 3); math.Abs(got-300) > 1 {
+			t.Errorf("%v.interpolate(3) = %v, want 300", m, got)
+		}
+	}
+	if got := InterpolationNearest.interpolate(&reader, 3.5); got != 300 {
+		t.Errorf("nearest.interpolate(3.5) = %v, want 300", got)
+	}
+	for _, m := range []Interpolation{InterpolationLinear, InterpolationCubic} {
+		if got := m.interpolate(&reader, 3.5); got != 350 {
+			t.Errorf("%v.interpolate(3.5) = %v, want 350", m, got)
+		}
+	}
+
+	looped := reader.withLoop(2, 6, false)
+	if got := looped.at(6); got != 200 {
+		t.Errorf("at(6) of a forward loop = %v, want 200", got)
+	}
+	pingPong := reader.withLoop(2, 6, true)
+	if got := pingPong.at(6); got != 500 {
+		t.Errorf("at(6) of a ping-pong loop = %v, want 500", got)
+	}
+	if got := reader.at(8); got != 0 {
+		t.Errorf("at(8) past the end = %v, want 0", got)
+	}
+}
@@ -0,0 +1,953 @@
+package player
+
+import (
+	"math"
+	"math/rand/v2"
+
+	"github.com/jesseward/impulse/pkg/it"
+	"github.com/jesseward/impulse/pkg/module"
+)
+
+// Impulse Tracker pitches are tracked as linear periods with 256 units per
+// semitone, so that every slide, vibrato and envelope works on the same scale.
+// Period 4096 is B-9 and larger periods are lower notes.
+const (
+	itSemitone           = 256
+	itPeriodB9           = 4096
+	itPeriodC5           = itPeriodB9 + 59*itSemitone
+	itMaxVirtualChannels = 64
+)
+
+// itPortaTable maps the volume column tone portamento (gx) onto Gxx speeds.
+var itPortaTable = [10]byte{0, 1, 4, 8, 16, 32, 64, 96, 128, 255}
+
+// itNotePeriod returns the linear period of a note (0 = C-0, 119 = B-9).
+func itNotePeriod(note int) uint16 {
+	return uint16(itPeriodB9 + (119-note)*itSemitone)
+}
+
+// itFrequency returns the playback frequency of a period for a sample with the given C-5 speed.
+func itFrequency(period uint16, c5Speed uint32) float64 {
+	if c5Speed == 0 {
+		c5Speed = 8363
+	}
+	return float64(c5Speed) * math.Pow(2, float64(itPeriodC5-int(period))/(12*itSemitone))
+}
+
+// itPeriodFromFrequency is the inverse of itFrequency.
+func itPeriodFromFrequency(freq float64, c5Speed uint32) int {
+	if c5Speed == 0 {
+		c5Speed = 8363
+	}
+	return itPeriodC5 - int(math.Round(12*itSemitone*math.Log2(freq/float64(c5Speed))))
+}
+
+// itWaveform returns the value (-64 to 64) of a vibrato, tremolo or panbrello
+// waveform at pos, where a full cycle is 256 positions. The random waveform
+// draws from rnd.
+func itWaveform(rnd *rand.Rand, wave byte, pos uint8) int {
+	switch wave & 3 {
+	case 1: // Ramp down
+		return 64 - int(pos)/2
+	case 2: // Square
+		if pos < 128 {
+			return 64
+		}
+		return -64
+	case 3: // Random
+		return rnd.IntN(129) - 64
+	default: // Sine
+		return int(math.Round(64 * math.Sin(2*math.Pi*float64(pos)/256)))
+	}
+}
+
+// itEnvelope returns the value of an envelope at pos, the position of the
+// next tick and whether the envelope has reached its final node.
+func itEnvelope(env *it.Envelope, pos uint16, sustained bool) (int, uint16, bool) {
+	nodes := env.Nodes[:min(int(env.NumNodes), len(env.Nodes))]
+	last := nodes[len(nodes)-1]
+
+	value := int(last.Value)
+	for i := 0; i+1 < len(nodes); i++ {
+		if pos >= nodes[i].Tick && pos < nodes[i+1].Tick {
+			span := int(nodes[i+1].Tick) - int(nodes[i].Tick)
+			value = int(nodes[i].Value) + (int(nodes[i+1].Value)-int(nodes[i].Value))*int(pos-nodes[i].Tick)/span
+			break
+		}
+	}
+	if pos < nodes[0].Tick {
+		value = int(nodes[0].Value)
+	}
+
+	next := pos + 1
+	switch {
+	case sustained && env.HasSustainLoop() && int(env.SustainEnd) < len(nodes):
+		if next > nodes[env.SustainEnd].Tick {
+			next = nodes[env.SustainBegin].Tick
+		}
+	case env.HasLoop() && int(env.LoopEnd) < len(nodes):
+		if next > nodes[env.LoopEnd].Tick {
+			next = nodes[env.LoopBegin].Tick
+		}
+	case pos >= last.Tick:
+		return value, last.Tick, true
+	}
+	return value, next, false
+}
+
+// itFilterCoefficients returns the coefficients of the IT resonant low-pass
+// filter, or false if the filter is inactive.
+func itFilterCoefficients(cutoff, resonance byte, modifier, sampleRate int) (float64, float64, float64, bool) {
+	if cutoff >= 127 && resonance == 0 && modifier == 256 {
+		return 0, 0, 0, false
+	}
+	fc := 110.0 * math.Pow(2, 0.25+float64(int(cutoff)*(modifier+256))/(24*512))
+	fc = math.Max(120, math.Min(fc, math.Min(20000, float64(sampleRate)/2)))
+
+	dmpfac := math.Pow(10, -(24.0/128.0)*float64(resonance)/20)
+	r := float64(sampleRate) / (2 * math.Pi * fc)
+	d := dmpfac*r + dmpfac - 1
+	e := r * r
+	return 1 / (1 + d + e), (d + 2*e) / (1 + d + e), -e / (1 + d + e), true
+}
+
+// itVolumeSlide applies a Dxy style slide to value, which is kept in the range 0-1.
+// DxF and DFy are fine slides applied on the first tick only.
+func itVolumeSlide(value *float64, param byte, memory *byte, tick int, scale float64) {
+	if param == 0 {
+		param = *memory
+	} else {
+		*memory = param
+	}
+	x := float64(param >> 4)
+	y := float64(param & 0x0F)
+	switch {
+	case param&0x0F == 0x0F && param>>4 > 0:
+		if tick == 0 {
+			*value += x / scale
+		}
+	case param>>4 == 0x0F && param&0x0F > 0:
+		if tick == 0 {
+			*value -= y / scale
+		}
+	case param&0x0F == 0:
+		if tick > 0 {
+			*value += x / scale
+		}
+	case param>>4 == 0:
+		if tick > 0 {
+			*value -= y / scale
+		}
+	}
+	*value = math.Max(0, math.Min(*value, 1.0))
+}
+
+type ITTicker struct{}
+
+func (t *ITTicker) initState(p *Player, playerState *playerState) {
+	mod, ok := p.module.(*it.Module)
+	if !ok {
+		return
+	}
+	playerState.globalVolume = float64(min(int(mod.Header.GlobalVolume), 128)) / 128.0
+	for i := range playerState.channels {
+		state := &playerState.channels[i]
+		state.channel = i
+		state.fadeoutVolume = 1024
+		state.filterCutoff = 127
+		state.filterModifier = 256
+		if i >= len(mod.Header.ChannelPan) {
+			continue
+		}
+		pan := mod.Header.ChannelPan[i]
+		state.channelVolume = float64(min(int(mod.Header.ChannelVolume[i]), 64)) / 64.0
+		if pan&0x80 != 0 { // Disabled channel
+			state.channelVolume = 0
+		}
+		if pan&0x7F == 100 {
+			state.surround = true
+		} else {
+			state.panning = float64(min(int(pan&0x7F), 64)) / 64.0
+		}
+	}
+}
+
+func (t *ITTicker) ProcessTick(p *Player, playerState *playerState, channelState *channelState, cell *module.Cell, speed, bpm, nextRow, nextOrder, currentOrder *int, tick int) {
+	mod, ok := p.module.(*it.Module)
+	if !ok {
+		return
+	}
+
+	channelState.vibratoDelta = 0
+	channelState.arpeggioDelta = 0
+	channelState.tremoloDelta = 0
+	channelState.panbrelloDelta = 0
+	channelState.tremorMute = false
+
+	noteDelay := 0
+	if cell.Effect == 19 && cell.EffectParam>>4 == 0x0D {
+		noteDelay = int(cell.EffectParam & 0x0F)
+	}
+	firstTick := tick == noteDelay
+	if firstTick {
+		t.handleNote(mod, playerState, channelState, cell)
+	}
+	t.handleVolumeColumn(mod, playerState, channelState, cell, tick, firstTick)
+	t.handleEffect(mod, playerState, channelState, cell, speed, bpm, nextRow, nextOrder, currentOrder, tick, firstTick)
+	t.updateVoice(mod, playerState, channelState)
+}
+
+// processVirtualTick advances a background voice that no longer responds to pattern data.
+func (t *ITTicker) processVirtualTick(p *Player, playerState *playerState, channelState *channelState, tick int) {
+	mod, ok := p.module.(*it.Module)
+	if !ok {
+		return
+	}
+	channelState.vibratoDelta = 0
+	channelState.arpeggioDelta = 0
+	channelState.tremoloDelta = 0
+	channelState.panbrelloDelta = 0
+	channelState.tremorMute = false
+	t.updateVoice(mod, playerState, channelState)
+}
+
+// instrument returns the instrument playing on a channel, or nil in sample mode.
+func (t *ITTicker) instrument(mod *it.Module, state *channelState) *it.Instrument {
+	if !mod.UsesInstruments() {
+		return nil
+	}
+	return mod.Instrument(state.sampleIndex)
+}
+
+// resolveNote maps a played note through the instrument keyboard table. In
+// sample mode the instrument number selects the sample directly.
+func (t *ITTicker) resolveNote(mod *it.Module, instrument, note int) (int, *it.Sample, *it.Instrument) {
+	if !mod.UsesInstruments() {
+		return note, mod.Sample(instrument), nil
+	}
+	ins := mod.Instrument(instrument)
+	if ins == nil || note < 0 || note >= len(ins.Keyboard) {
+		return note, nil, nil
+	}
+	entry := ins.Keyboard[note]
+	return int(entry.Note), mod.Sample(int(entry.Sample)), ins
+}
+
+func (t *ITTicker) handleNote(mod *it.Module, playerState *playerState, state *channelState, cell *module.Cell) {
+	instrument := int(cell.Instrument)
+	if instrument == 0 {
+		instrument = state.sampleIndex
+	}
+
+	switch {
+	case cell.Note >= 1 && cell.Note <= 120:
+		note, smp, ins := t.resolveNote(mod, instrument, int(cell.Note)-1)
+		if smp == nil || len(smp.Data()) == 0 {
+			return
+		}
+		porta := cell.Effect == 7 || cell.Effect == 12 || (cell.Volume >= 193 && cell.Volume <= 202)
+		if porta && state.sample != nil {
+			state.tonePortaTarget = itNotePeriod(note)
+			state.itNote = note
+			if cell.Instrument > 0 {
+				state.sampleIndex = instrument
+				state.volume = float64(state.sample.Volume()) / 64.0
+			}
+			return
+		}
+		t.newNoteAction(mod, playerState, state)
+		t.triggerNote(state, note, smp, ins, instrument, cell.Instrument > 0)
+		t.duplicateCheck(mod, playerState, state, ins)
+	case cell.Note == it.NoteOff:
+		t.keyOff(mod, state)
+	case cell.Note == it.NoteCut:
+		state.sample = nil
+	case cell.Note == it.NoteFade:
+		state.fading = true
+	case cell.Instrument > 0 && state.sample != nil:
+		state.sampleIndex = instrument
+		state.volume = float64(state.sample.Volume()) / 64.0
+	}
+}
+
+// newNoteAction moves the voice playing on a channel to the background,
+// according to its New Note Action, before a new note replaces it.
+func (t *ITTicker) newNoteAction(mod *it.Module, playerState *playerState, state *channelState) {
+	if state.sample == nil || state.nna == it.NNACut {
+		return
+	}
+	voice := *state
+	switch state.nna {
+	case it.NNANoteOff:
+		t.keyOff(mod, &voice)
+	case it.NNANoteFade:
+		voice.fading = true
+	}
+	if voice.sample == nil {
+		return
+	}
+	playerState.virtualChannels = append(playerState.virtualChannels, voice)
+	if len(playerState.virtualChannels) > itMaxVirtualChannels {
+		quietest := 0
+		for i, v := range playerState.virtualChannels {
+			if v.outVolume < playerState.virtualChannels[quietest].outVolume {
+				quietest = i
+			}
+		}
+		playerState.virtualChannels = append(playerState.virtualChannels[:quietest], playerState.virtualChannels[quietest+1:]...)
+	}
+}
+
+func (t *ITTicker) triggerNote(state *channelState, note int, smp *it.Sample, ins *it.Instrument, instrument int, resetVolume bool) {
+	state.sample = smp
+	state.sampleIndex = instrument
+	state.itNote = note
+	state.period = itNotePeriod(note)
+	state.notePeriod = state.period
+	state.tonePortaTarget = 0
+	state.samplePos = 0
+	state.reverse = false
+	state.sustained = true
+	state.fading = false
+	state.fadeoutVolume = 1024
+	state.volumeEnvelopePos = 0
+	state.panningEnvelopePos = 0
+	state.pitchEnvelopePos = 0
+	state.autovibratoPos = 0
+	state.autovibratoDepth = 0
+	state.retrigCount = 0
+	state.filterY1 = 0
+	state.filterY2 = 0
+	if resetVolume {
+		state.volume = float64(smp.Volume()) / 64.0
+	}
+
+	state.nna = it.NNACut
+	state.volumeEnvelopeOn = false
+	state.panningEnvelopeOn = false
+	state.pitchEnvelopeOn = false
+	if ins != nil {
+		state.nna = ins.NewNoteAction
+		state.volumeEnvelopeOn = ins.VolumeEnvelope.Enabled()
+		state.panningEnvelopeOn = ins.PanningEnvelope.Enabled()
+		state.pitchEnvelopeOn = ins.PitchEnvelope.Enabled()
+		if ins.DefaultPan&0x80 == 0 {
+			state.panning = float64(min(int(ins.DefaultPan), 64)) / 64.0
+			state.surround = false
+		}
+		if ins.FilterCutoff&0x80 != 0 {
+			state.filterCutoff = ins.FilterCutoff & 0x7F
+		}
+		if ins.FilterResonance&0x80 != 0 {
+			state.filterResonance = ins.FilterResonance & 0x7F
+		}
+	}
+	if smp.DefaultPan&0x80 != 0 {
+		state.panning = float64(min(int(smp.DefaultPan&0x7F), 64)) / 64.0
+		state.surround = false
+	}
+	if ins != nil && ins.PitchPanSeparation != 0 {
+		state.panning += float64(note-int(ins.PitchPanCenter)) * float64(ins.PitchPanSeparation) / 8 / 64
+		state.panning = math.Max(0, math.Min(state.panning, 1.0))
+	}
+}
+
+// duplicateCheck applies the Duplicate Check Action of an instrument to the
+// background voices of the channel that match the newly triggered note.
+func (t *ITTicker) duplicateCheck(mod *it.Module, playerState *playerState, state *channelState, ins *it.Instrument) {
+	if ins == nil || ins.DuplicateCheckType == it.DCTOff {
+		return
+	}
+	for i := range playerState.virtualChannels {
+		voice := &playerState.virtualChannels[i]
+		if voice.channel != state.channel || voice.sampleIndex != state.sampleIndex || voice.sample == nil {
+			continue
+		}
+		var duplicate bool
+		switch ins.DuplicateCheckType {
+		case it.DCTNote:
+			duplicate = voice.itNote == state.itNote
+		case it.DCTSample:
+			duplicate = voice.sample == state.sample
+		case it.DCTInstrument:
+			duplicate = true
+		}
+		if duplicate {
+			t.noteAction(mod, voice, ins.DuplicateCheckAction)
+		}
+	}
+}
+
+// noteAction cuts, releases or fades a voice. The actions are numbered as
+// Duplicate Check Actions, which also matches S70-S72.
+func (t *ITTicker) noteAction(mod *it.Module, state *channelState, action byte) {
+	switch action {
+	case it.DCACut:
+		state.sample = nil
+	case it.DCANoteOff:
+		t.keyOff(mod, state)
+	case it.DCANoteFade:
+		state.fading = true
+	}
+}
+
+func (t *ITTicker) keyOff(mod *it.Module, state *channelState) {
+	state.sustained = false
+	ins := t.instrument(mod, state)
+	if ins == nil {
+		if smp, ok := state.sample.(*it.Sample); ok && !smp.HasSustainLoop() {
+			state.sample = nil
+		}
+		return
+	}
+	if !state.volumeEnvelopeOn || ins.VolumeEnvelope.HasLoop() {
+		state.fading = true
+	}
+}
+
+func (t *ITTicker) handleVolumeColumn(mod *it.Module, playerState *playerState, state *channelState, cell *module.Cell, tick int, firstTick bool) {
+	v := cell.Volume
+	switch {
+	case v <= 64: // Set volume
+		if firstTick {
+			state.volume = float64(v) / 64.0
+		}
+	case v <= 84: // ax/bx: Fine volume slide
+		if firstTick {
+			x := (v - 65) % 10
+			if x == 0 {
+				x = state.lastVolColSlide
+			} else {
+				state.lastVolColSlide = x
+			}
+			if v < 75 {
+				state.volume += float64(x) / 64.0
+			} else {
+				state.volume -= float64(x) / 64.0
+			}
+		}
+	case v <= 104: // cx/dx: Volume slide
+		if tick > 0 {
+			x := (v - 85) % 10
+			if x == 0 {
+				x = state.lastVolColSlide
+			} else {
+				state.lastVolColSlide = x
+			}
+			if v < 95 {
+				state.volume += float64(x) / 64.0
+			} else {
+				state.volume -= float64(x) / 64.0
+			}
+		}
+	case v <= 124: // ex/fx: Pitch slide
+		if tick > 0 {
+			x := (v - 105) % 10
+			t.slidePitch(mod, state, int(x)*4*16, v >= 115)
+		}
+	case v >= 128 && v <= 192: // Set panning
+		if firstTick {
+			state.panning = float64(v-128) / 64.0
+			state.surround = false
+		}
+	case v >= 193 && v <= 202: // gx: Tone portamento
+		if speed := itPortaTable[v-193]; speed > 0 {
+			state.portaSpeed = uint16(speed) * 16
+		}
+		if tick > 0 {
+			t.tonePortamento(state)
+		}
+	case v >= 203 && v <= 212: // hx: Vibrato depth
+		if v > 203 {
+			state.vibratoDepth = (v - 203) * 4
+		}
+		t.vibrato(playerState, state, tick)
+	}
+	state.volume = math.Max(0, math.Min(state.volume, 1.0))
+}
+
+func (t *ITTicker) handleEffect(mod *it.Module, playerState *playerState, state *channelState, cell *module.Cell, speed, bpm, nextRow, nextOrder, currentOrder *int, tick int, firstTick bool) {
+	param := cell.EffectParam
+
+	switch cell.Effect {
+	case 1: // Axx: Set speed
+		if tick == 0 && param > 0 {
+			*speed = int(param)
+		}
+	case 2: // Bxx: Jump to order
+		if tick == 0 {
+			*nextOrder = int(param)
+			*nextRow = 0
+		}
+	case 3: // Cxx: Break to row
+		if tick == 0 {
+			if *nextOrder == -1 {
+				*nextOrder = *currentOrder + 1
+			}
+			*nextRow = int(param)
+		}
+	case 4: // Dxy: Volume slide
+		itVolumeSlide(&state.volume, param, &state.lastVolSlide, tick, 64)
+	case 5: // Exx: Portamento down
+		t.portamento(mod, state, param, tick, false)
+	case 6: // Fxx: Portamento up
+		t.portamento(mod, state, param, tick, true)
+	case 7: // Gxx: Tone portamento
+		linked := mod.Header.Flags&it.FlagCompatGxx != 0
+		if param > 0 {
+			state.portaSpeed = uint16(param) * 16
+			if linked {
+				state.lastPorta = param
+			}
+		} else if linked && state.lastPorta > 0 {
+			state.portaSpeed = uint16(state.lastPorta) * 16
+		}
+		if tick > 0 {
+			t.tonePortamento(state)
+		}
+	case 8: // Hxy: Vibrato
+		if param>>4 > 0 {
+			state.vibratoSpeed = param >> 4
+		}
+		if param&0x0F > 0 {
+			state.vibratoDepth = (param & 0x0F) * 4
+		}
+		t.vibrato(playerState, state, tick)
+	case 9: // Ixy: Tremor
+		if param > 0 {
+			state.tremorSpeed = param >> 4
+			state.tremorDepth = param & 0x0F
+		}
+		on := max(int(state.tremorSpeed), 1)
+		off := max(int(state.tremorDepth), 1)
+		state.tremorMute = state.tremorCount%(on+off) >= on
+		state.tremorCount++
+	case 10: // Jxy: Arpeggio
+		if param == 0 {
+			param = state.lastArpeggio
+		} else {
+			state.lastArpeggio = param
+		}
+		switch tick % 3 {
+		case 1:
+			state.arpeggioDelta = int(param>>4) * itSemitone
+		case 2:
+			state.arpeggioDelta = int(param&0x0F) * itSemitone
+		}
+	case 11: // Kxy: Vibrato + Volume slide
+		t.vibrato(playerState, state, tick)
+		itVolumeSlide(&state.volume, param, &state.lastVolSlide, tick, 64)
+	case 12: // Lxy: Tone portamento + Volume slide
+		if tick > 0 {
+			t.tonePortamento(state)
+		}
+		itVolumeSlide(&state.volume, param, &state.lastVolSlide, tick, 64)
+	case 13: // Mxx: Set channel volume
+		if tick == 0 && param <= 64 {
+			state.channelVolume = float64(param) / 64.0
+		}
+	case 14: // Nxy: Channel volume slide
+		itVolumeSlide(&state.channelVolume, param, &state.lastChanVolSlide, tick, 64)
+	case 15: // Oxx: Set sample offset
+		if param > 0 && tick == 0 {
+			state.lastSampleOffset = uint16(param)
+		}
+		if firstTick && cell.Note >= 1 && cell.Note <= 120 && state.sample != nil {
+			offset := int(state.sampleOffsetHigh)<<16 | int(state.lastSampleOffset)<<8
+			if offset < int(state.sample.Length()) {
+				state.samplePos = float64(offset)
+			}
+		}
+	case 16: // Pxy: Panning slide
+		left := 1.0 - state.panning
+		itVolumeSlide(&left, param, &state.lastPanSlide, tick, 64)
+		state.panning = 1.0 - left
+	case 17: // Qxy: Retrig + Volume slide
+		if param == 0 {
+			param = state.lastRetrig
+		} else {
+			state.lastRetrig = param
+		}
+		if interval := int(param & 0x0F); interval > 0 && state.sample != nil {
+			state.retrigCount++
+			if state.retrigCount >= interval {
+				state.retrigCount = 0
+				state.samplePos = 0
+				state.reverse = false
+				applyRetrigVolume(state, param>>4)
+			}
+		}
+	case 18: // Rxy: Tremolo
+		if param>>4 > 0 {
+			state.tremoloSpeed = param >> 4
+		}
+		if param&0x0F > 0 {
+			state.tremoloDepth = param & 0x0F
+		}
+		state.tremoloDelta = float64(itWaveform(playerState.rand, state.tremoloWave, state.tremoloPos)*int(state.tremoloDepth)) / 64 / 64
+		if tick > 0 {
+			state.tremoloPos += state.tremoloSpeed * 4
+		}
+	case 19: // Sxy: Special
+		t.specialEffect(mod, playerState, state, param, nextRow, tick)
+	case 20: // Txx: Set tempo / Tempo slide
+		if param == 0 {
+			param = state.lastTempoSlide
+		} else if param < 0x20 {
+			state.lastTempoSlide = param
+		}
+		switch {
+		case param >= 0x20:
+			if tick == 0 {
+				*bpm = int(param)
+			}
+		case param>>4 == 0:
+			if tick > 0 {
+				*bpm = max(*bpm-int(param&0x0F), 32)
+			}
+		case param>>4 == 1:
+			if tick > 0 {
+				*bpm = min(*bpm+int(param&0x0F), 255)
+			}
+		}
+	case 21: // Uxy: Fine vibrato
+		if param>>4 > 0 {
+			state.vibratoSpeed = param >> 4
+		}
+		if param&0x0F > 0 {
+			state.vibratoDepth = param & 0x0F
+		}
+		t.vibrato(playerState, state, tick)
+	case 22: // Vxx: Set global volume
+		if tick == 0 && param <= 128 {
+			playerState.globalVolume = float64(param) / 128.0
+		}
+	case 23: // Wxy: Global volume slide
+		itVolumeSlide(&playerState.globalVolume, param, &state.lastGlobalVolSlide, tick, 128)
+	case 24: // Xxx: Set panning
+		if tick == 0 {
+			state.panning = float64(param) / 255.0
+			state.surround = false
+		}
+	case 25: // Yxy: Panbrello
+		if param>>4 > 0 {
+			state.panbrelloSpeed = param >> 4
+		}
+		if param&0x0F > 0 {
+			state.panbrelloDepth = param & 0x0F
+		}
+		state.panbrelloDelta = float64(itWaveform(playerState.rand, state.panbrelloWave, state.panbrelloPos)*int(state.panbrelloDepth)) / 32 / 64
+		state.panbrelloPos += state.panbrelloSpeed
+	case 26: // Zxx: Set filter cutoff / resonance
+		if tick == 0 {
+			if param < 0x80 {
+				state.filterCutoff = param
+			} else if param <= 0x8F {
+				state.filterResonance = (param & 0x0F) * 8
+			}
+		}
+	}
+}
+
+func (t *ITTicker) specialEffect(mod *it.Module, playerState *playerState, state *channelState, param byte, nextRow *int, tick int) {
+	if param == 0 {
+		param = state.lastSpecial
+	} else {
+		state.lastSpecial = param
+	}
+	val := param & 0x0F
+
+	switch param >> 4 {
+	case 0x1: // S1x: Glissando control
+		state.glissando = val > 0
+	case 0x3: // S3x: Set vibrato waveform
+		state.vibratoWave = val
+	case 0x4: // S4x: Set tremolo waveform
+		state.tremoloWave = val
+	case 0x5: // S5x: Set panbrello waveform
+		state.panbrelloWave = val
+	case 0x6: // S6x: Fine pattern delay
+		if tick == 0 {
+			playerState.tickDelay += int(val)
+		}
+	case 0x7: // S7x: Instrument control
+		if tick != 0 {
+			return
+		}
+		switch {
+		case val <= 2: // Past note cut / off / fade
+			for i := range playerState.virtualChannels {
+				if playerState.virtualChannels[i].channel == state.channel {
+					t.noteAction(mod, &playerState.virtualChannels[i], val)
+				}
+			}
+		case val <= 6: // Set New Note Action
+			state.nna = val - 3
+		case val == 7, val == 8:
+			state.volumeEnvelopeOn = val == 8
+		case val == 9, val == 0xA:
+			state.panningEnvelopeOn = val == 0xA
+		case val == 0xB, val == 0xC:
+			state.pitchEnvelopeOn = val == 0xC
+		}
+	case 0x8: // S8x: Set panning
+		if tick == 0 {
+			state.panning = float64(val) / 15.0
+			state.surround = false
+		}
+	case 0x9: // S9x: Sound control
+		switch val {
+		case 0:
+			state.surround = false
+		case 1:
+			state.surround = true
+		}
+	case 0xA: // SAx: High sample offset
+		state.sampleOffsetHigh = val
+	case 0xB: // SBx: Pattern loop
+		if tick == 0 {
+			if val == 0 {
+				playerState.patternLoopRow = playerState.row
+			} else {
+				if playerState.patternLoopCount == 0 {
+					playerState.patternLoopCount = int(val)
+					*nextRow = playerState.patternLoopRow
+				} else {
+					playerState.patternLoopCount--
+					if playerState.patternLoopCount > 0 {
+						*nextRow = playerState.patternLoopRow
+					}
+				}
+			}
+		}
+	case 0xC: // SCx: Note cut
+		if tick == max(int(val), 1) {
+			state.sample = nil
+		}
+	case 0xE: // SEx: Pattern delay
+		if tick == 0 {
+			playerState.tickDelay += int(val) * playerState.speed
+		}
+	}
+}
+
+// portamento applies Exx/Fxx, including the fine (xFx) and extra fine (xEx) variants.
+func (t *ITTicker) portamento(mod *it.Module, state *channelState, param byte, tick int, up bool) {
+	if param == 0 {
+		param = state.lastPorta
+	} else {
+		state.lastPorta = param
+	}
+	switch param >> 4 {
+	case 0x0F:
+		if tick == 0 {
+			t.slidePitch(mod, state, int(param&0x0F)*4, up)
+		}
+	case 0x0E:
+		if tick == 0 {
+			t.slidePitch(mod, state, int(param&0x0F), up)
+		}
+	default:
+		if tick > 0 {
+			t.slidePitch(mod, state, int(param)*16, up)
+		}
+	}
+}
+
+// slidePitch moves the channel period by amount units (256 per semitone with
+// linear slides). Amiga slides convert the amount to Amiga period steps.
+func (t *ITTicker) slidePitch(mod *it.Module, state *channelState, amount int, up bool) {
+	if state.sample == nil || state.period == 0 {
+		return
+	}
+	period := int(state.period)
+	if mod.Header.Flags&it.FlagLinearSlides != 0 {
+		if up {
+			period -= amount
+		} else {
+			period += amount
+		}
+	} else {
+		c5 := state.sample.Finetune()
+		amigaPeriod := 14317456.0 / itFrequency(state.period, c5)
+		if up {
+			amigaPeriod -= float64(amount) / 4
+		} else {
+			amigaPeriod += float64(amount) / 4
+		}
+		amigaPeriod = math.Max(amigaPeriod, 1)
+		period = itPeriodFromFrequency(14317456.0/amigaPeriod, c5)
+	}
+	state.period = uint16(max(min(period, math.MaxUint16), 1))
+}
+
+func (t *ITTicker) tonePortamento(state *channelState) {
+	if state.tonePortaTarget == 0 || state.sample == nil {
+		return
+	}
+	period := int(state.period)
+	target := int(state.tonePortaTarget)
+	if period < target {
+		period = min(period+int(state.portaSpeed), target)
+	} else if period > target {
+		period = max(period-int(state.portaSpeed), target)
+	}
+	state.period = uint16(period)
+}
+
+func (t *ITTicker) vibrato(playerState *playerState, state *channelState, tick int) {
+	state.vibratoDelta = itWaveform(playerState.rand, state.vibratoWave, state.vibratoPos) * int(state.vibratoDepth) / 16
+	if tick > 0 {
+		state.vibratoPos += state.vibratoSpeed * 4
+	}
+}
+
+// updateVoice advances the envelopes, fadeout and auto-vibrato of a voice and
+// computes the period, volume and panning that are used to render it.
+func (t *ITTicker) updateVoice(mod *it.Module, playerState *playerState, state *channelState) {
+	smp, ok := state.sample.(*it.Sample)
+	if !ok {
+		return
+	}
+	ins := t.instrument(mod, state)
+
+	envVolume := 1.0
+	envPanning := 0.0
+	pitchDelta := 0
+	state.filterModifier = 256
+	if ins != nil {
+		if state.volumeEnvelopeOn && ins.VolumeEnvelope.Enabled() {
+			value, pos, ended := itEnvelope(&ins.VolumeEnvelope, state.volumeEnvelopePos, state.sustained)
+			state.volumeEnvelopePos = pos
+			envVolume = float64(value) / 64.0
+			if ended {
+				if value == 0 {
+					state.sample = nil
+					return
+				}
+				state.fading = true
+			}
+		}
+		if state.panningEnvelopeOn && ins.PanningEnvelope.Enabled() {
+			value, pos, _ := itEnvelope(&ins.PanningEnvelope, state.panningEnvelopePos, state.sustained)
+			state.panningEnvelopePos = pos
+			envPanning = float64(value) / 32.0
+		}
+		if state.pitchEnvelopeOn && ins.PitchEnvelope.Enabled() {
+			value, pos, _ := itEnvelope(&ins.PitchEnvelope, state.pitchEnvelopePos, state.sustained)
+			state.pitchEnvelopePos = pos
+			if ins.PitchEnvelope.IsFilter() {
+				state.filterModifier = 256 + value*8
+			} else {
+				pitchDelta = -value * itSemitone / 2
+			}
+		}
+		if state.fading {
+			state.fadeoutVolume -= int(ins.FadeOut)
+			if state.fadeoutVolume <= 0 {
+				state.sample = nil
+				return
+			}
+		}
+	} else if state.fading {
+		state.sample = nil
+		return
+	}
+
+	if smp.VibratoDepth > 0 {
+		state.autovibratoDepth = min(state.autovibratoDepth+int(smp.VibratoRate), int(smp.VibratoDepth)<<8)
+		state.autovibratoPos += smp.VibratoSpeed
+		pitchDelta -= itWaveform(playerState.rand, smp.VibratoType, state.autovibratoPos) * (state.autovibratoDepth >> 8) / 16
+	}
+
+	period := int(state.period) - state.vibratoDelta - state.arpeggioDelta + pitchDelta
+	state.outPeriod = uint16(max(min(period, math.MaxUint16), 1))
+
+	volume := math.Max(0, math.Min(state.volume+state.tremoloDelta, 1.0))
+	if state.tremorMute {
+		volume = 0
+	}
+	volume *= state.channelVolume * envVolume * float64(state.fadeoutVolume) / 1024.0
+	volume *= float64(smp.GlobalVolume) / 64.0
+	if ins != nil {
+		volume *= float64(ins.GlobalVolume) / 128.0
+	}
+	state.outVolume = volume * playerState.globalVolume * float64(mod.Header.MixVolume) / 128.0
+
+	panning := math.Max(0, math.Min(state.panning+state.panbrelloDelta, 1.0))
+	panning += envPanning * (0.5 - math.Abs(panning-0.5))
+	if state.surround || mod.Header.Flags&it.FlagStereo == 0 {
+		panning = 0.5
+	}
+	state.outPanning = 0.5 + (panning-0.5)*float64(mod.Header.Separation)/128.0
+}
+
+func (t *ITTicker) RenderChannelTick(p *Player, state *channelState, tickBuffer []float64, samplesPerTick int) {
+	smp, ok := state.sample.(*it.Sample)
+	if !ok || state.outPeriod == 0 || len(smp.Data()) == 0 {
+		return
+	}
+
+	sampleData := smp.Data()
+	sampleLength := float64(min(len(sampleData), int(smp.Length())))
+	freq := itFrequency(state.outPeriod, smp.C5Speed)
+	step := freq / float64(p.opts.SampleRate)
+	state.markOutput(state.outPeriod, freq, state.outVolume, state.outPanning)
+
+	var loopStart, loopEnd float64
+	var hasLoop, isPingPong bool
+	if state.sustained && smp.HasSustainLoop() {
+		loopStart, loopEnd = float64(smp.SustainLoopBegin), float64(smp.SustainLoopEnd)
+		hasLoop, isPingPong = true, smp.IsSustainPingPong()
+	} else if smp.HasLoop() {
+		loopStart, loopEnd = float64(smp.LoopStart()), float64(smp.LoopEnd())
+		hasLoop, isPingPong = true, smp.IsPingPong()
+	}
+	loopEnd = math.Min(loopEnd, sampleLength)
+	if !isPingPong {
+		state.reverse = false
+	}
+	reader := newSampleReader(sampleData, int(sampleLength))
+	if hasLoop {
+		reader = reader.withLoop(int(loopStart), int(loopEnd), isPingPong)
+	}
+
+	a0, b0, b1, filtered := itFilterCoefficients(state.filterCutoff, state.filterResonance, state.filterModifier, p.opts.SampleRate)
+
+	for i := 0; i < samplesPerTick; i++ {
+		if hasLoop && loopEnd > loopStart {
+			if isPingPong {
+				if !state.reverse && state.samplePos >= loopEnd {
+					state.samplePos = math.Max(loopStart, 2*(loopEnd-1)-state.samplePos)
+					state.reverse = true
+				} else if state.reverse && state.samplePos < loopStart {
+					state.samplePos = math.Min(2*loopStart-state.samplePos, loopEnd-1)
+					state.reverse = false
+				}
+			} else if state.samplePos >= loopEnd {
+				state.samplePos = loopStart + math.Mod(state.samplePos-loopStart, loopEnd-loopStart)
+			}
+		} else if state.samplePos >= sampleLength || state.samplePos < 0 {
+			state.sample = nil
+			return
+		}
+
+		sampleValue := p.opts.Interpolation.interpolate(&reader, state.samplePos)
+
+		if filtered {
+			y := a0*sampleValue + b0*state.filterY1 + b1*state.filterY2
+			y = math.Max(-131072, math.Min(y, 131071))
+			state.filterY2 = state.filterY1
+			state.filterY1 = y
+			sampleValue = y
+		}
+
+		p.mixMono(state, tickBuffer, i, sampleValue, state.outVolume, state.outPanning)
+
+		if state.reverse {
+			state.samplePos -= step
+		} else {
+			state.samplePos += step
+		}
+	}
+}
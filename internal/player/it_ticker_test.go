@@ -0,0 +1,93 @@
+package player
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/jesseward/impulse/pkg/it"
+)
+
+func TestITFrequency(t *testing.T) {
+	if got := itFrequency(itNotePeriod(60), 8363); got != 8363 {
+		t.Errorf("itFrequency(C-5) = %v, want 8363", got)
+	}
+	if got := itFrequency(itNotePeriod(72), 8363); math.Abs(got-16726) > 0.001 {
+		t.Errorf("itFrequency(C-6) = %v, want 16726", got)
+	}
+	if got := itPeriodFromFrequency(16726, 8363); got != int(itNotePeriod(72)) {
+		t.Errorf("itPeriodFromFrequency(16726) = %v, want %v", got, itNotePeriod(72))
+	}
+}
+
+func TestITWaveform_random(t *testing.T) {
+	// The random waveform draws from the generator of the song, so that a
+	// song renders the same every time it is played from the start.
+	a, b := rand.New(rand.NewPCG(1, 2)), rand.New(rand.NewPCG(1, 2))
+	for pos := range 256 {
+		got, want := itWaveform(a, 3, uint8(pos)), itWaveform(b, 3, uint8(pos))
+		if got != want {
+			t.Fatalf("itWaveform(random, %d) = %d, then %d with the same seed", pos, got, want)
+		}
+		if got < -64 || got > 64 {
+			t.Errorf("itWaveform(random, %d) = %d, want -64 to 64", pos, got)
+		}
+	}
+}
+
+func TestITEnvelope(t *testing.T) {
+	env := it.Envelope{
+		Flags:        it.EnvelopeOn | it.EnvelopeSustainLoop,
+		NumNodes:     3,
+		SustainBegin: 1,
+		SustainEnd:   1,
+	}
+	env.Nodes[0] = it.EnvelopeNode{Value: 0, Tick: 0}
+	env.Nodes[1] = it.EnvelopeNode{Value: 64, Tick: 4}
+	env.Nodes[2] = it.EnvelopeNode{Value: 0, Tick: 8}
+
+	if value, next, _ := itEnvelope(&env, 2, true); value != 32 || next != 3 {
+		t.Errorf("itEnvelope(2) = %v, %v, want 32, 3", value, next)
+	}
+	if value, next, _ := itEnvelope(&env, 4, true); value != 64 || next != 4 {
+		t.Errorf("itEnvelope(4, sustained) = %v, %v, want 64, 4", value, next)
+	}
+	if _, next, _ := itEnvelope(&env, 4, false); next != 5 {
+		t.Errorf("itEnvelope(4, released) next = %v, want 5", next)
+	}
+	if value, _, ended := itEnvelope(&env, 8, false); value != 0 || !ended {
+		t.Errorf("itEnvelope(8) = %v, ended %v, want 0, true", value, ended)
+	}
+}
+
+func TestITEnvelope_nodeCount(t *testing.T) {
+	// An envelope that counts more nodes than it holds plays the nodes it has.
+	env := it.Envelope{Flags: it.EnvelopeOn | it.EnvelopeLoop, NumNodes: 0xFF, LoopBegin: 0, LoopEnd: 30}
+	for i := range env.Nodes {
+		env.Nodes[i] = it.EnvelopeNode{Value: 64, Tick: uint16(i)}
+	}
+	if value, next, ended := itEnvelope(&env, 24, false); value != 64 || next != 24 || !ended {
+		t.Errorf("itEnvelope(24) = %v, %v, %v, want 64, 24, true", value, next, ended)
+	}
+}
+
+func TestITVolumeSlide(t *testing.T) {
+	volume := 0.5
+	var memory byte
+	itVolumeSlide(&volume, 0x20, &memory, 1, 64)
+	if volume != 0.5+2.0/64 {
+		t.Errorf("D20 volume = %v, want %v", volume, 0.5+2.0/64)
+	}
+	itVolumeSlide(&volume, 0x00, &memory, 1, 64)
+	if volume != 0.5+4.0/64 {
+		t.Errorf("D00 volume = %v, want %v", volume, 0.5+4.0/64)
+	}
+	itVolumeSlide(&volume, 0xF1, &memory, 1, 64)
+	if volume != 0.5+4.0/64 {
+		t.Errorf("DF1 on tick 1 changed volume to %v", volume)
+	}
+	itVolumeSlide(&volume, 0xF1, &memory, 0, 64)
+	if volume != 0.5+3.0/64 {
+		t.Errorf("DF1 volume = %v, want %v", volume, 0.5+3.0/64)
+	}
+}
@@ -0,0 +1,285 @@
+package player
+
+import (
+	"math"
+	"slices"
+)
+
+// ReplayGainReference is the loudness, in LUFS, that ReplayGain 2.0 gains
+// bring songs to.
+const ReplayGainReference = -18.0
+
+// The loudness of ITU-R BS.1770 is measured over blocks of 400 ms that
+// overlap by 75%, so a block ends every 100 ms step. The loudness range of
+// EBU Tech 3342 uses short-term blocks of 3 s.
+const (
+	loudnessStep       = 0.1
+	momentarySteps     = 4
+	shortTermSteps     = 30
+	absoluteGate       = -70.0
+	relativeGate       = -10.0
+	rangeRelativeGate  = -20.0
+	truePeakOversample = 4
+	truePeakTaps       = 12 // per phase of the oversampling filter
+)
+
+// Loudness is the loudness of a rendering as defined by EBU R128.
+type Loudness struct {
+	Integrated float64 // gated loudness of the whole rendering, in LUFS
+	Range      float64 // loudness range, in LU
+	TruePeak   float64 // highest level between samples, in dBTP
+}
+
+// ReplayGain returns the ReplayGain 2.0 track gain in dB, which brings the
+// rendering to ReplayGainReference.
+func (l Loudness) ReplayGain() float64 {
+	if math.IsInf(l.Integrated, -1) {
+		return 0
+	}
+	return ReplayGainReference - l.Integrated
+}
+
+// ReplayGainPeak returns the ReplayGain track peak, the true peak as a linear
+// level where 1 is full scale.
+func (l Loudness) ReplayGainPeak() float64 {
+	return decibelsToGain(l.TruePeak)
+}
+
+// NormalizationGain returns the gain in dB that brings the rendering to the
+// target loudness in LUFS, lowered as needed to keep its true peak at or below
+// ceiling in dBTP. Silence is left as it is.
+func (l Loudness) NormalizationGain(target, ceiling float64) float64 {
+	if math.IsInf(l.Integrated, -1) {
+		return 0
+	}
+	return math.Min(target-l.Integrated, ceiling-l.TruePeak)
+}
+
+// LoudnessMeter is an AudioPlayer that measures the loudness of the audio
+// written to it, in the sample format of its player options.
+type LoudnessMeter struct {
+	opts     PlayerOptions
+	pending  []byte // partial frame of the last write
+	channels []loudnessChannel
+
+	stepFrames int
+	frames     int       // of the current step
+	steps      []float64 // mean square of every complete step
+	peak       float64   // true peak, as a linear level
+}
+
+// loudnessChannel holds the K-weighting filters and the oversampling history
+// of a channel.
+type loudnessChannel struct {
+	shelf, highPass biquadState
+	sum             float64 // of the squares of the current step
+	history         [truePeakTaps]float64
+}
+
+// NewLoudnessMeter returns a meter for audio in the format of opts.
+func NewLoudnessMeter(opts PlayerOptions) *LoudnessMeter {
+	return &LoudnessMeter{
+		opts:       opts,
+		channels:   make([]loudnessChannel, max(opts.NumChannels, 1)),
+		stepFrames: max(int(loudnessStep*float64(opts.SampleRate)), 1),
+	}
+}
+
+// kWeighting returns the two filters of the K-weighting of BS.1770, a high
+// shelf for the effect of the head followed by a high-pass, designed for the
+// sample rate with the bilinear transform.
+func kWeighting(sampleRate float64) (shelf, highPass biquad) {
+	const (
+		shelfFrequency = 1681.974450955533
+		shelfGain      = 3.999843853973347
+		shelfQ         = 0.7071752369554196
+		highFrequency  = 38.13547087602444
+		highQ          = 0.5003270373238773
+	)
+	k := math.Tan(math.Pi * shelfFrequency / sampleRate)
+	vh := math.Pow(10, shelfGain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/shelfQ + k*k
+	shelf = biquad{
+		b0: (vh + vb*k/shelfQ + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/shelfQ + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/shelfQ + k*k) / a0,
+	}
+
+	k = math.Tan(math.Pi * highFrequency / sampleRate)
+	a0 = 1 + k/highQ + k*k
+	highPass = biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/highQ + k*k) / a0,
+	}
+	return shelf, highPass
+}
+
+// truePeakFilter is the interpolation filter of the true peak meter, a
+// windowed sinc split into one phase per oversampled point, each normalized
+// to unity gain.
+var truePeakFilter = func() [truePeakOversample][truePeakTaps]float64 {
+	var phases [truePeakOversample][truePeakTaps]float64
+	n := truePeakOversample * truePeakTaps
+	center := float64(n-1) / 2
+	for p := range phases {
+		var sum float64
+		for j := range phases[p] {
+			i := p + truePeakOversample*j
+			x := (float64(i) - center) / truePeakOversample
+			h := 1.0
+			if x != 0 {
+				h = math.Sin(math.Pi*x) / (math.Pi * x)
+			}
+			h *= 0.5 - 0.5*math.Cos(2*math.Pi*(float64(i)+0.5)/float64(n)) // Hann window
+			phases[p][j] = h
+			sum += h
+		}
+		for j := range phases[p] {
+			phases[p][j] /= sum
+		}
+	}
+	return phases
+}()
+
+func (m *LoudnessMeter) Write(data []byte) (int, error) {
+	size := m.opts.BitDepth
+	frameSize := size * len(m.channels)
+	buf := data
+	if len(m.pending) > 0 {
+		buf = append(m.pending, data...)
+	}
+	shelf, highPass := kWeighting(float64(m.opts.SampleRate))
+
+	var end int
+	for ; end+frameSize <= len(buf); end += frameSize {
+		for ch := range m.channels {
+			m.add(ch, decodeSample(buf[end+ch*size:], m.opts), shelf, highPass)
+		}
+		if m.frames++; m.frames == m.stepFrames {
+			m.endStep()
+		}
+	}
+	m.pending = append(m.pending[:0], buf[end:]...)
+	return len(data), nil
+}
+
+// add measures sample x of a channel.
+func (m *LoudnessMeter) add(ch int, x float64, shelf, highPass biquad) {
+	c := &m.channels[ch]
+	y := highPass.process(&c.highPass, shelf.process(&c.shelf, x))
+	c.sum += y * y
+
+	copy(c.history[1:], c.history[:truePeakTaps-1])
+	c.history[0] = x
+	for _, phase := range truePeakFilter {
+		var v float64
+		for j, h := range phase {
+			v += h * c.history[j]
+		}
+		m.peak = math.Max(m.peak, math.Abs(v))
+	}
+	m.peak = math.Max(m.peak, math.Abs(x))
+}
+
+// endStep records the mean square of the current step, summed over the
+// channels, which all have the weight 1 in mono and stereo.
+func (m *LoudnessMeter) endStep() {
+	var sum float64
+	for ch := range m.channels {
+		sum += m.channels[ch].sum
+		m.channels[ch].sum = 0
+	}
+	m.steps = append(m.steps, sum/float64(m.stepFrames))
+	m.frames = 0
+}
+
+func (m *LoudnessMeter) Close() error {
+	return nil
+}
+
+func (m *LoudnessMeter) GetSampleRate() int {
+	return m.opts.SampleRate
+}
+
+// Loudness returns the loudness of the audio written so far. The last partial
+// step is not measured, like the last partial block of BS.1770.
+func (m *LoudnessMeter) Loudness() Loudness {
+	return Loudness{
+		Integrated: gatedLoudness(blocks(m.steps, momentarySteps), relativeGate),
+		Range:      loudnessRange(blocks(m.steps, shortTermSteps)),
+		TruePeak:   20 * math.Log10(m.peak),
+	}
+}
+
+// blocks returns the mean squares of the blocks of n steps that end at every
+// step.
+func blocks(steps []float64, n int) []float64 {
+	var out []float64
+	for end := n; end <= len(steps); end++ {
+		var sum float64
+		for _, s := range steps[end-n : end] {
+			sum += s
+		}
+		out = append(out, sum/float64(n))
+	}
+	return out
+}
+
+// blockLoudness converts the mean square of a block to LUFS.
+func blockLoudness(meanSquare float64) float64 {
+	return -0.691 + 10*math.Log10(meanSquare)
+}
+
+// gate returns the blocks above the absolute gate and then above the
+// loudness of those blocks lowered by relative LU.
+func gate(blocks []float64, relative float64) []float64 {
+	var loud []float64
+	var sum float64
+	for _, b := range blocks {
+		if blockLoudness(b) > absoluteGate {
+			loud = append(loud, b)
+			sum += b
+		}
+	}
+	if len(loud) == 0 {
+		return nil
+	}
+	threshold := blockLoudness(sum/float64(len(loud))) + relative
+	return slices.DeleteFunc(loud, func(b float64) bool {
+		return blockLoudness(b) <= threshold
+	})
+}
+
+// gatedLoudness returns the loudness of the gated blocks, or -Inf if there
+// are none.
+func gatedLoudness(blocks []float64, relative float64) float64 {
+	gated := gate(blocks, relative)
+	if len(gated) == 0 {
+		return math.Inf(-1)
+	}
+	var sum float64
+	for _, b := range gated {
+		sum += b
+	}
+	return blockLoudness(sum / float64(len(gated)))
+}
+
+// loudnessRange returns the spread between the 10th and the 95th percentile
+// of the gated short-term loudness.
+func loudnessRange(blocks []float64) float64 {
+	gated := gate(blocks, rangeRelativeGate)
+	if len(gated) == 0 {
+		return 0
+	}
+	slices.Sort(gated)
+	percentile := func(p float64) float64 {
+		return blockLoudness(gated[int(math.Round(p*float64(len(gated)-1)))])
+	}
+	return percentile(0.95) - percentile(0.10)
+}
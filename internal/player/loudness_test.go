@@ -0,0 +1,116 @@
+package player
+
+import (
+	"math"
+	"testing"
+)
+
+// measure writes seconds of a stereo signal to a loudness meter in 16-bit
+// samples, in uneven chunks to cross the steps of the meter.
+func measure(t *testing.T, seconds float64, signal func(i int) float64) Loudness {
+	t.Helper()
+	opts := DefaultPlayerOptions()
+	opts.Dither = false
+	meter := NewLoudnessMeter(opts)
+	encoder := newPCMEncoder(opts)
+	n := int(seconds * float64(opts.SampleRate))
+	for start := 0; start < n; start += 1001 {
+		var buf []float64
+		for i := start; i < min(start+1001, n); i++ {
+			buf = append(buf, signal(i), signal(i))
+		}
+		data := encoder.encode(buf)
+		// Split a sample to test partial writes.
+		if _, err := meter.Write(data[:3]); err != nil {
+			t.Fatalf("Write() failed: %v", err)
+		}
+		if _, err := meter.Write(data[3:]); err != nil {
+			t.Fatalf("Write() failed: %v", err)
+		}
+	}
+	return meter.Loudness()
+}
+
+func sine(frequency, level float64) func(i int) float64 {
+	amp := decibelsToGain(level)
+	return func(i int) float64 {
+		return amp * math.Sin(2*math.Pi*frequency*float64(i)/44100)
+	}
+}
+
+func TestLoudnessMeter(t *testing.T) {
+	// The reference signal of EBU Tech 3341: a 1 kHz sine at -23 dBFS in both
+	// channels measures -23 LUFS.
+	l := measure(t, 20, sine(997, -23))
+	if math.Abs(l.Integrated+23) > 0.1 {
+		t.Errorf("integrated loudness of a -23 dBFS sine = %.2f LUFS, want -23", l.Integrated)
+	}
+	if math.Abs(l.TruePeak+23) > 0.1 {
+		t.Errorf("true peak of a -23 dBFS sine = %.2f dBTP, want -23", l.TruePeak)
+	}
+	if l.Range > 0.1 {
+		t.Errorf("loudness range of a steady sine = %.2f LU, want 0", l.Range)
+	}
+
+	// Silence is gated out of the integrated loudness, and quieter passages
+	// widen the range.
+	loud, quiet := sine(997, -20), sine(997, -30)
+	l = measure(t, 60, func(i int) float64 {
+		switch {
+		case i < 20*44100:
+			return loud(i)
+		case i < 40*44100:
+			return quiet(i)
+		}
+		return 0
+	})
+	want := 10 * math.Log10((math.Pow(10, -2)+math.Pow(10, -3))/2)
+	if math.Abs(l.Integrated-want) > 0.2 {
+		t.Errorf("integrated loudness of a loud and a quiet passage = %.2f LUFS, want %.2f", l.Integrated, want)
+	}
+	if math.Abs(l.Range-10) > 0.5 {
+		t.Errorf("loudness range of passages 10 dB apart = %.2f LU, want 10", l.Range)
+	}
+
+	// A sine at a quarter of the sample rate, sampled away from its peaks,
+	// has a true peak 3 dB above its samples.
+	l = measure(t, 1, func(i int) float64 {
+		return 0.9 * math.Sin(math.Pi*float64(i)/2+math.Pi/4)
+	})
+	if want := 20 * math.Log10(0.9); l.TruePeak < want-0.5 || l.TruePeak > want+0.1 {
+		t.Errorf("true peak of an intersample peak of %.2f dB = %.2f dBTP", want, l.TruePeak)
+	}
+}
+
+func TestLoudness_silence(t *testing.T) {
+	l := measure(t, 5, func(int) float64 { return 0 })
+	if !math.IsInf(l.Integrated, -1) {
+		t.Errorf("integrated loudness of silence = %v, want -Inf", l.Integrated)
+	}
+	if got := l.ReplayGain(); got != 0 {
+		t.Errorf("ReplayGain() of silence = %v, want 0", got)
+	}
+	if got := l.NormalizationGain(-23, -1); got != 0 {
+		t.Errorf("NormalizationGain() of silence = %v, want 0", got)
+	}
+}
+
+func TestLoudness_NormalizationGain(t *testing.T) {
+	tests := []struct {
+		loudness Loudness
+		want     float64
+	}{
+		{Loudness{Integrated: -10, TruePeak: -8}, -13},
+		{Loudness{Integrated: -30, TruePeak: -10}, 7},
+		// Limited by the true peak ceiling.
+		{Loudness{Integrated: -30, TruePeak: -3}, 2},
+	}
+	for _, tt := range tests {
+		if got := tt.loudness.NormalizationGain(-23, -1); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%+v.NormalizationGain(-23, -1) = %v, want %v", tt.loudness, got, tt.want)
+		}
+	}
+	if got := (Loudness{Integrated: -14}).ReplayGain(); got != -4 {
+		t.Errorf("ReplayGain() of -14 LUFS = %v, want -4", got)
+	}
+}
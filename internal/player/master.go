@@ -0,0 +1,87 @@
+package player
+
+import (
+	"math"
+)
+
+// The soft-knee limiter keeps the master output below limiterThreshold, in
+// dBFS. Its gain reduction starts limiterKnee dB below the threshold, follows
+// peaks instantly and recovers over limiterRelease seconds.
+const (
+	limiterThreshold = -0.3
+	limiterKnee      = 6.0
+	limiterRelease   = 0.1
+)
+
+// headroom returns the gain of the mix of a module with the given number of
+// channels. Four channels play at full level, and every doubling of the
+// channels lowers the level by 3 dB, as uncorrelated voices add up.
+func headroom(numChannels int) float64 {
+	return math.Min(2/math.Sqrt(float64(max(numChannels, 1))), 1)
+}
+
+// decibelsToGain converts a level in decibels to a linear gain.
+func decibelsToGain(db float64) float64 {
+	return math.Pow(10, db/20)
+}
+
+// masterBus applies the master gain and the limiter to the mix, which has
+// the 16-bit full scale, before it is encoded.
+type masterBus struct {
+	gain        float64
+	limit       bool
+	numChannels int
+	release     float64 // per frame coefficient of the envelope
+	envelope    float64 // peak level of the recent frames
+}
+
+func newMasterBus(opts PlayerOptions, numChannels int) *masterBus {
+	return &masterBus{
+		gain:        decibelsToGain(opts.MasterGain) * headroom(numChannels),
+		limit:       !opts.NoLimiter,
+		numChannels: max(opts.NumChannels, 1),
+		release:     math.Exp(-1 / (limiterRelease * float64(opts.SampleRate))),
+	}
+}
+
+// process applies the master gain and the limiter to interleaved audio in
+// place, leaving it in the range -1 to 1 unless the limiter is disabled.
+func (m *masterBus) process(buf []float64) {
+	scale := m.gain / 32768
+	for frame := 0; frame+m.numChannels <= len(buf); frame += m.numChannels {
+		out := buf[frame : frame+m.numChannels]
+		var peak float64
+		for i := range out {
+			out[i] *= scale
+			peak = math.Max(peak, math.Abs(out[i]))
+		}
+		if !m.limit {
+			continue
+		}
+		m.envelope = math.Max(peak, m.envelope*m.release)
+		if gain := limiterGain(m.envelope); gain < 1 {
+			for i := range out {
+				out[i] *= gain
+			}
+		}
+	}
+}
+
+// limiterGain returns the gain that the limiter applies at the given peak
+// level.
+func limiterGain(peak float64) float64 {
+	if peak <= 0 {
+		return 1
+	}
+	over := 20*math.Log10(peak) - limiterThreshold
+	var reduction float64
+	switch {
+	case over <= -limiterKnee/2:
+		return 1
+	case over < limiterKnee/2:
+		reduction = (over + limiterKnee/2) * (over + limiterKnee/2) / (2 * limiterKnee)
+	default:
+		reduction = over
+	}
+	return decibelsToGain(-reduction)
+}
@@ -0,0 +1,63 @@
+package player
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHeadroom(t *testing.T) {
+	tests := []struct {
+		numChannels int
+		want        float64
+	}{
+		{1, 1},
+		{4, 1},
+		{8, 1 / math.Sqrt2},
+		{16, 0.5},
+	}
+	for _, tt := range tests {
+		if got := headroom(tt.numChannels); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("headroom(%d) = %v, want %v", tt.numChannels, got, tt.want)
+		}
+	}
+}
+
+func TestMasterBus_process(t *testing.T) {
+	opts := DefaultPlayerOptions()
+	signal := func(level float64) []float64 {
+		buf := make([]float64, 4410*opts.NumChannels)
+		for i := range buf {
+			buf[i] = level * math.Sin(2*math.Pi*440*float64(i/opts.NumChannels)/44100)
+		}
+		return buf
+	}
+	peak := func(buf []float64) float64 {
+		var p float64
+		for _, v := range buf {
+			p = math.Max(p, math.Abs(v))
+		}
+		return p
+	}
+
+	// A quiet mix is only scaled to the range -1 to 1.
+	buf := signal(8192)
+	newMasterBus(opts, 4).process(buf)
+	if got := peak(buf); math.Abs(got-0.25) > 1e-3 {
+		t.Errorf("peak of a quiet mix = %v, want 0.25", got)
+	}
+
+	// A mix that is 4 times over full scale is limited below the threshold.
+	buf = signal(4 * 32768)
+	newMasterBus(opts, 4).process(buf)
+	if got, limit := peak(buf), decibelsToGain(limiterThreshold); got > limit+1e-9 {
+		t.Errorf("peak of a loud mix = %v, want at most %v", got, limit)
+	}
+
+	opts.NoLimiter = true
+	opts.MasterGain = -6
+	buf = signal(4 * 32768)
+	newMasterBus(opts, 4).process(buf)
+	if got := peak(buf); math.Abs(got-4*decibelsToGain(-6)) > 1e-2 {
+		t.Errorf("peak of a loud mix without the limiter = %v, want %v", got, 4*decibelsToGain(-6))
+	}
+}
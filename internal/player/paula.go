@@ -0,0 +1,296 @@
+package player
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"strings"
+	"sync"
+)
+
+// AmigaModel selects the Amiga whose audio output is emulated when playing
+// Protracker modules.
+type AmigaModel int
+
+const (
+	// AmigaOff mixes Protracker modules like the other formats.
+	AmigaOff AmigaModel = iota
+	// Amiga500 emulates the Amiga 500, whose fixed low-pass filter cuts off
+	// at about 4.4 kHz.
+	Amiga500
+	// Amiga1200 emulates the Amiga 1200, whose fixed low-pass filter is far
+	// above the audible range.
+	Amiga1200
+)
+
+var amigaModelNames = []string{"off", "a500", "a1200"}
+
+func (m AmigaModel) String() string {
+	if m < 0 || int(m) >= len(amigaModelNames) {
+		return fmt.Sprintf("AmigaModel(%d)", int(m))
+	}
+	return amigaModelNames[m]
+}
+
+// ParseAmigaModel returns the Amiga model with the given name.
+func ParseAmigaModel(name string) (AmigaModel, error) {
+	for i, n := range amigaModelNames {
+		if strings.EqualFold(name, n) {
+			return AmigaModel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown Amiga model %q, want one of %s", name, strings.Join(amigaModelNames, ", "))
+}
+
+// amigaPanning returns the hard panning of a Paula channel. Channels 1 and 4
+// are on the left and channels 2 and 3 on the right, repeating for modules
+// with more channels.
+func amigaPanning(channel int) float64 {
+	if c := channel % 4; c == 0 || c == 3 {
+		return 0
+	}
+	return 1
+}
+
+// The cutoff frequencies of the RC filters on the Amiga audio output.
+const (
+	a500LowPass   = 4420.971  // R = 360 ohm, C = 0.1 uF
+	a1200LowPass  = 34419.322 // R = 680 ohm, C = 6.8 nF
+	a500HighPass  = 5.2       // R = 1390 ohm, C = 22 uF
+	a1200HighPass = 5.3       // R = 1360 ohm, C = 22 uF
+	ledCutoff     = 3090.533  // the two-pole Sallen-Key "LED" filter
+	ledQ          = 0.660225
+)
+
+// paulaFilter applies the output filters of an Amiga to the mixed audio. The
+// LED filter is switched by the Protracker E0x command.
+type paulaFilter struct {
+	lowPass, highPass float64 // one-pole filter coefficients
+	led               biquad
+	channels          []paulaFilterChannel
+}
+
+type paulaFilterChannel struct {
+	lowPass, highPass float64
+	led               biquadState
+}
+
+func newPaulaFilter(model AmigaModel, sampleRate, numChannels int) *paulaFilter {
+	lowPass, highPass := a500LowPass, a500HighPass
+	if model == Amiga1200 {
+		lowPass, highPass = a1200LowPass, a1200HighPass
+	}
+	onePole := func(cutoff float64) float64 {
+		return 1 - math.Exp(-2*math.Pi*cutoff/float64(sampleRate))
+	}
+	return &paulaFilter{
+		lowPass:  onePole(math.Min(lowPass, float64(sampleRate)/2)),
+		highPass: onePole(highPass),
+		led:      newLowPassBiquad(ledCutoff, ledQ, float64(sampleRate)),
+		channels: make([]paulaFilterChannel, numChannels),
+	}
+}
+
+// paulaGain is the level of the Amiga output. Paula channels are hard panned,
+// so they are halved to play as loud as centered channels do.
+const paulaGain = 0.5
+
+// process filters interleaved audio in place.
+func (f *paulaFilter) process(buf []float64, led bool) {
+	numChannels := len(f.channels)
+	for i := range buf {
+		ch := &f.channels[i%numChannels]
+		x := buf[i] * paulaGain
+		ch.lowPass += f.lowPass * (x - ch.lowPass)
+		x = ch.lowPass
+		if led {
+			x = f.led.process(&ch.led, x)
+		}
+		ch.highPass += f.highPass * (x - ch.highPass)
+		buf[i] = x - ch.highPass
+	}
+}
+
+// biquad holds the normalized coefficients of a second order filter.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+}
+
+type biquadState struct {
+	x1, x2, y1, y2 float64
+}
+
+// newLowPassBiquad returns a low-pass filter with the given cutoff frequency
+// and resonance, using the bilinear transform.
+func newLowPassBiquad(cutoff, q, sampleRate float64) biquad {
+	w := 2 * math.Pi * cutoff / sampleRate
+	alpha := math.Sin(w) / (2 * q)
+	cos := math.Cos(w)
+	a0 := 1 + alpha
+	return biquad{
+		b0: (1 - cos) / 2 / a0,
+		b1: (1 - cos) / a0,
+		b2: (1 - cos) / 2 / a0,
+		a1: -2 * cos / a0,
+		a2: (1 - alpha) / a0,
+	}
+}
+
+func (f biquad) process(s *biquadState, x float64) float64 {
+	y := f.b0*x + f.b1*s.x1 + f.b2*s.x2 - f.a1*s.y1 - f.a2*s.y2
+	s.x2, s.x1 = s.x1, x
+	s.y2, s.y1 = s.y1, y
+	return y
+}
+
+// paulaVoice synthesizes the output of a Paula channel, which holds each
+// sample point until the next one. Every step between two points is smoothed
+// with a band-limited step (BLEP) so that it does not alias.
+type paulaVoice struct {
+	last   float64
+	buffer [blepSamples]float64
+	index  int
+}
+
+// output returns the output for the held value at the fractional sample
+// position pos, which advances by step for every output sample.
+func (v *paulaVoice) output(value, pos, step float64) float64 {
+	if value != v.last {
+		// The step happened offset output samples ago.
+		offset := 0.0
+		if step > 0 {
+			offset = math.Min((pos-math.Floor(pos))/step, 1-1.0/blepOversampling)
+		}
+		v.addStep(offset, v.last-value)
+		v.last = value
+	}
+	out := value + v.buffer[v.index]
+	v.buffer[v.index] = 0
+	v.index = (v.index + 1) % blepSamples
+	return out
+}
+
+// addStep adds the residual of a band-limited step of the given amplitude
+// that happened offset output samples ago.
+func (v *paulaVoice) addStep(offset, amplitude float64) {
+	table := blepResidual()
+	f := offset * blepOversampling
+	i := int(f)
+	frac := f - float64(i)
+	for n := 0; n < blepSamples && i+1 < len(table); n++ {
+		r := table[i] + (table[i+1]-table[i])*frac
+		v.buffer[(v.index+n)%blepSamples] += amplitude * r
+		i += blepOversampling
+	}
+}
+
+const (
+	// blepSamples is the length of a band-limited step in output samples.
+	blepSamples = 32
+	// blepOversampling is the resolution of the step table per sample.
+	blepOversampling = 32
+)
+
+var (
+	blepOnce  sync.Once
+	blepTable []float64
+)
+
+// blepResidual returns the difference between a unit step and a minimum phase
+// band-limited step, which starts at 1 and decays to 0, sampled
+// blepOversampling times per output sample.
+func blepResidual() []float64 {
+	blepOnce.Do(func() {
+		blepTable = minimumPhaseStep(blepSamples*blepOversampling, blepOversampling)
+		for i, s := range blepTable {
+			blepTable[i] = 1 - s
+		}
+		blepTable = append(blepTable, 0)
+	})
+	return blepTable
+}
+
+// minimumPhaseStep returns a band-limited step of n points, built from a
+// Blackman windowed sinc with oversampling points per zero crossing that is
+// converted to minimum phase through its real cepstrum and integrated.
+func minimumPhaseStep(n, oversampling int) []float64 {
+	size := 1
+	for size < 4*n {
+		size *= 2
+	}
+	x := make([]complex128, size)
+	for i := 0; i < n; i++ {
+		t := float64(i-n/2) / float64(oversampling)
+		s := 1.0
+		if t != 0 {
+			s = math.Sin(math.Pi*t) / (math.Pi * t)
+		}
+		w := 0.42 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n-1)) + 0.08*math.Cos(4*math.Pi*float64(i)/float64(n-1))
+		x[i] = complex(s*w, 0)
+	}
+
+	// The real cepstrum, folded to make it causal.
+	fft(x, false)
+	for i := range x {
+		x[i] = complex(math.Log(math.Max(cmplx.Abs(x[i]), 1e-12)), 0)
+	}
+	fft(x, true)
+	for i := 1; i < size/2; i++ {
+		x[i] *= 2
+	}
+	for i := size/2 + 1; i < size; i++ {
+		x[i] = 0
+	}
+	fft(x, false)
+	for i := range x {
+		x[i] = cmplx.Exp(x[i])
+	}
+	fft(x, true)
+
+	step := make([]float64, n)
+	var sum float64
+	for i := range step {
+		sum += real(x[i])
+		step[i] = sum
+	}
+	for i := range step {
+		step[i] /= sum
+	}
+	return step
+}
+
+// fft computes the discrete Fourier transform of x in place, whose length must
+// be a power of two. The inverse transform is scaled by 1/len(x).
+func fft(x []complex128, inverse bool) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	sign := -1.0
+	if inverse {
+		sign = 1
+	}
+	for length := 2; length <= n; length <<= 1 {
+		w := cmplx.Rect(1, sign*2*math.Pi/float64(length))
+		for start := 0; start < n; start += length {
+			wn := complex(1, 0)
+			for k := 0; k < length/2; k++ {
+				a, b := x[start+k], x[start+k+length/2]*wn
+				x[start+k], x[start+k+length/2] = a+b, a-b
+				wn *= w
+			}
+		}
+	}
+	if inverse {
+		for i := range x {
+			x[i] /= complex(float64(n), 0)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package player
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseAmigaModel(t *testing.T) {
+	for _, m := range []AmigaModel{AmigaOff, Amiga500, Amiga1200} {
+		got, err := ParseAmigaModel(m.String())
+		if err != nil || got != m {
+			t.Errorf("ParseAmigaModel(%q) = %v, %v, want %v", m.String(), got, err, m)
+		}
+	}
+	if _, err := ParseAmigaModel("a4000"); err == nil {
+		t.Error("ParseAmigaModel(\"a4000\") succeeded")
+	}
+}
+
+func TestBLEPResidual(t *testing.T) {
+	table := blepResidual()
+	if len(table) != blepSamples*blepOversampling+1 {
+		t.Fatalf("len(blepResidual()) = %d, want %d", len(table), blepSamples*blepOversampling+1)
+	}
+	if math.Abs(table[0]-1) > 0.01 {
+		t.Errorf("residual starts at %v, want 1", table[0])
+	}
+	if last := table[len(table)-2]; math.Abs(last) > 1e-6 {
+		t.Errorf("residual ends at %v, want 0", last)
+	}
+}
+
+func TestPaulaVoice_output(t *testing.T) {
+	var v paulaVoice
+	// A step from 0 to 1000 is smoothed, then settles on the new value.
+	first := v.output(1000, 0.5, 1)
+	if first <= 0 || first >= 1000 {
+		t.Errorf("output right after the step = %v, want between 0 and 1000", first)
+	}
+	var out float64
+	for range blepSamples {
+		out = v.output(1000, 0.5, 1)
+	}
+	if out != 1000 {
+		t.Errorf("output after the step settled = %v, want 1000", out)
+	}
+}
+
+func TestPaulaFilter_LED(t *testing.T) {
+	// A tone at half the output rate is damped by the low-pass filters, and
+	// more so when the LED filter is on.
+	level := func(led bool) float64 {
+		f := newPaulaFilter(Amiga500, 44100, 1)
+		buf := make([]float64, 4096)
+		for i := range buf {
+			buf[i] = float64(10000 * (1 - 2*(i%2)))
+		}
+		f.process(buf, led)
+		return math.Abs(buf[len(buf)-1])
+	}
+	off, on := level(false), level(true)
+	if off >= 10000*paulaGain || on >= off {
+		t.Errorf("Nyquist level = %v without and %v with the LED filter, want each one lower", off, on)
+	}
+}
@@ -0,0 +1,84 @@
+package player
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand/v2"
+)
+
+// validSampleFormat reports an error if opts does not select 8, 16 or 24-bit
+// integer samples or 32-bit floating point samples.
+func validSampleFormat(opts PlayerOptions) error {
+	if opts.Float && opts.BitDepth != 4 || !opts.Float && (opts.BitDepth < 1 || opts.BitDepth > 3) {
+		return fmt.Errorf("unsupported sample format of %d bytes (float %v)", opts.BitDepth, opts.Float)
+	}
+	return nil
+}
+
+// pcmEncoder converts the output of the master bus, in the range -1 to 1, to
+// little-endian samples in the format of the player options. Samples of 8
+// bits are unsigned, as in WAV files. Integer samples are dithered with
+// triangular noise of 1 LSB, which is seeded the same way for every encoder so
+// that rendering is repeatable.
+type pcmEncoder struct {
+	bitDepth int
+	float    bool
+	dither   bool
+	rand     *rand.Rand
+}
+
+func newPCMEncoder(opts PlayerOptions) *pcmEncoder {
+	return &pcmEncoder{
+		bitDepth: opts.BitDepth,
+		float:    opts.Float,
+		dither:   opts.Dither,
+		rand:     rand.New(rand.NewPCG(1, 2)),
+	}
+}
+
+func (e *pcmEncoder) encode(buf []float64) []byte {
+	out := make([]byte, len(buf)*e.bitDepth)
+	if e.float {
+		for i, sample := range buf {
+			binary.LittleEndian.PutUint32(out[i*4:], math.Float32bits(float32(sample)))
+		}
+		return out
+	}
+
+	scale := float64(int(1) << (8*e.bitDepth - 1))
+	for i, sample := range buf {
+		x := sample * scale
+		if e.dither {
+			x += e.rand.Float64() - e.rand.Float64()
+		}
+		v := int32(math.Max(math.Min(math.Round(x), scale-1), -scale))
+		b := out[i*e.bitDepth:]
+		switch e.bitDepth {
+		case 1:
+			b[0] = byte(v + 128)
+		case 2:
+			binary.LittleEndian.PutUint16(b, uint16(v))
+		case 3:
+			b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+		}
+	}
+	return out
+}
+
+// decodeSample returns the sample at the start of b, in the format of opts,
+// in the range -1 to 1.
+func decodeSample(b []byte, opts PlayerOptions) float64 {
+	scale := 1 / float64(int(1)<<(8*opts.BitDepth-1))
+	switch {
+	case opts.Float:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	case opts.BitDepth == 1:
+		return float64(int(b[0])-128) * scale
+	case opts.BitDepth == 2:
+		return float64(int16(binary.LittleEndian.Uint16(b))) * scale
+	case opts.BitDepth == 3:
+		return float64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)>>8) * scale
+	}
+	return 0
+}
@@ -0,0 +1,44 @@
+package player
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestPCMEncoder_encode(t *testing.T) {
+	tests := []struct {
+		bitDepth int
+		float    bool
+		want     []byte
+	}{
+		{1, false, []byte{0xc0, 0x00, 0xff}},
+		{2, false, []byte{0x00, 0x40, 0x00, 0x80, 0xff, 0x7f}},
+		{3, false, []byte{0x00, 0x00, 0x40, 0x00, 0x00, 0x80, 0xff, 0xff, 0x7f}},
+		{4, true, []byte{0x00, 0x00, 0x00, 0x3f, 0x00, 0x00, 0x80, 0xbf, 0x00, 0x00, 0xc0, 0x3f}},
+	}
+	for _, tt := range tests {
+		opts := DefaultPlayerOptions()
+		opts.BitDepth, opts.Float, opts.Dither = tt.bitDepth, tt.float, false
+		if got := newPCMEncoder(opts).encode([]float64{0.5, -1, 1.5}); !bytes.Equal(got, tt.want) {
+			t.Errorf("encode() of %d bytes (float %v) = % x, want % x", tt.bitDepth, tt.float, got, tt.want)
+		}
+	}
+}
+
+func TestPCMEncoder_dither(t *testing.T) {
+	e := newPCMEncoder(DefaultPlayerOptions())
+	buf := make([]float64, 10000)
+	out := e.encode(buf)
+	var sum float64
+	for i := 0; i < len(out); i += 2 {
+		v := int16(uint16(out[i]) | uint16(out[i+1])<<8)
+		if v < -1 || v > 1 {
+			t.Fatalf("dithered silence = %d, want at most 1 LSB", v)
+		}
+		sum += float64(v)
+	}
+	if mean := sum / float64(len(buf)); math.Abs(mean) > 0.05 {
+		t.Errorf("mean of dithered silence = %v, want about 0", mean)
+	}
+}
@@ -1,24 +1,39 @@
 package player
 
 import (
-	"encoding/binary"
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
 
 	"github.com/jesseward/impulse/pkg/module"
 )
 
 // PlayerOptions defines the configuration for the audio player.
 type PlayerOptions struct {
-	SampleRate  int
-	NumChannels int
-	BitDepth    int // in bytes
+	SampleRate    int
+	NumChannels   int
+	BitDepth      int  // in bytes, 1 to 3 for integer samples or 4 for float samples
+	Float         bool // writes 32-bit floating point samples
+	Dither        bool // adds triangular dither to integer samples
+	Interpolation Interpolation
+	Amiga         AmigaModel // emulates the Amiga audio output for Protracker modules
+	VolumeRamp    int        // frames over which volume changes are smoothed, 0 to disable
+	MasterGain    float64    // in decibels, on top of the headroom for the number of channels
+	NoLimiter     bool       // disables the soft limiter, so that loud mixes clip
 }
 
 // DefaultPlayerOptions returns a default set of player options (CD quality).
 func DefaultPlayerOptions() PlayerOptions {
 	return PlayerOptions{
-		SampleRate:  44100,
-		NumChannels: 2,
-		BitDepth:    2, // 16-bit
+		SampleRate:    44100,
+		NumChannels:   2,
+		BitDepth:      2, // 16-bit
+		Dither:        true,
+		Interpolation: InterpolationLinear,
+		VolumeRamp:    44, // 1 ms
 	}
 }
 
@@ -28,121 +43,188 @@ type Player struct {
 	log             func(format string, a ...interface{})
 	StateUpdateChan chan<- PlayerStateUpdate
 	opts            PlayerOptions
+
+	// mu guards state, which holds the position of the next row to play,
+	// endFrame, the frame at which playback stops if it is not zero, the
+	// limits of SetMaxDuration, SetEndOrder and SetFadeOut, and the channel of
+	// SetTelemetry.
+	mu         sync.Mutex
+	state      *playerState
+	endFrame   int
+	maxFrames  int
+	stopOrder  int // one past the last order to play, or zero
+	fadeFrames int
+	telemetry  chan<- Telemetry
+	// resumeChan is closed by Resume while playback is paused, and sink is
+	// the audio player of WriteRaw.
+	resumeChan chan struct{}
+	sink       AudioPlayer
+	master     *masterBus
+	encoder    *pcmEncoder
+	// muted holds the channels that are not mixed, which are rendered to
+	// scratch instead.
+	muted            []bool
+	mutedInstruments map[int]bool
+	scratch          []float64
 }
 
-func NewPlayer(module module.Module, log func(format string, a ...interface{}), stateUpdateChan chan<- PlayerStateUpdate, opts PlayerOptions) *Player {
-	var ticker Ticker
-	switch module.Type() {
+// newTicker returns the ticker that plays modules of the type of m, or nil if
+// there is none.
+func newTicker(m module.Module) Ticker {
+	switch m.Type() {
 	case "Protracker":
-		ticker = &ProtrackerTicker{}
+		return &ProtrackerTicker{}
 	case "S3M":
-		ticker = &S3MTicker{}
+		return &S3MTicker{}
 	case "FastTracker II Extended Module":
-		ticker = &XMTicker{}
+		return &XMTicker{}
+	case "Impulse Tracker":
+		return &ITTicker{}
 	}
+	return nil
+}
+
+// Playable reports whether a Player can play m. A module of a format that the
+// player has no ticker for, such as one registered with the loader by another
+// package, can be loaded but not played.
+func Playable(m module.Module) bool {
+	return newTicker(m) != nil
+}
 
-	return &Player{
+func NewPlayer(module module.Module, log func(format string, a ...interface{}), stateUpdateChan chan<- PlayerStateUpdate, opts PlayerOptions) *Player {
+	p := &Player{
 		module:          module,
-		ticker:          ticker,
+		ticker:          newTicker(module),
 		log:             log,
 		StateUpdateChan: stateUpdateChan,
 		opts:            opts,
+		muted:           make([]bool, module.NumChannels()),
+		master:          newMasterBus(opts, module.NumChannels()),
+		encoder:         newPCMEncoder(opts),
 	}
+	p.reset()
+	return p
 }
 
+// WriteRaw plays the song from the current position, which is the start of the
+// song unless Seek or SeekTime moved it. Stopping playback keeps the position,
+// and playing the song to the end moves it back to the start. It returns nil
+// when stopChan is closed, and otherwise behaves as Render.
 func (p *Player) WriteRaw(player AudioPlayer, stopChan <-chan struct{}) error {
-	if otoPlayer, ok := player.(*OtoPlayer); ok {
+	return p.render(context.Background(), player, stopChan)
+}
+
+// Render plays the song from the current position to sink until it ends, or
+// until ctx is done, in which case it returns the error of ctx. It returns a
+// *RenderError if a fault stops the playback of the song, and the error of sink
+// if writing to it fails.
+func (p *Player) Render(ctx context.Context, sink AudioPlayer) error {
+	return p.render(ctx, sink, nil)
+}
+
+// render implements Render, and returns nil when stopChan is closed.
+func (p *Player) render(ctx context.Context, sink AudioPlayer, stopChan <-chan struct{}) error {
+	if p.ticker == nil {
+		return fmt.Errorf("cannot play %s modules", p.module.Type())
+	}
+	if err := validSampleFormat(p.opts); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.sink = sink
+	paused := p.resumeChan != nil
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.sink = nil
+		p.mu.Unlock()
+	}()
+	if otoPlayer, ok := sink.(*OtoPlayer); ok && !paused {
 		otoPlayer.player.Play()
 	}
 
-	audioChan, errChan := p.renderSongByRow(stopChan)
+	// Rendering stops when Render returns for any reason.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	audioChan, errChan := p.renderSongByRow(ctx)
 
 	for {
 		select {
 		case audioBuf, ok := <-audioChan:
 			if !ok {
-				return nil
-			}
-			buf := make([]byte, len(audioBuf)*p.opts.BitDepth)
-			for i, sample := range audioBuf {
-				clipped := int16(max(min(sample, 32767), -32768))
-				binary.LittleEndian.PutUint16(buf[i*2:], uint16(clipped))
+				// errChan is closed before audioChan, holding the error
+				// that stopped rendering, if any. Rendering also stops
+				// when ctx is done, which may be seen here first.
+				if err := <-errChan; err != nil {
+					return err
+				}
+				return ctx.Err()
 			}
-			if _, err := player.Write(buf); err != nil {
+			p.master.process(audioBuf)
+			if _, err := sink.Write(p.encoder.encode(audioBuf)); err != nil {
 				return err
 			}
-		case err := <-errChan:
-			return err
+		case <-ctx.Done():
+			return ctx.Err()
 		case <-stopChan:
 			return nil
 		}
 	}
 }
 
-func (p *Player) renderSongByRow(stopChan <-chan struct{}) (<-chan []int, <-chan error) {
-	audioChan := make(chan []int)
+// renderSongByRow mixes the song in a goroutine and sends every row to the
+// returned channel, until the song ends, ctx is done, or a fault is sent to
+// the error channel.
+func (p *Player) renderSongByRow(ctx context.Context) (<-chan []float64, <-chan error) {
+	audioChan := make(chan []float64)
 	errChan := make(chan error, 1)
 
 	go func() {
 		defer close(audioChan)
 		defer close(errChan)
 
-		playerState := playerState{
-			speed:    p.module.DefaultSpeed(),
-			bpm:      p.module.DefaultBPM(),
-			channels: make([]channelState, p.module.NumChannels()),
+		end, err := p.startRender()
+		if err != nil {
+			errChan <- err
+			return
 		}
-		for i := range playerState.channels {
-			playerState.channels[i] = defaultChannelState()
-		}
-
-		orderIndex := 0
-		rowIndex := 0
 
-		for orderIndex < p.module.SongLength() {
-			select {
-			case <-stopChan:
+		for {
+			if ctx.Err() != nil {
 				return
-			default:
 			}
 
-			patternIndex := p.module.PatternOrder()[orderIndex]
-			if int(patternIndex) >= p.module.NumPatterns() {
-				orderIndex++
-				continue
+			p.mu.Lock()
+			resume := p.resumeChan
+			p.mu.Unlock()
+			if resume != nil {
+				select {
+				case <-resume:
+				case <-ctx.Done():
+					return
+				}
 			}
 
-			numRows := p.module.NumRows(int(patternIndex))
-			if rowIndex >= numRows {
-				rowIndex = 0
-				orderIndex++
-				continue
+			rowBuffer, update, ok, err := p.renderRow(end)
+			if err != nil {
+				errChan <- err
+				return
+			}
+			if !ok {
+				return
 			}
-
-			playerState.row = rowIndex
-			playerState.pattern = int(patternIndex)
-			playerState.order = orderIndex
 
 			if p.StateUpdateChan != nil {
-				p.StateUpdateChan <- PlayerStateUpdate{
-					Order:   orderIndex,
-					Pattern: int(patternIndex),
-					Row:     rowIndex,
-					Speed:   playerState.speed,
-					BPM:     playerState.bpm,
+				select {
+				case p.StateUpdateChan <- update:
+				case <-ctx.Done():
+					return
 				}
 			}
-
-			rowBuffer, newRow, newOrder := p.processRow(&playerState, patternIndex)
-			audioChan <- rowBuffer
-
-			if newOrder != -1 {
-				orderIndex = newOrder
-				rowIndex = newRow
-			} else if newRow != -1 {
-				rowIndex = newRow
-			} else {
-				rowIndex++
+			select {
+			case audioChan <- rowBuffer:
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
@@ -150,34 +232,448 @@ func (p *Player) renderSongByRow(stopChan <-chan struct{}) (<-chan []int, <-chan
 	return audioChan, errChan
 }
 
-func (p *Player) processRow(state *playerState, pattern int) ([]int, int, int) {
-	var rowBuffer []int
+// startRender returns the frame at which the rendering that starts at the
+// current position stops, as found by playbackEnd.
+func (p *Player) startRender() (end int, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	defer recoverFault(&err)
+	return p.playbackEnd(), nil
+}
+
+// renderRow mixes the next row, cut and faded out at end, the frame at which
+// playback stops if it is not zero. It reports false when playback has
+// stopped, and moves the position back to the start of the song.
+func (p *Player) renderRow(end int) (rowBuffer []float64, update PlayerStateUpdate, ok bool, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	defer recoverFault(&err)
+	start := p.state.frames
+	if end == 0 || start < end {
+		rowBuffer, update, ok = p.step(true)
+	}
+	if !ok {
+		p.reset()
+		return nil, PlayerStateUpdate{}, false, nil
+	}
+	if end > 0 {
+		rowBuffer = p.trimToEnd(rowBuffer, start, end)
+	}
+	return rowBuffer, update, true, nil
+}
+
+// playbackEnd returns the frame of the song at which playback from the
+// current position stops, or zero if it plays until the song ends by itself.
+// Finding the end order, or the end of a song to fade out, plays the song from
+// the start without mixing it. It is called with mu held.
+func (p *Player) playbackEnd() int {
+	start := p.state.frames
+	end := p.endFrame
+	limit := func(frame int) {
+		if end == 0 || frame < end {
+			end = frame
+		}
+	}
+	if p.maxFrames > 0 {
+		limit(start + p.maxFrames)
+	}
+	if p.stopOrder > 0 || p.fadeFrames > 0 {
+		saved := p.state
+		defer func() { p.state = saved }()
+		p.reset()
+		found := p.fastForward(func(state *playerState) bool {
+			return p.stopOrder > 0 && state.frames >= start && state.order >= p.stopOrder
+		})
+		if found || !p.nextPlayableRow(p.state) {
+			limit(p.state.frames)
+		}
+	}
+	return end
+}
+
+// trimToEnd cuts a row that starts at frame start at the end of playback, and
+// fades out the frames of the row that are within the fade-out before it.
+func (p *Player) trimToEnd(rowBuffer []float64, start, end int) []float64 {
+	channels := p.opts.NumChannels
+	frames := len(rowBuffer) / channels
+	if start+frames > end {
+		frames = end - start
+		rowBuffer = rowBuffer[:frames*channels]
+	}
+	if p.fadeFrames == 0 || start+frames <= end-p.fadeFrames {
+		return rowBuffer
+	}
+	for i := range frames {
+		remaining := end - start - i
+		if remaining >= p.fadeFrames {
+			continue
+		}
+		// A raised cosine fades smoothly out of the song and into silence.
+		gain := 0.5 - 0.5*math.Cos(math.Pi*float64(remaining)/float64(p.fadeFrames))
+		for ch := range channels {
+			rowBuffer[i*channels+ch] *= gain
+		}
+	}
+	return rowBuffer
+}
+
+// Pause suspends playback in place. Mixing stops before the next row, and an
+// audio player that implements Pauser stops its output as well, so that Resume
+// continues at the same sample.
+func (p *Player) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resumeChan != nil {
+		return
+	}
+	p.resumeChan = make(chan struct{})
+	if pauser, ok := p.sink.(Pauser); ok {
+		pauser.Pause()
+	}
+}
+
+// Resume continues playback after Pause.
+func (p *Player) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resumeChan == nil {
+		return
+	}
+	close(p.resumeChan)
+	p.resumeChan = nil
+	if pauser, ok := p.sink.(Pauser); ok {
+		pauser.Resume()
+	}
+}
+
+// Paused reports whether playback is paused.
+func (p *Player) Paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.resumeChan != nil
+}
+
+// SetChannelMute mutes or unmutes a channel, counting from 0. Muted channels
+// are still played, so that they sound as expected when they are unmuted.
+func (p *Player) SetChannelMute(ch int, mute bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ch >= 0 && ch < len(p.muted) {
+		p.muted[ch] = mute
+	}
+}
+
+// Solo mutes every channel except ch. Soloing a channel that is already the
+// only one playing unmutes all channels.
+func (p *Player) Solo(ch int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ch < 0 || ch >= len(p.muted) {
+		return
+	}
+	soloed := !p.muted[ch]
+	for i, muted := range p.muted {
+		if i != ch && !muted {
+			soloed = false
+		}
+	}
+	for i := range p.muted {
+		p.muted[i] = !soloed && i != ch
+	}
+}
+
+// ChannelMuted reports whether a channel is muted.
+func (p *Player) ChannelMuted(ch int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return ch >= 0 && ch < len(p.muted) && p.muted[ch]
+}
+
+// SetInstrumentMute mutes or unmutes the notes played with an instrument, as
+// numbered in the pattern data, the same way as SetChannelMute does.
+func (p *Player) SetInstrumentMute(instrument int, mute bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.mutedInstruments == nil {
+		p.mutedInstruments = make(map[int]bool)
+	}
+	p.mutedInstruments[instrument] = mute
+}
+
+// InstrumentMuted reports whether an instrument is muted.
+func (p *Player) InstrumentMuted(instrument int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.mutedInstruments[instrument]
+}
+
+// isMuted reports whether a voice playing on channel ch is muted, either by its
+// channel or by its instrument.
+func (p *Player) isMuted(ch int, state *channelState) bool {
+	return (ch < len(p.muted) && p.muted[ch]) || p.mutedInstruments[state.sampleIndex]
+}
+
+// Seek moves playback to the given order and row. The channel state, speed,
+// tempo and global volume are reconstructed by playing the song from the start
+// up to that row without mixing it. A row that cannot be reached that way, for
+// example one that is skipped by a pattern break, is played with the state of
+// the start of the song. A fault while playing up to the row returns a
+// *RenderError and leaves the position unchanged.
+func (p *Player) Seek(order, row int) (err error) {
+	if order < 0 || order >= p.module.SongLength() {
+		return fmt.Errorf("order %d is out of range", order)
+	}
+	pattern := p.module.PatternOrder()[order]
+	if pattern >= p.module.NumPatterns() {
+		return fmt.Errorf("order %d refers to missing pattern %d", order, pattern)
+	}
+	if row < 0 || row >= p.module.NumRows(pattern) {
+		return fmt.Errorf("row %d is out of range for pattern %d", row, pattern)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	saved := p.state
+	defer func() {
+		if err != nil {
+			p.state = saved
+		}
+	}()
+	defer recoverFault(&err)
+	p.reset()
+	if p.fastForward(func(state *playerState) bool {
+		return state.order == order && state.row == row
+	}) {
+		return nil
+	}
+	p.reset()
+	p.state.order, p.state.row = order, row
+	return nil
+}
+
+// SeekTime moves playback to the first row that starts at or after d, in the
+// same way as Seek. It returns an error if the song ends before d or a fault
+// stops it, in which case the position is left unchanged.
+func (p *Player) SeekTime(d time.Duration) (err error) {
+	if d < 0 {
+		return fmt.Errorf("negative seek time %v", d)
+	}
+	frames := p.durationToFrames(d)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	saved := p.state
+	defer func() {
+		if err != nil {
+			p.state = saved
+		}
+	}()
+	defer recoverFault(&err)
+	p.reset()
+	if p.fastForward(func(state *playerState) bool {
+		return state.frames >= frames
+	}) {
+		return nil
+	}
+	return fmt.Errorf("seek time %v is beyond the end of the song", d)
+}
+
+// Position returns the order, pattern, row, speed and tempo of the next row to
+// be played.
+func (p *Player) Position() PlayerStateUpdate {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nextPlayableRow(p.state)
+	return p.stateUpdate(p.state)
+}
+
+// Elapsed returns the playing time of the song up to the next row to be
+// played.
+func (p *Player) Elapsed() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.framesToDuration(p.state.frames)
+}
+
+// reset moves the player back to the start of the song with its initial state.
+func (p *Player) reset() {
+	state := &playerState{
+		speed:    p.module.DefaultSpeed(),
+		bpm:      p.module.DefaultBPM(),
+		channels: make([]channelState, p.module.NumChannels()),
+		rand:     rand.New(rand.NewPCG(1, 2)),
+	}
+	for i := range state.channels {
+		state.channels[i] = defaultChannelState()
+	}
+	if initializer, ok := p.ticker.(stateInitializer); ok {
+		initializer.initState(p, state)
+	}
+	p.state = state
+}
+
+// maxRowVisits is the number of times fastForward plays a row before it
+// considers the song to be looping without reaching its target.
+const maxRowVisits = 32
+
+// fastForward plays the song from the current state without mixing it until
+// done reports true for the next row to play. It reports whether that
+// happened before the song ended or started looping.
+func (p *Player) fastForward(done func(state *playerState) bool) bool {
+	visits := make(map[[2]int]int)
+	for p.nextPlayableRow(p.state) {
+		if done(p.state) {
+			return true
+		}
+		position := [2]int{p.state.order, p.state.row}
+		if visits[position]++; visits[position] > maxRowVisits {
+			return false
+		}
+		p.step(false)
+	}
+	return false
+}
+
+// nextPlayableRow moves the position of state past missing patterns and the
+// ends of patterns, and reports whether there is a row left to play.
+func (p *Player) nextPlayableRow(state *playerState) bool {
+	for state.order < p.module.SongLength() {
+		patternIndex := p.module.PatternOrder()[state.order]
+		if patternIndex >= p.module.NumPatterns() {
+			state.order++
+			continue
+		}
+		if state.row >= p.module.NumRows(patternIndex) {
+			state.row = 0
+			state.order++
+			continue
+		}
+		state.pattern = patternIndex
+		return true
+	}
+	return false
+}
+
+// step plays the next row and moves the position past it. The row is only
+// mixed if mix is set. It reports false when the song has ended. A fault of
+// the ticker panics with a *RenderError.
+func (p *Player) step(mix bool) ([]float64, PlayerStateUpdate, bool) {
+	state := p.state
+	if !p.nextPlayableRow(state) {
+		return nil, PlayerStateUpdate{}, false
+	}
+	update := p.stateUpdate(state)
+	defer p.catchFault(state, state.order, state.row)
+
+	rowBuffer, newRow, newOrder := p.processRow(state, state.pattern, mix)
+	if newOrder != -1 {
+		state.order = newOrder
+		state.row = newRow
+	} else if newRow != -1 {
+		state.row = newRow
+	} else {
+		state.row++
+	}
+	return rowBuffer, update, true
+}
+
+func (p *Player) stateUpdate(state *playerState) PlayerStateUpdate {
+	return PlayerStateUpdate{
+		Order:   state.order,
+		Pattern: state.pattern,
+		Row:     state.row,
+		Speed:   state.speed,
+		BPM:     state.bpm,
+	}
+}
+
+// processRow plays the row of state. Without mix the effects are processed
+// but no audio is rendered, which is used to fast-forward the song.
+func (p *Player) processRow(state *playerState, pattern int, mix bool) ([]float64, int, int) {
+	var rowBuffer []float64
+	position := p.stateUpdate(state)
 
 	nextOrder := -1
 	nextRow := -1
 
+	state.tickDelay = 0
+	state.channel = -1
 	if state.patternDelay > 0 {
 		state.patternDelay--
 	} else {
-		for tick := 0; tick < state.speed; tick++ {
+		for tick := 0; tick < state.speed+state.tickDelay; tick++ {
 			samplesPerTick := int(float64(p.opts.SampleRate) * 2.5 / float64(state.bpm))
-			tickBuffer := make([]int, samplesPerTick*p.opts.NumChannels)
+			var tickBuffer []float64
+			if mix {
+				tickBuffer = make([]float64, samplesPerTick*p.opts.NumChannels)
+			}
 
 			for ch := 0; ch < p.module.NumChannels(); ch++ {
+				state.channel = ch
 				cell := p.module.PatternCell(pattern, state.row, ch)
 				channel := &state.channels[ch]
+				if tick == 0 {
+					channel.markNote(&cell)
+				}
+				channel.clearOutput()
 				p.ticker.ProcessTick(p, state, channel, &cell, &state.speed, &state.bpm, &nextRow, &nextOrder, &state.order, tick)
+				p.declick(channel, tickBuffer)
 				if channel.sample != nil && channel.period > 0 {
 					p.applyPorta(channel)
-					p.ticker.RenderChannelTick(p, channel, tickBuffer, samplesPerTick)
+					if p.isMuted(ch, channel) {
+						p.renderMuted(channel, tickBuffer, samplesPerTick)
+					} else if mix {
+						p.ticker.RenderChannelTick(p, channel, tickBuffer, samplesPerTick)
+					}
 				}
+				channel.markMixed()
+			}
+			p.processVirtualChannels(state, tickBuffer, samplesPerTick, tick)
+			state.channel = -1
+			if mix && state.paula != nil {
+				state.paula.process(tickBuffer, state.ledFilter)
+			}
+			if mix {
+				p.sendTelemetry(state, position, tick, state.frames)
 			}
 			rowBuffer = append(rowBuffer, tickBuffer...)
+			state.frames += samplesPerTick
 		}
 	}
 	return rowBuffer, nextRow, nextOrder
 }
 
+// processVirtualChannels advances and mixes the voices that were moved to the
+// background by a New Note Action, dropping the ones that have finished. They
+// are only mixed if tickBuffer is not nil.
+func (p *Player) processVirtualChannels(state *playerState, tickBuffer []float64, samplesPerTick, tick int) {
+	vt, ok := p.ticker.(virtualChannelTicker)
+	if !ok {
+		return
+	}
+	live := state.virtualChannels[:0]
+	for i := range state.virtualChannels {
+		channel := &state.virtualChannels[i]
+		state.channel = channel.channel
+		channel.clearOutput()
+		vt.processVirtualTick(p, state, channel, tick)
+		p.declick(channel, tickBuffer)
+		if channel.sample == nil {
+			continue
+		}
+		if p.isMuted(channel.channel, channel) {
+			p.renderMuted(channel, tickBuffer, samplesPerTick)
+		} else if tickBuffer != nil {
+			p.ticker.RenderChannelTick(p, channel, tickBuffer, samplesPerTick)
+		}
+		channel.markMixed()
+		if channel.sample != nil {
+			live = append(live, *channel)
+		}
+	}
+	state.virtualChannels = live
+}
+
 func (p *Player) applyPorta(state *channelState) {
 	if state.portaTarget > 0 {
 		if state.period < state.portaTarget {
@@ -199,11 +695,101 @@ func (p *Player) applyPorta(state *channelState) {
 	}
 }
 
-func (p *Player) pan(numChannels int, panning float64, sampleValue float64) (int, int) {
-	if numChannels == 1 {
-		return int(sampleValue), 0
+// mixMono adds a frame of a mono voice to tickBuffer at the given volume and
+// panning.
+func (p *Player) mixMono(state *channelState, tickBuffer []float64, frame int, sampleValue, volume, panning float64) {
+	if p.opts.NumChannels == 1 {
+		p.mixFrame(state, tickBuffer, frame, sampleValue, 0, volume, 0)
+		return
+	}
+	p.mixFrame(state, tickBuffer, frame, sampleValue, sampleValue, volume*(1.0-panning), volume*panning)
+}
+
+// mixStereo adds a frame of a stereo voice to tickBuffer at the given volume.
+func (p *Player) mixStereo(state *channelState, tickBuffer []float64, frame int, left, right, volume float64) {
+	p.mixFrame(state, tickBuffer, frame, left, right, volume, volume)
+}
+
+// mixFrame adds a frame to tickBuffer. The gains of the channel move to
+// leftGain and rightGain over PlayerOptions.VolumeRamp frames, so that
+// volume and panning changes do not click.
+func (p *Player) mixFrame(state *channelState, tickBuffer []float64, frame int, left, right, leftGain, rightGain float64) {
+	target := [2]float64{leftGain, rightGain}
+	if target != state.rampTarget {
+		state.rampTarget = target
+		state.rampFrames = p.opts.VolumeRamp
+	}
+	if state.rampFrames > 0 {
+		for i := range state.gain {
+			state.gain[i] += (target[i] - state.gain[i]) / float64(state.rampFrames)
+		}
+		state.rampFrames--
+	} else {
+		state.gain = target
+	}
+
+	state.lastOut = [2]float64{left * state.gain[0], right * state.gain[1]}
+	if p.telemetry != nil {
+		p.markLevel(state, tickBuffer, frame)
+	}
+	offset := frame * p.opts.NumChannels
+	tickBuffer[offset] += state.lastOut[0]
+	if p.opts.NumChannels > 1 {
+		tickBuffer[offset+1] += state.lastOut[1]
+	}
+}
+
+// declick fades out the last output of a channel whose voice was cut or
+// replaced since the previous tick, and makes the new voice ramp in. Sample
+// positions only move while a channel is mixed, so any other change means that
+// a note was triggered, retriggered or cut.
+func (p *Player) declick(state *channelState, tickBuffer []float64) {
+	if p.opts.VolumeRamp == 0 ||
+		(state.sample != nil) == state.mixActive && state.sampleIndex == state.mixIndex && state.samplePos == state.mixPos {
+		return
 	}
-	return int(sampleValue * (1.0 - panning)), int(sampleValue * panning)
+	p.fadeOut(state, tickBuffer)
+	state.markMixed()
+}
+
+// renderMuted fades out a channel that was muted and renders it to a scratch
+// buffer, so that its sample position keeps moving.
+func (p *Player) renderMuted(state *channelState, tickBuffer []float64, samplesPerTick int) {
+	if tickBuffer == nil {
+		return
+	}
+	p.fadeOut(state, tickBuffer)
+	if cap(p.scratch) < len(tickBuffer) {
+		p.scratch = make([]float64, len(tickBuffer))
+	}
+	p.ticker.RenderChannelTick(p, state, p.scratch[:len(tickBuffer)], samplesPerTick)
+	p.fadeOut(state, nil)
+	state.clearOutput()
+}
+
+// fadeOut fades out the last output of a channel that stops being mixed, and
+// makes it ramp in when it is mixed again.
+func (p *Player) fadeOut(state *channelState, tickBuffer []float64) {
+	if tickBuffer != nil && state.lastOut != [2]float64{} {
+		frames := min(p.opts.VolumeRamp, len(tickBuffer)/p.opts.NumChannels)
+		for i := 0; i < frames; i++ {
+			fade := float64(frames-i) / float64(frames+1)
+			for ch := 0; ch < p.opts.NumChannels && ch < len(state.lastOut); ch++ {
+				tickBuffer[i*p.opts.NumChannels+ch] += state.lastOut[ch] * fade
+			}
+		}
+	}
+	state.lastOut = [2]float64{}
+	state.gain = [2]float64{}
+	state.rampTarget = [2]float64{}
+	state.rampFrames = 0
+}
+
+// markMixed records the voice of a channel after it was mixed, for declick.
+func (state *channelState) markMixed() {
+	state.mixActive = state.sample != nil
+	state.mixIndex = state.sampleIndex
+	state.mixPos = state.samplePos
 }
 
 type playerState struct {
@@ -217,6 +803,13 @@ type playerState struct {
 	patternDelay     int
 	patternLoopRow   int
 	patternLoopCount int
+	tickDelay        int
+	virtualChannels  []channelState
+	frames           int // frames played since the start of the song
+	channel          int // being played, for the location of faults
+	ledFilter        bool
+	paula            *paulaFilter
+	rand             *rand.Rand // of random waveforms, seeded by reset
 }
 
 type channelState struct {
@@ -253,6 +846,69 @@ type channelState struct {
 	lastVolSlide       byte
 	lastPorta          byte
 	stereo             float64
+	paula              *paulaVoice
+
+	// Mixer state, for volume ramping and declicking
+	gain       [2]float64
+	rampTarget [2]float64
+	rampFrames int
+	lastOut    [2]float64
+	mixActive  bool
+	mixIndex   int
+	mixPos     float64
+
+	// Telemetry of the last tick mixed, see markNote and markOutput
+	note       string
+	mixPeriod  uint16
+	frequency  float64
+	mixVolume  float64
+	mixPanning float64
+	peak       float64 // of the output of the tick, at 16-bit full scale
+	scope      [telemetryScopeLength]float64
+
+	// Impulse Tracker state
+	channel            int
+	itNote             int
+	tonePortaTarget    uint16
+	channelVolume      float64
+	surround           bool
+	reverse            bool
+	fading             bool
+	fadeoutVolume      int
+	nna                byte
+	volumeEnvelopeOn   bool
+	panningEnvelopeOn  bool
+	pitchEnvelopeOn    bool
+	pitchEnvelopePos   uint16
+	autovibratoDepth   int
+	panbrelloSpeed     uint8
+	panbrelloDepth     uint8
+	panbrelloWave      uint8
+	panbrelloPos       uint8
+	tremorCount        int
+	retrigCount        int
+	lastVolColSlide    byte
+	lastChanVolSlide   byte
+	lastPanSlide       byte
+	lastGlobalVolSlide byte
+	lastTempoSlide     byte
+	lastRetrig         byte
+	lastArpeggio       byte
+	lastSpecial        byte
+	sampleOffsetHigh   byte
+	filterCutoff       byte
+	filterResonance    byte
+	filterModifier     int
+	filterY1           float64
+	filterY2           float64
+	vibratoDelta       int
+	arpeggioDelta      int
+	tremoloDelta       float64
+	panbrelloDelta     float64
+	tremorMute         bool
+	outPeriod          uint16
+	outVolume          float64
+	outPanning         float64
 }
 
 func defaultChannelState() channelState {
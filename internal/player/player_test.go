@@ -0,0 +1,468 @@
+package player
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jesseward/impulse/pkg/loader"
+	"github.com/jesseward/impulse/pkg/module"
+	"github.com/jesseward/impulse/pkg/protracker"
+)
+
+func newTestPlayer(t *testing.T, name string) *Player {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "..", "examples", name))
+	if err != nil {
+		t.Fatalf("failed to read test file: %v", err)
+	}
+	m, err := loader.LoadBytes(data)
+	if err != nil {
+		t.Fatalf("failed to load %s: %v", name, err)
+	}
+	return NewPlayer(m, func(string, ...interface{}) {}, nil, DefaultPlayerOptions())
+}
+
+func TestPlayer_Seek(t *testing.T) {
+	p := newTestPlayer(t, "space_debris.mod")
+	if err := p.Seek(3, 16); err != nil {
+		t.Fatalf("Seek() failed: %v", err)
+	}
+	if pos := p.Position(); pos.Order != 3 || pos.Row != 16 {
+		t.Errorf("Position() = order %d row %d, want order 3 row 16", pos.Order, pos.Row)
+	}
+	elapsed := p.Elapsed()
+	if elapsed <= 0 {
+		t.Fatalf("Elapsed() = %v after seeking, want a positive time", elapsed)
+	}
+
+	if err := p.Seek(0, 0); err != nil {
+		t.Fatalf("Seek() failed: %v", err)
+	}
+	if err := p.SeekTime(elapsed); err != nil {
+		t.Fatalf("SeekTime() failed: %v", err)
+	}
+	if pos := p.Position(); pos.Order != 3 || pos.Row != 16 {
+		t.Errorf("SeekTime(%v) moved to order %d row %d, want order 3 row 16", elapsed, pos.Order, pos.Row)
+	}
+
+	if err := p.Seek(p.module.SongLength(), 0); err == nil {
+		t.Error("Seek() past the last order succeeded")
+	}
+	if err := p.SeekTime(time.Hour); err == nil {
+		t.Error("SeekTime() past the end of the song succeeded")
+	}
+	if pos := p.Position(); pos.Order != 3 || pos.Row != 16 {
+		t.Errorf("a failed SeekTime() moved to order %d row %d", pos.Order, pos.Row)
+	}
+}
+
+// loopingModule returns a MOD file that plays order 0, then jumps from row 10
+// of order 1 back to the start of order 1.
+func loopingModule(t *testing.T) *protracker.ModFile {
+	t.Helper()
+	patterns := [][]protracker.ChannelSequence{
+		make([]protracker.ChannelSequence, 64*4),
+		make([]protracker.ChannelSequence, 64*4),
+	}
+	patterns[1][10*4].Effect = module.Effect{Command: 0xB, X: 0, Y: 1}
+	m, err := protracker.New("loop", 4, nil, []byte{0, 1}, patterns)
+	if err != nil {
+		t.Fatalf("failed to create module: %v", err)
+	}
+	return m
+}
+
+func TestAnalyze(t *testing.T) {
+	// At speed 6 and 125 BPM every row lasts 6 ticks of 882 frames.
+	const rowFrames = 6 * 882
+
+	a, err := Analyze(newTestPlayer(t, "space_debris.mod").module)
+	if err != nil {
+		t.Fatalf("Analyze() failed: %v", err)
+	}
+	if a.Loops || a.Duration < 5*time.Minute {
+		t.Errorf("Analyze(space_debris.mod) = %+v, want a song of over 5 minutes that ends", a)
+	}
+
+	a, err = Analyze(loopingModule(t))
+	if err != nil {
+		t.Fatalf("Analyze() failed: %v", err)
+	}
+	if !a.Loops || a.LoopOrder != 1 || a.LoopRow != 0 {
+		t.Errorf("Analyze() = %+v, want a loop back to order 1 row 0", a)
+	}
+	if a.frames != 75*rowFrames || a.loopFrames != 64*rowFrames {
+		t.Errorf("Analyze() = %d frames looping at %d, want %d looping at %d", a.frames, a.loopFrames, 75*rowFrames, 64*rowFrames)
+	}
+}
+
+func TestPlayer_SetLoops(t *testing.T) {
+	const rowFrames = 6 * 882
+
+	opts := DefaultPlayerOptions()
+	p := NewPlayer(loopingModule(t), func(string, ...interface{}) {}, nil, opts)
+	p.SetLoops(3)
+	buf := &bytes.Buffer{}
+	if err := p.WriteRaw(NewStreamPlayer(nopCloser{buf}, opts), nil); err != nil {
+		t.Fatalf("WriteRaw() failed: %v", err)
+	}
+	want := (64 + 3*11) * rowFrames * opts.NumChannels * opts.BitDepth
+	if buf.Len() != want {
+		t.Errorf("WriteRaw() wrote %d bytes, want %d", buf.Len(), want)
+	}
+}
+
+func TestPlayer_SetMaxDuration(t *testing.T) {
+	opts := DefaultPlayerOptions()
+	p := NewPlayer(loopingModule(t), func(string, ...interface{}) {}, nil, opts)
+	p.SetMaxDuration(2500 * time.Millisecond)
+	p.SetFadeOut(time.Second)
+	buf := &bytes.Buffer{}
+	if err := p.WriteRaw(NewStreamPlayer(nopCloser{buf}, opts), nil); err != nil {
+		t.Fatalf("WriteRaw() failed: %v", err)
+	}
+	if want := 110250 * opts.NumChannels * opts.BitDepth; buf.Len() != want {
+		t.Errorf("WriteRaw() wrote %d bytes, want %d", buf.Len(), want)
+	}
+}
+
+func TestPlayer_SetEndOrder(t *testing.T) {
+	const rowFrames = 6 * 882
+
+	patterns := [][]protracker.ChannelSequence{
+		make([]protracker.ChannelSequence, 64*4),
+		make([]protracker.ChannelSequence, 64*4),
+	}
+	m, err := protracker.New("orders", 4, nil, []byte{0, 1, 0, 1}, patterns)
+	if err != nil {
+		t.Fatalf("failed to create module: %v", err)
+	}
+	opts := DefaultPlayerOptions()
+	p := NewPlayer(m, func(string, ...interface{}) {}, nil, opts)
+	if err := p.SetEndOrder(4); err == nil {
+		t.Error("SetEndOrder(4) succeeded for a song of 4 orders, want an error")
+	}
+	if err := p.SetEndOrder(2); err != nil {
+		t.Fatalf("SetEndOrder(2) failed: %v", err)
+	}
+	if err := p.Seek(1, 0); err != nil {
+		t.Fatalf("Seek(1, 0) failed: %v", err)
+	}
+	buf := &bytes.Buffer{}
+	if err := p.WriteRaw(NewStreamPlayer(nopCloser{buf}, opts), nil); err != nil {
+		t.Fatalf("WriteRaw() failed: %v", err)
+	}
+	if want := 2 * 64 * rowFrames * opts.NumChannels * opts.BitDepth; buf.Len() != want {
+		t.Errorf("WriteRaw() wrote %d bytes, want %d", buf.Len(), want)
+	}
+}
+
+func TestPlayer_trimToEnd(t *testing.T) {
+	opts := DefaultPlayerOptions()
+	p := NewPlayer(loopingModule(t), func(string, ...interface{}) {}, nil, opts)
+	p.SetFadeOut(10 * time.Millisecond) // 441 frames
+
+	row := make([]float64, 1000*opts.NumChannels)
+	for i := range row {
+		row[i] = 1
+	}
+	// The row starts 500 frames before the end, 59 before the fade-out.
+	row = p.trimToEnd(row, 1000, 1500)
+	if len(row) != 500*opts.NumChannels {
+		t.Fatalf("trimToEnd() returned %d samples, want %d", len(row), 500*opts.NumChannels)
+	}
+	for i := 0; i < len(row); i += opts.NumChannels {
+		frame := i / opts.NumChannels
+		switch {
+		case frame < 59 && row[i] != 1:
+			t.Fatalf("frame %d before the fade-out has gain %v", frame, row[i])
+		case frame > 59 && row[i] >= row[i-opts.NumChannels]:
+			t.Fatalf("gain of frame %d does not fade out: %v after %v", frame, row[i], row[i-opts.NumChannels])
+		}
+	}
+	if last := row[len(row)-1]; last > 1e-4 {
+		t.Errorf("gain of the last frame = %v, want near 0", last)
+	}
+}
+
+func TestPlayer_PauseResume(t *testing.T) {
+	opts := DefaultPlayerOptions()
+	render := func(pause bool) []byte {
+		p := NewPlayer(loopingModule(t), func(string, ...interface{}) {}, nil, opts)
+		p.SetLoops(1)
+		if pause {
+			p.Pause()
+		}
+		buf := &bytes.Buffer{}
+		done := make(chan error)
+		go func() { done <- p.WriteRaw(NewStreamPlayer(nopCloser{buf}, opts), nil) }()
+		if pause {
+			select {
+			case <-done:
+				t.Fatal("WriteRaw() finished while paused")
+			case <-time.After(50 * time.Millisecond):
+			}
+			if !p.Paused() {
+				t.Error("Paused() = false after Pause()")
+			}
+			p.Resume()
+		}
+		if err := <-done; err != nil {
+			t.Fatalf("WriteRaw() failed: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	if want, got := render(false), render(true); !bytes.Equal(got, want) {
+		t.Errorf("paused playback wrote %d bytes, want the same %d bytes as uninterrupted playback", len(got), len(want))
+	}
+}
+
+func TestPlayer_mixFrame(t *testing.T) {
+	opts := DefaultPlayerOptions()
+	opts.VolumeRamp = 4
+	p := &Player{opts: opts}
+	state := defaultChannelState()
+	buf := make([]float64, 8*opts.NumChannels)
+
+	// The gain ramps from 0 to the full volume over 4 frames.
+	for i := 0; i < 8; i++ {
+		p.mixMono(&state, buf, i, 1000, 1, 0)
+	}
+	want := []float64{250, 500, 750, 1000, 1000}
+	for i, w := range want {
+		if got := buf[i*opts.NumChannels]; got != w {
+			t.Errorf("frame %d = %v, want %v", i, got, w)
+		}
+	}
+	if got := buf[1]; got != 0 {
+		t.Errorf("right channel of a hard left voice = %v, want 0", got)
+	}
+}
+
+func TestPlayer_declick(t *testing.T) {
+	opts := DefaultPlayerOptions()
+	opts.VolumeRamp = 4
+	p := &Player{opts: opts}
+	state := defaultChannelState()
+	state.samplePos = 10
+	state.markMixed()
+	state.lastOut = [2]float64{1000, 1000}
+	state.gain = [2]float64{1, 1}
+
+	buf := make([]float64, 8*opts.NumChannels)
+	p.declick(&state, buf)
+	if buf[0] != 0 {
+		t.Errorf("declick() without a new note changed the output to %v", buf[0])
+	}
+
+	// A retriggered note fades out the previous output.
+	state.samplePos = 0
+	p.declick(&state, buf)
+	want := []float64{800, 600, 400, 200, 0}
+	for i, w := range want {
+		if got := math.Round(buf[i*opts.NumChannels]); got != w {
+			t.Errorf("frame %d of the fade = %v, want %v", i, got, w)
+		}
+	}
+	if state.gain != [2]float64{} {
+		t.Errorf("gain after a new note = %v, want it to ramp in from 0", state.gain)
+	}
+}
+
+func TestPlayer_SetChannelMute(t *testing.T) {
+	render := func(p *Player) (peak float64) {
+		for range 32 {
+			buf, _, _ := p.step(true)
+			for _, v := range buf {
+				peak = math.Max(peak, math.Abs(v))
+			}
+		}
+		return peak
+	}
+
+	if peak := render(newTestPlayer(t, "space_debris.mod")); peak == 0 {
+		t.Fatal("rendering space_debris.mod gave silence")
+	}
+	p := newTestPlayer(t, "space_debris.mod")
+	for ch := range p.module.NumChannels() {
+		p.SetChannelMute(ch, true)
+	}
+	if peak := render(p); peak != 0 {
+		t.Errorf("rendering with all channels muted peaked at %v, want silence", peak)
+	}
+}
+
+func TestPlayer_Solo(t *testing.T) {
+	p := newTestPlayer(t, "space_debris.mod")
+	p.Solo(1)
+	for ch := range p.module.NumChannels() {
+		if got, want := p.ChannelMuted(ch), ch != 1; got != want {
+			t.Errorf("ChannelMuted(%d) = %v after Solo(1), want %v", ch, got, want)
+		}
+	}
+	p.Solo(1)
+	for ch := range p.module.NumChannels() {
+		if p.ChannelMuted(ch) {
+			t.Errorf("ChannelMuted(%d) = true after soloing channel 1 twice", ch)
+		}
+	}
+}
+
+func TestPlayer_SetInstrumentMute(t *testing.T) {
+	render := func(mute func(p *Player)) []float64 {
+		p := newTestPlayer(t, "space_debris.mod")
+		mute(p)
+		var out []float64
+		for range 32 {
+			buf, _, _ := p.step(true)
+			out = append(out, buf...)
+		}
+		return out
+	}
+
+	// Stems of every instrument add up to the full mix.
+	full := render(func(*Player) {})
+	sum := make([]float64, len(full))
+	numSamples := len(newTestPlayer(t, "space_debris.mod").module.Samples())
+	for ins := 1; ins <= numSamples; ins++ {
+		stem := render(func(p *Player) {
+			for other := 1; other <= numSamples; other++ {
+				p.SetInstrumentMute(other, other != ins)
+			}
+		})
+		for i, v := range stem {
+			sum[i] += v
+		}
+	}
+	for i := range full {
+		if math.Abs(sum[i]-full[i]) > 1e-6 {
+			t.Fatalf("sum of the instrument stems at %d = %v, want %v", i, sum[i], full[i])
+		}
+	}
+}
+
+// faultTicker plays a module with ticker, and panics on channel 2 of row 3 of
+// order 1 with an index out of range.
+type faultTicker struct {
+	Ticker
+}
+
+func (f faultTicker) ProcessTick(p *Player, playerState *playerState, channelState *channelState, cell *module.Cell, speed, bpm, nextRow, nextOrder, currentOrder *int, tick int) {
+	if playerState.order == 1 && playerState.row == 3 && playerState.channel == 2 {
+		var samples []int
+		_ = samples[playerState.row]
+	}
+	f.Ticker.ProcessTick(p, playerState, channelState, cell, speed, bpm, nextRow, nextOrder, currentOrder, tick)
+}
+
+func TestPlayer_Render_fault(t *testing.T) {
+	opts := DefaultPlayerOptions()
+	p := NewPlayer(loopingModule(t), func(string, ...interface{}) {}, nil, opts)
+	p.ticker = faultTicker{p.ticker}
+
+	err := p.Render(context.Background(), NewStreamPlayer(nopCloser{io.Discard}, opts))
+	var renderErr *RenderError
+	if !errors.As(err, &renderErr) {
+		t.Fatalf("Render() = %v, want a *RenderError", err)
+	}
+	if renderErr.Order != 1 || renderErr.Row != 3 || renderErr.Channel != 2 {
+		t.Errorf("Render() = %v, want a fault at order 1, row 3, channel 2", err)
+	}
+	if !strings.Contains(err.Error(), "row 3, channel 3:") {
+		t.Errorf("Render() = %q, want the message to number the channel from one", err)
+	}
+	var runtimeErr runtime.Error
+	if !errors.As(err, &runtimeErr) {
+		t.Errorf("Render() = %v, want it to wrap the runtime error", err)
+	}
+
+	// Seeking plays the song up to the fault as well.
+	if err := p.SeekTime(time.Minute); !errors.As(err, &renderErr) {
+		t.Errorf("SeekTime() = %v, want a *RenderError", err)
+	}
+	if err := p.SetLoops(1); !errors.As(err, &renderErr) {
+		t.Errorf("SetLoops() = %v, want a *RenderError", err)
+	}
+
+	s, err := NewStream(loopingModule(t), opts)
+	if err != nil {
+		t.Fatalf("NewStream() failed: %v", err)
+	}
+	s.Player().ticker = faultTicker{s.Player().ticker}
+	if _, err := io.Copy(io.Discard, s); !errors.As(err, &renderErr) {
+		t.Errorf("reading a stream = %v, want a *RenderError", err)
+	}
+}
+
+func TestPlayer_Render_context(t *testing.T) {
+	opts := DefaultPlayerOptions()
+	p := NewPlayer(loopingModule(t), func(string, ...interface{}) {}, nil, opts)
+
+	// The song loops forever, so only the deadline stops it.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := p.Render(ctx, NewStreamPlayer(nopCloser{io.Discard}, opts)); err != context.DeadlineExceeded {
+		t.Errorf("Render() = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := p.Render(ctx, NewStreamPlayer(nopCloser{io.Discard}, opts)); err != context.Canceled {
+		t.Errorf("Render() = %v, want %v", err, context.Canceled)
+	}
+
+	stop := make(chan struct{})
+	close(stop)
+	if err := p.WriteRaw(NewStreamPlayer(nopCloser{io.Discard}, opts), stop); err != nil {
+		t.Errorf("WriteRaw() after a stop = %v, want nil", err)
+	}
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+var errWrite = errors.New("disk full")
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errWrite }
+
+func TestPlayer_Render_writeError(t *testing.T) {
+	opts := DefaultPlayerOptions()
+	p := NewPlayer(loopingModule(t), func(string, ...interface{}) {}, nil, opts)
+	if err := p.Render(context.Background(), NewStreamPlayer(nopCloser{failingWriter{}}, opts)); !errors.Is(err, errWrite) {
+		t.Errorf("Render() = %v, want %v", err, errWrite)
+	}
+}
+
+// otherFormat is a module of a format that the player has no ticker for.
+type otherFormat struct{ module.Module }
+
+func (otherFormat) Type() string { return "Other Format" }
+
+func TestPlayer_Render_unplayable(t *testing.T) {
+	m := otherFormat{loopingModule(t)}
+	if Playable(m) {
+		t.Error("Playable() = true for a format without a ticker")
+	}
+	if !Playable(m.Module) {
+		t.Error("Playable() = false for a Protracker module")
+	}
+	opts := DefaultPlayerOptions()
+	var buf bytes.Buffer
+	p := NewPlayer(m, func(string, ...interface{}) {}, nil, opts)
+	if err := p.Render(context.Background(), NewStreamPlayer(nopCloser{&buf}, opts)); err == nil {
+		t.Error("Render() = nil, want an error for a format without a ticker")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Render() wrote %d bytes, want none", buf.Len())
+	}
+}
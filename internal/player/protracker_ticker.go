@@ -1,6 +1,11 @@
 package player
 
-import "github.com/jesseward/impulse/pkg/module"
+import (
+	"math"
+
+	"github.com/jesseward/impulse/pkg/module"
+	"github.com/jesseward/impulse/pkg/protracker"
+)
 
 var periodTable = [16 * 36]uint16{
 	856, 808, 762, 720, 678, 640, 604, 570, 538, 508, 480, 453, 428, 404, 381, 360, 339, 320, 302, 285, 269, 254, 240, 226, 214, 202, 190, 180, 170, 160, 151, 143, 135, 127, 120, 113,
@@ -25,6 +30,18 @@ var periodTable = [16 * 36]uint16{
 
 type ProtrackerTicker struct{}
 
+// initState sets up the emulation of the Amiga audio output, if it is enabled.
+func (t *ProtrackerTicker) initState(p *Player, playerState *playerState) {
+	if p.opts.Amiga == AmigaOff {
+		return
+	}
+	playerState.paula = newPaulaFilter(p.opts.Amiga, p.opts.SampleRate, p.opts.NumChannels)
+	for i := range playerState.channels {
+		playerState.channels[i].panning = amigaPanning(i)
+		playerState.channels[i].paula = &paulaVoice{}
+	}
+}
+
 func (t *ProtrackerTicker) ProcessTick(p *Player, playerState *playerState, channelState *channelState, cell *module.Cell, speed, bpm, nextRow, nextOrder, currentOrder *int, tick int) {
 	if tick == 0 {
 		t.handleTickZero(p, cell, channelState)
@@ -54,6 +71,19 @@ func (t *ProtrackerTicker) handleTickZero(p *Player, cell *module.Cell, state *c
 func (t *ProtrackerTicker) handleEffect(p *Player, state *channelState, cell *module.Cell, speed, bpm, nextRow, nextOrder, currentOrder *int, tick int, playerState *playerState) {
 	effect := cell.Effect
 	val := cell.EffectParam
+	if mod, ok := p.module.(*protracker.ModFile); ok && mod.IsSoundtracker() {
+		if mod.IsUltimateSoundtracker() {
+			t.handleUltimateSoundtrackerEffect(state, cell, tick)
+			return
+		}
+		// Soundtracker has no CIA tempo, so F always sets the speed.
+		if effect == 0x0F {
+			if val > 0 {
+				*speed = int(val)
+			}
+			return
+		}
+	}
 	switch effect {
 	// Arpeggio alternates between the base note and two other notes, creating a chord-like effect.
 	case 0x00: // Arpeggio
@@ -106,9 +136,12 @@ func (t *ProtrackerTicker) handleEffect(p *Player, state *channelState, cell *mo
 			state.tremoloDepth = val & 0x0F
 		}
 		applyTremolo(state)
-	// Set Panning sets the stereo panning of the channel.
+	// Set Panning sets the stereo panning of the channel. Paula channels are
+	// hard panned, so it is ignored when emulating an Amiga.
 	case 0x08: // Set Panning
-		state.panning = float64(val) / 255.0
+		if state.paula == nil {
+			state.panning = float64(val) / 255.0
+		}
 	// Set Sample Offset starts the sample from a specific offset.
 	case 0x09: // Set Sample Offset
 		handleSampleOffset(state, val)
@@ -141,8 +174,41 @@ func (t *ProtrackerTicker) handleEffect(p *Player, state *channelState, cell *mo
 	}
 }
 
+// handleUltimateSoundtrackerEffect handles the two effects known to Ultimate Soundtracker.
+func (t *ProtrackerTicker) handleUltimateSoundtrackerEffect(state *channelState, cell *module.Cell, tick int) {
+	val := cell.EffectParam
+	switch cell.Effect {
+	// Arpeggio works as effect 0 does in later trackers.
+	case 0x01: // Arpeggio
+		if val > 0 {
+			applyArpeggio(state, val, tick, t)
+		}
+	// Pitch Bend slides the pitch down by y, or up by x when y is zero.
+	case 0x02: // Pitch Bend
+		if tick == 0 {
+			return
+		}
+		if val&0x0F > 0 {
+			state.period += uint16(val & 0x0F)
+			if state.period > 856 {
+				state.period = 856
+			}
+		} else if val>>4 > 0 {
+			state.period -= uint16(val >> 4)
+			if state.period < 113 {
+				state.period = 113
+			}
+		}
+	}
+}
+
 func (t *ProtrackerTicker) handleExtendedEffect(state *channelState, command, value uint8, nextRow *int, tick int, playerState *playerState) {
 	switch command {
+	// Set Filter turns the Amiga LED filter on with E00 and off with E01.
+	case 0x00: // Set Filter
+		if tick == 0 {
+			playerState.ledFilter = value&1 == 0
+		}
 	// Fine Porta Up slides the pitch of the note up by a small amount.
 	case 0x01: // Fine Porta Up
 		if tick == 0 {
@@ -236,35 +302,44 @@ func (t *ProtrackerTicker) GetPeriod(period uint16, offset int) uint16 {
 	return periodTable[finetune*36+note]
 }
 
-func (t *ProtrackerTicker) RenderChannelTick(p *Player, state *channelState, tickBuffer []int, samplesPerTick int) {
+func (t *ProtrackerTicker) RenderChannelTick(p *Player, state *channelState, tickBuffer []float64, samplesPerTick int) {
 	if state.sample == nil || state.period == 0 || state.sample.Length() == 0 || state.sampleIndex == -1 {
 		return
 	}
 
 	freq := 7093789.2 / (float64(state.period) * 2.0)
 	step := freq / float64(p.opts.SampleRate)
+	state.markOutput(state.period, freq, state.volume, state.panning)
+
+	// A looped sample plays to the end of its loop, not of its data.
+	end := float64(state.sample.Length())
+	loopStart := float64(state.sample.LoopStart())
+	loopEnd := math.Min(loopStart+float64(state.sample.LoopLength()), end)
+	looped := loopEnd-loopStart > 2
+	reader := newSampleReader(state.sample.Data(), int(end))
+	if looped {
+		end = loopEnd
+		reader = reader.withLoop(int(loopStart), int(loopEnd), false)
+	}
 
 	for i := 0; i < samplesPerTick; i++ {
-		sampleLength := float64(state.sample.Length() * 2)
-		loopStart := float64(state.sample.LoopStart() * 2)
-		loopLength := float64(state.sample.LoopLength() * 2)
 
-		if state.samplePos >= sampleLength {
-			if loopLength > 1 {
-				state.samplePos = loopStart
+		if state.samplePos >= end {
+			if looped {
+				state.samplePos = loopStart + math.Mod(state.samplePos-loopStart, loopEnd-loopStart)
 			} else {
 				continue
 			}
 		}
 
 		if int(state.samplePos) < len(state.sample.Data()) {
-			sampleValue := float64(state.sample.Data()[int(state.samplePos)]) * state.volume
-			left, right := p.pan(p.opts.NumChannels, state.panning, sampleValue)
-
-			offset := i * p.opts.NumChannels
-			tickBuffer[offset] += left
-			if p.opts.NumChannels > 1 {
-				tickBuffer[offset+1] += right
+			if state.paula != nil {
+				// Paula steps to each new volume along with the sample points.
+				sampleValue := state.paula.output(reader.at(int(state.samplePos))*state.volume, state.samplePos, step)
+				p.mixMono(state, tickBuffer, i, sampleValue, 1, state.panning)
+			} else {
+				sampleValue := p.opts.Interpolation.interpolate(&reader, state.samplePos)
+				p.mixMono(state, tickBuffer, i, sampleValue, state.volume, state.panning)
 			}
 			state.samplePos += step
 		}
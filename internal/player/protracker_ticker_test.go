@@ -2,8 +2,43 @@ package player
 
 import (
 	"testing"
+
+	"github.com/jesseward/impulse/pkg/protracker"
 )
 
 func TestProtrackerTicker_ProcessTick(t *testing.T) {
 	// TODO: Add tests
 }
+
+func TestProtrackerTicker_RenderChannelTick_loop(t *testing.T) {
+	// The loop ends before the sample does, so the points after it are never
+	// played.
+	data := make([]int16, 100)
+	for i := range data {
+		data[i] = 1024
+		if i >= 40 {
+			data[i] = 32512
+		}
+	}
+	sample := protracker.NewSample("loop", data, 64, 0, 20, 20)
+
+	opts := DefaultPlayerOptions()
+	opts.NumChannels, opts.VolumeRamp = 1, 0
+	for _, interpolation := range []Interpolation{InterpolationNearest, InterpolationLinear} {
+		opts.Interpolation = interpolation
+		p := NewPlayer(loopingModule(t), func(string, ...interface{}) {}, nil, opts)
+		state := defaultChannelState()
+		state.sample, state.sampleIndex, state.period = &sample, 0, 428
+
+		tickBuffer := make([]float64, 1000)
+		(&ProtrackerTicker{}).RenderChannelTick(p, &state, tickBuffer, len(tickBuffer))
+		if state.samplePos < 20 || state.samplePos >= 40 {
+			t.Errorf("%v: sample position = %v, want within the loop from 20 to 40", interpolation, state.samplePos)
+		}
+		for i, v := range tickBuffer {
+			if v != 1024 {
+				t.Fatalf("%v: frame %d = %v, want 1024 from the loop", interpolation, i, v)
+			}
+		}
+	}
+}
@@ -47,7 +47,8 @@ func (t *S3MTicker) handleTickZero(p *Player, cell *module.Cell, state *channelS
 		} else {
 			state.volume = float64(state.sample.Volume()) / 64.0
 		}
-		if cell.Note > 0 {
+		// Empty cells hold note 255, and note 0 is C-0.
+		if cell.Note < 254 {
 			state.samplePos = 0
 		}
 	} else if cell.Volume <= 64 {
@@ -256,33 +257,39 @@ func (t *S3MTicker) GetPeriod(period uint16, offset int) uint16 {
 	return s3mPeriodTable[octave*12+note]
 }
 
-func (t *S3MTicker) RenderChannelTick(p *Player, state *channelState, tickBuffer []int, samplesPerTick int) {
+func (t *S3MTicker) RenderChannelTick(p *Player, state *channelState, tickBuffer []float64, samplesPerTick int) {
 	if state.sample == nil || state.period == 0 || state.sample.Length() == 0 || state.sampleIndex == -1 {
 		return
 	}
 
 	freq := 14317456.0 / float64(state.period)
 	step := freq / float64(p.opts.SampleRate)
+	state.markOutput(state.period, freq, state.volume, state.panning)
 
-	is16Bit := state.sample.Flags()&4 != 0
 	isStereo := state.sample.Flags()&2 != 0
-	bytesPerSample := 1
-	if is16Bit {
-		bytesPerSample = 2
-	}
 	numChannels := 1
 	if isStereo {
 		numChannels = 2
 	}
 
-	for i := 0; i < samplesPerTick; i++ {
-		sampleLength := float64(state.sample.Length())
-		loopBegin := float64(state.sample.LoopStart())
-		loopEnd := float64(state.sample.LoopEnd())
-		loopLength := loopEnd - loopBegin
-		hasLoop := state.sample.Flags()&1 != 0
+	sampleLength := float64(state.sample.Length())
+	loopBegin := float64(state.sample.LoopStart())
+	loopEnd := float64(state.sample.LoopEnd())
+	loopLength := loopEnd - loopBegin
+	hasLoop := state.sample.Flags()&1 != 0 && loopLength > 1
+
+	// Stereo samples hold the left and right channels interleaved.
+	sampleData := state.sample.Data()
+	reader := newSampleReader(sampleData, min(int(sampleLength), len(sampleData)/numChannels))
+	if hasLoop {
+		reader = reader.withLoop(int(loopBegin), int(loopEnd), false)
+	}
+	reader.stride = numChannels
+	rightReader := reader
+	rightReader.offset = 1
 
-		if hasLoop && loopLength > 1 {
+	for i := 0; i < samplesPerTick; i++ {
+		if hasLoop {
 			if state.samplePos >= loopEnd {
 				state.samplePos -= loopLength
 			}
@@ -293,50 +300,17 @@ func (t *S3MTicker) RenderChannelTick(p *Player, state *channelState, tickBuffer
 			}
 		}
 
-		byteOffset := int(state.samplePos) * bytesPerSample * numChannels
-		sampleData := state.sample.Data()
-		if byteOffset >= len(sampleData) {
+		if int(state.samplePos) >= reader.length {
 			continue
 		}
 
-		offset := i * p.opts.NumChannels
 		if isStereo {
-			var left, right float64
-			if is16Bit {
-				if byteOffset/2+1 < len(sampleData) {
-					lSample := sampleData[byteOffset/2]
-					rSample := sampleData[byteOffset/2+1]
-					left = float64(lSample) * state.volume
-					right = float64(rSample) * state.volume
-				}
-			} else {
-				lSample := sampleData[byteOffset]
-				rSample := sampleData[byteOffset+1]
-				left = float64(lSample) * state.volume
-				right = float64(rSample) * state.volume
-			}
-			tickBuffer[offset] += int(left)
-			if p.opts.NumChannels > 1 {
-				tickBuffer[offset+1] += int(right)
-			}
+			left := p.opts.Interpolation.interpolate(&reader, state.samplePos)
+			right := p.opts.Interpolation.interpolate(&rightReader, state.samplePos)
+			p.mixStereo(state, tickBuffer, i, left, right, state.volume)
 		} else { // Mono
-			var sampleValue float64
-			if is16Bit {
-				if byteOffset/2 < len(sampleData) {
-					sample := sampleData[byteOffset/2]
-					sampleValue = float64(sample) * state.volume
-				}
-			} else {
-				if byteOffset < len(sampleData) {
-					sample := sampleData[byteOffset]
-					sampleValue = float64(sample) * state.volume
-				}
-			}
-			left, right := p.pan(p.opts.NumChannels, state.panning, sampleValue)
-			tickBuffer[offset] += left
-			if p.opts.NumChannels > 1 {
-				tickBuffer[offset+1] += right
-			}
+			sampleValue := p.opts.Interpolation.interpolate(&reader, state.samplePos)
+			p.mixMono(state, tickBuffer, i, sampleValue, state.volume, state.panning)
 		}
 		state.samplePos += step
 	}
@@ -2,8 +2,41 @@ package player
 
 import (
 	"testing"
+
+	"github.com/jesseward/impulse/pkg/module"
+	"github.com/jesseward/impulse/pkg/s3m"
 )
 
 func TestS3MTicker_ProcessTick(t *testing.T) {
 	// TODO: Add tests
 }
+
+func TestS3MTicker_handleTickZero(t *testing.T) {
+	mod := &s3m.S3M{Instruments: []s3m.Instrument{
+		s3m.NewInstrument("sample", make([]int16, 1000), 64, 8363, 0, 0),
+	}}
+	p := &Player{module: mod}
+	tests := []struct {
+		name    string
+		cell    module.Cell
+		wantPos float64
+	}{
+		// An instrument without a note changes the sample and volume but does
+		// not retrigger the note.
+		{"instrument only", module.Cell{Note: 255, Instrument: 1, Volume: 255}, 100},
+		{"note", module.Cell{Note: 0x40, Instrument: 1, Volume: 255}, 0},
+		{"C-0", module.Cell{Note: 0, Instrument: 1, Volume: 255}, 0},
+	}
+	for _, tt := range tests {
+		state := defaultChannelState()
+		state.samplePos = 100
+		ticker := &S3MTicker{}
+		ticker.handleTickZero(p, &tt.cell, &state, &playerState{})
+		if state.sampleIndex != 1 || state.volume != 1 {
+			t.Errorf("%s: sample %d at volume %v, want sample 1 at volume 1", tt.name, state.sampleIndex, state.volume)
+		}
+		if state.samplePos != tt.wantPos {
+			t.Errorf("%s: sample position = %v, want %v", tt.name, state.samplePos, tt.wantPos)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package player
+
+import (
+	"math"
+	"math/cmplx"
+	"sync"
+)
+
+// spectrumSize is the number of frames of the window of a SpectrumAnalyzer,
+// a power of two for the FFT. At 44.1 kHz it spans 46 ms, with bins 21.5 Hz
+// apart.
+const spectrumSize = 2048
+
+// SpectrumAnalyzer keeps the latest frames of the samples written to it, in
+// the format of its player options, to measure their spectrum. It is written
+// by the rendering goroutine, such as through a TeePlayer, and read by
+// another.
+type SpectrumAnalyzer struct {
+	opts PlayerOptions
+
+	mu      sync.Mutex
+	pending []byte                // partial frame of the last write
+	window  [spectrumSize]float64 // of mono frames, in a ring from pos
+	pos     int
+}
+
+// NewSpectrumAnalyzer returns an analyzer of samples in the format of opts.
+func NewSpectrumAnalyzer(opts PlayerOptions) *SpectrumAnalyzer {
+	return &SpectrumAnalyzer{opts: opts}
+}
+
+func (a *SpectrumAnalyzer) Write(data []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	size := a.opts.BitDepth
+	frameSize := size * a.opts.NumChannels
+	buf := data
+	if len(a.pending) > 0 {
+		buf = append(a.pending, data...)
+	}
+
+	var end int
+	for ; end+frameSize <= len(buf); end += frameSize {
+		var x float64
+		for ch := range a.opts.NumChannels {
+			x += decodeSample(buf[end+ch*size:], a.opts)
+		}
+		a.window[a.pos] = x / float64(a.opts.NumChannels)
+		a.pos = (a.pos + 1) % spectrumSize
+	}
+	a.pending = append(a.pending[:0], buf[end:]...)
+	return len(data), nil
+}
+
+// Reset clears the frames of the analyzer, as after silence.
+func (a *SpectrumAnalyzer) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pending = a.pending[:0]
+	a.window = [spectrumSize]float64{}
+}
+
+// Bands sets bands to the levels of the latest frames in len(bands) bands from
+// minFreq to maxFreq that are evenly spaced in log frequency, in dBFS. The
+// level of a band is that of its strongest bin, so that a sine at full scale
+// measures 0 dB, and a band that falls between bins takes the level of the
+// bin of its center frequency. Silence measures -Inf.
+func (a *SpectrumAnalyzer) Bands(bands []float64, minFreq, maxFreq float64) {
+	x := make([]complex128, spectrumSize)
+	a.mu.Lock()
+	for i := range x {
+		// A Hann window, which halves the level of a sine.
+		w := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/spectrumSize)
+		x[i] = complex(a.window[(a.pos+i)%spectrumSize]*w, 0)
+	}
+	a.mu.Unlock()
+	fft(x, false)
+
+	binWidth := float64(a.opts.SampleRate) / spectrumSize
+	ratio := math.Pow(maxFreq/minFreq, 1/float64(len(bands)))
+	for i := range bands {
+		low := minFreq * math.Pow(ratio, float64(i))
+		high := low * ratio
+		first := int(math.Ceil(low / binWidth))
+		last := min(int(math.Floor(high/binWidth)), spectrumSize/2)
+		if first > last {
+			first = min(int(math.Round(math.Sqrt(low*high)/binWidth)), spectrumSize/2)
+			last = first
+		}
+		var magnitude float64
+		for bin := first; bin <= last; bin++ {
+			magnitude = math.Max(magnitude, cmplx.Abs(x[bin]))
+		}
+		bands[i] = 20 * math.Log10(magnitude*4/spectrumSize)
+	}
+}
@@ -0,0 +1,56 @@
+package player
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSpectrumAnalyzer(t *testing.T) {
+	opts := DefaultPlayerOptions()
+	opts.Dither = false
+	a := NewSpectrumAnalyzer(opts)
+	bands := make([]float64, 30)
+	a.Bands(bands, 20, 20000)
+	for i, level := range bands {
+		if !math.IsInf(level, -1) {
+			t.Fatalf("band %d of silence = %v dB, want -Inf", i, level)
+		}
+	}
+
+	// A 1 kHz sine at -6 dBFS in both channels, written in uneven chunks.
+	sine := sine(1000, -6)
+	var buf []float64
+	for i := range spectrumSize * 2 {
+		buf = append(buf, sine(i), sine(i))
+	}
+	data := newPCMEncoder(opts).encode(buf)
+	for len(data) > 0 {
+		n := min(len(data), 1001)
+		if _, err := a.Write(data[:n]); err != nil {
+			t.Fatalf("Write() failed: %v", err)
+		}
+		data = data[n:]
+	}
+
+	a.Bands(bands, 20, 20000)
+	// The bands are a tenth of a decade wide, and 1 kHz is at the edge of
+	// bands 16 and 17.
+	for i, level := range bands {
+		switch {
+		case i == 16 || i == 17:
+			if level < -8 || level > -5.5 {
+				t.Errorf("band %d of a -6 dBFS sine in it = %.1f dB, want about -6", i, level)
+			}
+		case i < 14 || i > 19:
+			if level > -60 {
+				t.Errorf("band %d away from a sine = %.1f dB, want under -60", i, level)
+			}
+		}
+	}
+
+	a.Reset()
+	a.Bands(bands, 20, 20000)
+	if !math.IsInf(bands[17], -1) {
+		t.Errorf("band 17 after Reset() = %v dB, want -Inf", bands[17])
+	}
+}
@@ -0,0 +1,110 @@
+package player
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/jesseward/impulse/pkg/module"
+)
+
+// Stream renders a song on demand as it is read, in the calling goroutine.
+// It is an io.Reader of samples in the format of its player options, and
+// ReadFrames reads floating point samples instead. A stream is read with
+// either Read or ReadFrames, not both.
+type Stream struct {
+	player  *Player
+	end     int
+	started bool
+	done    bool
+	err     error     // that stopped the stream
+	samples []float64 // mastered samples that have not been read
+	data    []byte    // encoded samples that have not been read
+}
+
+// NewStream returns a stream of the song of m from its start. The song plays
+// forever if it loops back, unless the player of the stream limits it.
+func NewStream(m module.Module, opts PlayerOptions) (*Stream, error) {
+	if err := validSampleFormat(opts); err != nil {
+		return nil, err
+	}
+	p := NewPlayer(m, func(string, ...interface{}) {}, nil, opts)
+	if p.ticker == nil {
+		return nil, fmt.Errorf("playback of %s modules is not supported", m.Type())
+	}
+	return &Stream{player: p}, nil
+}
+
+// Player returns the player that renders the stream. Its position, loops,
+// limits and muted channels can be set before the stream is first read.
+func (s *Stream) Player() *Player {
+	return s.player
+}
+
+// fill renders rows until there are samples to read. It reports false at the
+// end of the stream, or on a fault that stopped it.
+func (s *Stream) fill() bool {
+	if !s.started {
+		s.end, s.err = s.player.startRender()
+		s.started = true
+		s.done = s.err != nil
+	}
+	for len(s.samples) == 0 && !s.done {
+		rowBuffer, _, ok, err := s.player.renderRow(s.end)
+		if !ok {
+			s.done, s.err = true, err
+			break
+		}
+		s.player.master.process(rowBuffer)
+		s.samples = rowBuffer
+	}
+	return len(s.samples) > 0
+}
+
+// endErr returns the error of the end of the stream: io.EOF, or the
+// *RenderError of a fault.
+func (s *Stream) endErr() error {
+	if s.err != nil {
+		return s.err
+	}
+	return io.EOF
+}
+
+// Read reads samples in the format of the player options. It returns io.EOF
+// when the song has ended, and a *RenderError if a fault stopped it.
+func (s *Stream) Read(b []byte) (int, error) {
+	if len(s.data) == 0 {
+		if !s.fill() {
+			return 0, s.endErr()
+		}
+		s.data = s.player.encoder.encode(s.samples)
+		s.samples = nil
+	}
+	n := copy(b, s.data)
+	s.data = s.data[n:]
+	return n, nil
+}
+
+// ReadFrames reads interleaved floating point samples, in the range -1 to 1
+// unless the limiter is disabled, into buf. It returns the number of frames
+// read, which is at most len(buf) divided by the number of channels, and the
+// same errors as Read. A buffer too small for a frame returns
+// io.ErrShortBuffer.
+func (s *Stream) ReadFrames(buf []float32) (int, error) {
+	channels := s.player.opts.NumChannels
+	if len(buf) < channels {
+		return 0, io.ErrShortBuffer
+	}
+	var n int
+	for n+channels <= len(buf) && s.fill() {
+		copied := min(len(buf)-n, len(s.samples)) / channels * channels
+		for i, sample := range s.samples[:copied] {
+			buf[n+i] = float32(sample)
+		}
+		s.samples = s.samples[copied:]
+		n += copied
+	}
+	if n == 0 {
+		return 0, s.endErr()
+	}
+	return n / channels, nil
+}
@@ -0,0 +1,107 @@
+package player
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+	"time"
+)
+
+// writeRaw renders the first seconds of space_debris.mod with WriteRaw.
+func writeRaw(t *testing.T, opts PlayerOptions) []byte {
+	t.Helper()
+	p := newTestPlayer(t, "space_debris.mod")
+	p = NewPlayer(p.module, p.log, nil, opts)
+	p.SetMaxDuration(3 * time.Second)
+	buf := &bytes.Buffer{}
+	if err := p.WriteRaw(NewStreamPlayer(nopCloser{buf}, opts), nil); err != nil {
+		t.Fatalf("WriteRaw() failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func newTestStream(t *testing.T, opts PlayerOptions) *Stream {
+	t.Helper()
+	s, err := NewStream(newTestPlayer(t, "space_debris.mod").module, opts)
+	if err != nil {
+		t.Fatalf("NewStream() failed: %v", err)
+	}
+	s.Player().SetMaxDuration(3 * time.Second)
+	return s
+}
+
+func TestStream_Read(t *testing.T) {
+	opts := DefaultPlayerOptions()
+	want := writeRaw(t, opts)
+
+	// Read in small uneven pieces that split samples.
+	s := newTestStream(t, opts)
+	var got []byte
+	buf := make([]byte, 1001)
+	for {
+		n, err := s.Read(buf)
+		got = append(got, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read() failed: %v", err)
+		}
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Read() returned %d bytes that differ from the %d of WriteRaw()", len(got), len(want))
+	}
+}
+
+func TestStream_ReadFrames(t *testing.T) {
+	opts := DefaultPlayerOptions()
+	opts.BitDepth, opts.Float = 4, true
+	raw := writeRaw(t, opts)
+
+	s := newTestStream(t, opts)
+	var got []float32
+	buf := make([]float32, 999)
+	for {
+		n, err := s.ReadFrames(buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadFrames() failed: %v", err)
+		}
+		if n == 0 || n > len(buf)/opts.NumChannels {
+			t.Fatalf("ReadFrames() read %d frames into a buffer of %d samples", n, len(buf))
+		}
+		got = append(got, buf[:n*opts.NumChannels]...)
+	}
+	if len(got)*4 != len(raw) {
+		t.Fatalf("ReadFrames() read %d samples, want %d", len(got), len(raw)/4)
+	}
+	for i, v := range got {
+		if want := math.Float32frombits(binary.LittleEndian.Uint32(raw[i*4:])); v != want {
+			t.Fatalf("sample %d = %v, want %v", i, v, want)
+		}
+	}
+}
+
+func TestStream_ReadFrames_shortBuffer(t *testing.T) {
+	s := newTestStream(t, DefaultPlayerOptions())
+	for _, size := range []int{0, 1} {
+		if n, err := s.ReadFrames(make([]float32, size)); n != 0 || err != io.ErrShortBuffer {
+			t.Errorf("ReadFrames() of %d samples = %d, %v, want 0, %v", size, n, err, io.ErrShortBuffer)
+		}
+	}
+	if n, err := s.ReadFrames(make([]float32, 2)); n != 1 || err != nil {
+		t.Errorf("ReadFrames() of a frame = %d, %v, want 1, nil", n, err)
+	}
+}
+
+func TestNewStream_sampleFormat(t *testing.T) {
+	opts := DefaultPlayerOptions()
+	opts.BitDepth = 5
+	if _, err := NewStream(newTestPlayer(t, "space_debris.mod").module, opts); err == nil {
+		t.Error("NewStream() of 40-bit samples succeeded, want an error")
+	}
+}
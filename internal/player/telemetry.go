@@ -0,0 +1,143 @@
+package player
+
+import (
+	"math"
+	"time"
+
+	"github.com/jesseward/impulse/pkg/module"
+)
+
+// Telemetry describes a tick of the song as it is mixed. It extends the
+// position of PlayerStateUpdate with the state of every channel.
+type Telemetry struct {
+	PlayerStateUpdate
+	Tick     int
+	Time     time.Duration // of the start of the tick, from the start of the song
+	Channels []ChannelTelemetry
+}
+
+// ChannelTelemetry describes what a channel played during a tick.
+type ChannelTelemetry struct {
+	Active      bool   // a voice is playing
+	Note        string // last note of the channel, such as "C-4"
+	Instrument  int    // instrument or sample number of the voice, from 1
+	Volume      float64
+	Panning     float64 // from 0, left, to 1, right
+	Period      int
+	Frequency   float64 // at which the sample is played, in Hz
+	Effect      byte    // of the row
+	EffectParam byte
+	Peak        float64 // absolute level of the mixed channel, from 0 to 1 at full scale
+	Muted       bool
+	// Scope is the mixed output of the channel, mono, in telemetryScopeLength
+	// even parts of the tick. Each holds the sample of the part that is
+	// furthest from zero, from -1 to 1 at full scale.
+	Scope []float64
+}
+
+// telemetryScopeLength is the number of points of ChannelTelemetry.Scope.
+const telemetryScopeLength = 32
+
+// SetTelemetry sets a channel that receives the telemetry of each tick as it
+// is mixed, or stops sending it if ch is nil. Telemetry is dropped while the
+// channel is full, so that a slow receiver never stalls playback; a buffered
+// channel evens out the delivery. The ticks are mixed ahead of the audio that
+// the sink plays, by up to the size of its buffer.
+func (p *Player) SetTelemetry(ch chan<- Telemetry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.telemetry = ch
+}
+
+// sendTelemetry sends the telemetry of a tick of the row at position that
+// started at frame, unless the channel of SetTelemetry is full.
+func (p *Player) sendTelemetry(state *playerState, position PlayerStateUpdate, tick, frame int) {
+	if p.telemetry == nil {
+		return
+	}
+	position.Speed, position.BPM = state.speed, state.bpm
+	t := Telemetry{
+		PlayerStateUpdate: position,
+		Tick:              tick,
+		Time:              p.framesToDuration(frame),
+		Channels:          make([]ChannelTelemetry, len(state.channels)),
+	}
+	for ch := range state.channels {
+		channel := &state.channels[ch]
+		cell := p.module.PatternCell(position.Pattern, position.Row, ch)
+		t.Channels[ch] = ChannelTelemetry{
+			Effect:      cell.Effect,
+			EffectParam: cell.EffectParam,
+			Muted:       p.isMuted(ch, channel),
+		}
+		if channel.sample != nil {
+			t.Channels[ch].Active = true
+			t.Channels[ch].Note = channel.note
+			t.Channels[ch].Instrument = channel.sampleIndex
+			t.Channels[ch].Volume = channel.mixVolume
+			t.Channels[ch].Panning = channel.mixPanning
+			t.Channels[ch].Period = int(channel.mixPeriod)
+			t.Channels[ch].Frequency = channel.frequency
+			t.Channels[ch].Peak = channel.peak / 32768
+		}
+		t.Channels[ch].Scope = make([]float64, telemetryScopeLength)
+		addScope(t.Channels[ch].Scope, channel)
+	}
+	// Voices moved to the background by a New Note Action still sound on
+	// their channel.
+	for i := range state.virtualChannels {
+		channel := &state.virtualChannels[i]
+		if channel.channel >= 0 && channel.channel < len(t.Channels) {
+			c := &t.Channels[channel.channel]
+			c.Peak = math.Max(c.Peak, channel.peak/32768)
+			addScope(c.Scope, channel)
+		}
+	}
+	select {
+	case p.telemetry <- t:
+	default:
+	}
+}
+
+// addScope adds the scope of the output of a channel to scope.
+func addScope(scope []float64, state *channelState) {
+	for i, v := range state.scope {
+		scope[i] = math.Max(-1, math.Min(scope[i]+v/32768, 1))
+	}
+}
+
+// markLevel records the peak and scope of the frame of a channel that was
+// just mixed to tickBuffer, for telemetry.
+func (p *Player) markLevel(state *channelState, tickBuffer []float64, frame int) {
+	state.peak = math.Max(state.peak, math.Max(math.Abs(state.lastOut[0]), math.Abs(state.lastOut[1])))
+	out := state.lastOut[0]
+	if p.opts.NumChannels > 1 {
+		out = (out + state.lastOut[1]) / 2
+	}
+	i := frame * telemetryScopeLength * p.opts.NumChannels / len(tickBuffer)
+	if i < telemetryScopeLength && math.Abs(out) > math.Abs(state.scope[i]) {
+		state.scope[i] = out
+	}
+}
+
+// clearOutput clears the peak and scope of a channel before a tick.
+func (state *channelState) clearOutput() {
+	state.peak = 0
+	state.scope = [telemetryScopeLength]float64{}
+}
+
+// markNote records the note of a cell that plays one, for telemetry.
+func (state *channelState) markNote(cell *module.Cell) {
+	if cell.HumanNote != "" && cell.HumanNote != module.EmptyNote {
+		state.note = cell.HumanNote
+	}
+}
+
+// markOutput is called by the tickers as they mix a tick of a channel to record
+// the period, frequency, volume and panning of the voice, for telemetry.
+func (state *channelState) markOutput(period uint16, frequency, volume, panning float64) {
+	state.mixPeriod = period
+	state.frequency = frequency
+	state.mixVolume = volume
+	state.mixPanning = panning
+}
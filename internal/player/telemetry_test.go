@@ -0,0 +1,101 @@
+package player
+
+import (
+	"io"
+	"math"
+	"testing"
+	"time"
+)
+
+// readTelemetry plays the first seconds of space_debris.mod and returns the
+// telemetry that fits in a channel of size n.
+func readTelemetry(t *testing.T, n int, setup func(p *Player)) []Telemetry {
+	t.Helper()
+	s := newTestStream(t, DefaultPlayerOptions())
+	s.Player().SetMaxDuration(2 * time.Second)
+	ch := make(chan Telemetry, n)
+	s.Player().SetTelemetry(ch)
+	if setup != nil {
+		setup(s.Player())
+	}
+	if _, err := io.Copy(io.Discard, s); err != nil {
+		t.Fatalf("reading the stream failed: %v", err)
+	}
+	close(ch)
+	var ticks []Telemetry
+	for tick := range ch {
+		ticks = append(ticks, tick)
+	}
+	return ticks
+}
+
+func TestPlayer_SetTelemetry(t *testing.T) {
+	ticks := readTelemetry(t, 1000, nil)
+	if len(ticks) == 0 || len(ticks) == 1000 {
+		t.Fatalf("received %d ticks, want some in a channel of 1000", len(ticks))
+	}
+	var active, peaks int
+	for i, tick := range ticks {
+		if len(tick.Channels) != 4 {
+			t.Fatalf("tick %d has %d channels, want 4", i, len(tick.Channels))
+		}
+		if i == 0 {
+			continue
+		}
+		prev := ticks[i-1]
+		if tick.Time <= prev.Time {
+			t.Errorf("tick %d at %v, not after %v", i, tick.Time, prev.Time)
+		}
+		if tick.Row == prev.Row && tick.Tick != prev.Tick+1 || tick.Row != prev.Row && tick.Tick != 0 {
+			t.Errorf("tick %d is tick %d of row %d, after tick %d of row %d", i, tick.Tick, tick.Row, prev.Tick, prev.Row)
+		}
+		for ch, c := range tick.Channels {
+			if !c.Active {
+				continue
+			}
+			active++
+			if c.Note == "" || c.Instrument == 0 || c.Period == 0 || c.Frequency <= 0 {
+				t.Errorf("tick %d channel %d = %+v, want a note, instrument, period and frequency", i, ch, c)
+			}
+			if c.Peak < 0 || c.Peak > 1 {
+				t.Errorf("tick %d channel %d peak = %v, want 0 to 1", i, ch, c.Peak)
+			}
+			if c.Peak > 0 {
+				peaks++
+			}
+			if len(c.Scope) != telemetryScopeLength {
+				t.Fatalf("tick %d channel %d has a scope of %d points, want %d", i, ch, len(c.Scope), telemetryScopeLength)
+			}
+			var scopePeak float64
+			for _, v := range c.Scope {
+				scopePeak = math.Max(scopePeak, math.Abs(v))
+			}
+			if scopePeak > c.Peak+1e-9 || c.Peak > 0 && scopePeak == 0 {
+				t.Errorf("tick %d channel %d scope reaches %v, want up to its peak of %v", i, ch, scopePeak, c.Peak)
+			}
+		}
+	}
+	if active == 0 || peaks == 0 {
+		t.Errorf("%d active channels with %d peaks, want some of both", active, peaks)
+	}
+}
+
+func TestPlayer_SetTelemetry_muted(t *testing.T) {
+	ticks := readTelemetry(t, 1000, func(p *Player) { p.SetChannelMute(1, true) })
+	for i, tick := range ticks {
+		if c := tick.Channels[1]; !c.Muted || c.Peak != 0 {
+			t.Fatalf("tick %d muted channel = %+v, want muted without a peak", i, c)
+		}
+	}
+}
+
+func TestPlayer_SetTelemetry_slowReceiver(t *testing.T) {
+	// Playback goes on while the channel is full, and the ticks that do not
+	// fit are dropped.
+	if ticks := readTelemetry(t, 3, nil); len(ticks) != 3 {
+		t.Errorf("received %d ticks, want the 3 that fit", len(ticks))
+	}
+	if ticks := readTelemetry(t, 0, nil); len(ticks) != 0 {
+		t.Errorf("received %d ticks on an unbuffered channel, want none", len(ticks))
+	}
+}
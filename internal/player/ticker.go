@@ -6,5 +6,17 @@ import "github.com/jesseward/impulse/pkg/module"
 type Ticker interface {
 	// ProcessTick handles the logic for a single tick, including effects and period calculations.
 	ProcessTick(p *Player, playerState *playerState, channelState *channelState, cell *module.Cell, speed, bpm, nextRow, nextOrder, currentOrder *int, tick int)
-	RenderChannelTick(p *Player, state *channelState, tickBuffer []int, samplesPerTick int)
+	RenderChannelTick(p *Player, state *channelState, tickBuffer []float64, samplesPerTick int)
+}
+
+// stateInitializer is implemented by tickers that seed the player state from
+// module header values, such as initial channel volumes, before playback starts.
+type stateInitializer interface {
+	initState(p *Player, playerState *playerState)
+}
+
+// virtualChannelTicker is implemented by tickers for formats where a note can
+// keep sounding in the background after a new note takes over its channel.
+type virtualChannelTicker interface {
+	processVirtualTick(p *Player, playerState *playerState, channelState *channelState, tick int)
 }
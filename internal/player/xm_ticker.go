@@ -56,8 +56,12 @@ func (t *XMTicker) handleTickZero(p *Player, mod *xm.Module, playerState *player
 			}
 		}
 		state.sampleIndex = int(cell.Instrument)
-		state.volume = float64(state.sample.Volume()) / 64.0
-		state.panning = float64(state.sample.Panning()) / 255.0
+		// An instrument without a note keeps the sample of the last note,
+		// if the channel has played one.
+		if state.sample != nil {
+			state.volume = float64(state.sample.Volume()) / 64.0
+			state.panning = float64(state.sample.Panning()) / 255.0
+		}
 	}
 
 	if cell.Note > 0 && cell.Note < 97 { // Note is not KeyOff
@@ -112,7 +116,7 @@ func (t *XMTicker) handleEffect(p *Player, mod *xm.Module, playerState *playerSt
 
 	switch effect {
 	case 0x00: // Arpeggio
-		if tick > 0 {
+		if tick > 0 && state.sample != nil {
 			var noteOffset byte
 			switch tick % 3 {
 			case 1:
@@ -233,44 +237,7 @@ func (t *XMTicker) handleEffect(p *Player, mod *xm.Module, playerState *playerSt
 	case 0x1A: // R: Multi retrig note
 		if tick > 0 && param&0x0F > 0 && tick%int(param&0x0F) == 0 {
 			state.samplePos = 0
-			// also apply volume modification
-			x := param >> 4
-			switch x {
-			case 1:
-				state.volume -= 1.0 / 64.0
-			case 2:
-				state.volume -= 2.0 / 64.0
-			case 3:
-				state.volume -= 4.0 / 64.0
-			case 4:
-				state.volume -= 8.0 / 64.0
-			case 5:
-				state.volume -= 16.0 / 64.0
-			case 6:
-				state.volume = state.volume * 2 / 3
-			case 7:
-				state.volume = state.volume / 2
-			case 9:
-				state.volume += 1.0 / 64.0
-			case 0xA:
-				state.volume += 2.0 / 64.0
-			case 0xB:
-				state.volume += 4.0 / 64.0
-			case 0xC:
-				state.volume += 8.0 / 64.0
-			case 0xD:
-				state.volume += 16.0 / 64.0
-			case 0xE:
-				state.volume = state.volume * 3 / 2
-			case 0xF:
-				state.volume = state.volume * 2
-			}
-			if state.volume < 0 {
-				state.volume = 0
-			}
-			if state.volume > 1.0 {
-				state.volume = 1.0
-			}
+			applyRetrigVolume(state, param>>4)
 		}
 	case 0x1C: // T: Tremor
 		if param > 0 {
@@ -524,7 +491,7 @@ func (t *XMTicker) getPeriod(note byte, finetune int8, isAmiga bool) uint16 {
 	}
 }
 
-func (t *XMTicker) RenderChannelTick(p *Player, state *channelState, tickBuffer []int, samplesPerTick int) {
+func (t *XMTicker) RenderChannelTick(p *Player, state *channelState, tickBuffer []float64, samplesPerTick int) {
 	if state.sample == nil || state.period == 0 || state.sample.Length() == 0 || state.sampleIndex == -1 {
 		return
 	}
@@ -542,12 +509,17 @@ func (t *XMTicker) RenderChannelTick(p *Player, state *channelState, tickBuffer
 	}
 
 	step := freq / float64(p.opts.SampleRate)
+	state.markOutput(state.period, freq, state.volume, state.panning)
 	sampleData := state.sample.Data()
 	sampleLength := float64(state.sample.Length())
 	loopStart := float64(state.sample.LoopStart())
 	loopEnd := float64(state.sample.LoopEnd())
 	hasLoop := state.sample.LoopLength() > 2
 	isPingPong := state.sample.IsPingPong()
+	reader := newSampleReader(sampleData, len(sampleData))
+	if hasLoop {
+		reader = reader.withLoop(int(loopStart), int(loopEnd), isPingPong)
+	}
 
 	for i := 0; i < samplesPerTick; i++ {
 		if state.samplePos >= sampleLength {
@@ -575,33 +547,8 @@ func (t *XMTicker) RenderChannelTick(p *Player, state *channelState, tickBuffer
 		}
 
 		if pos < len(sampleData) {
-			// Linear interpolation
-			posFloor := int(math.Floor(state.samplePos))
-			posCeil := posFloor + 1
-			t := state.samplePos - float64(posFloor)
-
-			sample1 := float64(sampleData[posFloor])
-			var sample2 float64
-			if posCeil < len(sampleData) {
-				sample2 = float64(sampleData[posCeil])
-			} else if hasLoop {
-				if isPingPong {
-					sample2 = float64(sampleData[posFloor-1])
-				} else {
-					sample2 = float64(sampleData[int(loopStart)])
-				}
-			} else {
-				sample2 = sample1
-			}
-
-			sampleValue := (sample1*(1.0-t) + sample2*t) * state.volume
-			left, right := p.pan(p.opts.NumChannels, state.panning, sampleValue)
-
-			offset := i * p.opts.NumChannels
-			tickBuffer[offset] += left
-			if p.opts.NumChannels > 1 {
-				tickBuffer[offset+1] += right
-			}
+			sampleValue := p.opts.Interpolation.interpolate(&reader, state.samplePos)
+			p.mixMono(state, tickBuffer, i, sampleValue, state.volume, state.panning)
 			state.samplePos += step
 		}
 	}
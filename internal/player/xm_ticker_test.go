@@ -2,8 +2,33 @@ package player
 
 import (
 	"testing"
+
+	"github.com/jesseward/impulse/pkg/module"
+	"github.com/jesseward/impulse/pkg/xm"
 )
 
 func TestXMTicker_ProcessTick(t *testing.T) {
 	// TODO: Add tests
 }
+
+func TestXMTicker_handleTickZero(t *testing.T) {
+	// An instrument without samples, or without a note to pick one, leaves
+	// a channel that has not played a note without a sample.
+	mod := &xm.Module{Instruments: []*xm.Instrument{{}}}
+	for _, cell := range []module.Cell{
+		{Instrument: 1},
+		{Instrument: 1, Note: 49},
+	} {
+		state := defaultChannelState()
+		ticker := &XMTicker{}
+		ticker.handleTickZero(nil, mod, &playerState{}, &state, &cell)
+		if state.sample != nil || state.sampleIndex != 1 {
+			t.Errorf("handleTickZero(%+v) set sample %v of instrument %d, want no sample of instrument 1", cell, state.sample, state.sampleIndex)
+		}
+
+		// Arpeggio of a channel without a sample is ignored.
+		cell.EffectParam = 0x37
+		var speed, bpm, nextRow, nextOrder, order int
+		ticker.handleEffect(nil, mod, &playerState{}, &state, &cell, &speed, &bpm, &nextRow, &nextOrder, &order, 1)
+	}
+}
@@ -0,0 +1,217 @@
+package ui
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/jesseward/impulse/internal/player"
+	"github.com/jesseward/impulse/pkg/module"
+)
+
+var (
+	scopeStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("45"))
+	vuLowStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("40"))
+	vuMidStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("226"))
+	vuHighStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
+	vuEmptyStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("238"))
+)
+
+const (
+	minStripWidth = 8   // of a channel strip, with the space that separates it
+	scopeHeight   = 3   // rows of the oscilloscopes
+	vuFloor       = -48 // level in dB of an empty VU bar
+	vuDecay       = 0.8 // of the VU level per tick, as it falls back
+	maxPending    = 1024
+)
+
+// scopePoint is a column of a scrolling oscilloscope, the lowest and highest
+// output of a channel during a tick.
+type scopePoint struct {
+	low, high float64
+}
+
+// channelsModel is a panel of channel strips, which show the note, a VU bar
+// and a scrolling oscilloscope of each channel from the telemetry of the
+// player.
+type channelsModel struct {
+	width      int
+	stripWidth int
+	visible    int // channels that fit in the width
+	muted      []bool
+	notes      []string
+	levels     []float64
+	scopes     [][]scopePoint
+	// pending holds the telemetry that was received ahead of the audio that
+	// is playing.
+	pending []player.Telemetry
+}
+
+func newChannelsModel(m module.Module) channelsModel {
+	return channelsModel{
+		muted:  make([]bool, m.NumChannels()),
+		notes:  make([]string, m.NumChannels()),
+		levels: make([]float64, m.NumChannels()),
+		scopes: make([][]scopePoint, m.NumChannels()),
+	}
+}
+
+func (m channelsModel) height() int {
+	return scopeHeight + 4 // border, note and VU bar
+}
+
+// setWidth fits as many channel strips as possible in width, at least
+// minStripWidth wide each.
+func (m *channelsModel) setWidth(width int) {
+	m.width = width
+	availableWidth := width - 2 - 2 // subtract border and padding
+	m.visible = min(len(m.levels), max(availableWidth/minStripWidth, 1))
+	m.stripWidth = availableWidth / max(m.visible, 1)
+}
+
+// receive queues telemetry of the player until its audio is played.
+func (m *channelsModel) receive(t player.Telemetry) {
+	if len(m.pending) == maxPending {
+		m.pending = m.pending[1:]
+	}
+	m.pending = append(m.pending, t)
+}
+
+// update shows the telemetry of the ticks that have been played by the time
+// elapsed.
+func (m *channelsModel) update(elapsed time.Duration) {
+	n := 0
+	for n < len(m.pending) && m.pending[n].Time <= elapsed {
+		m.apply(m.pending[n])
+		n++
+	}
+	m.pending = m.pending[n:]
+}
+
+func (m *channelsModel) apply(t player.Telemetry) {
+	for ch, c := range t.Channels {
+		if ch >= len(m.levels) {
+			break
+		}
+		m.notes[ch] = ""
+		if c.Active {
+			m.notes[ch] = c.Note
+		}
+		m.levels[ch] = math.Max(c.Peak, m.levels[ch]*vuDecay)
+
+		point := scopePoint{}
+		for _, v := range c.Scope {
+			point.low = math.Min(point.low, v)
+			point.high = math.Max(point.high, v)
+		}
+		scope := append(m.scopes[ch], point)
+		// Keep the columns of the widest strip that fits the terminal.
+		if keep := max(m.stripWidth-1, 1); len(scope) > keep {
+			scope = scope[len(scope)-keep:]
+		}
+		m.scopes[ch] = scope
+	}
+}
+
+// reset clears the strips when playback stops, or moves to another position.
+func (m *channelsModel) reset() {
+	m.pending = nil
+	for ch := range m.levels {
+		m.notes[ch] = ""
+		m.levels[ch] = 0
+		m.scopes[ch] = nil
+	}
+}
+
+func (m channelsModel) View() string {
+	width := m.stripWidth - 1
+	var lines [scopeHeight + 2]strings.Builder
+	for ch := range m.visible {
+		label := fmt.Sprintf("%-*s", width, fmt.Sprintf("%d %s", ch+1, m.notes[ch]))
+		style := noteStyle
+		if m.muted[ch] {
+			style = mutedStyle
+		}
+		lines[0].WriteString(style.Render(label[:width]) + " ")
+
+		scope := m.scopeView(ch, width)
+		for y := range scopeHeight {
+			lines[1+y].WriteString(scope[y] + " ")
+		}
+		lines[scopeHeight+1].WriteString(m.vuView(ch, width) + " ")
+	}
+
+	rows := make([]string, len(lines))
+	for i := range lines {
+		rows[i] = lines[i].String()
+	}
+	style := lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder(), true).
+		Inherit(borderColorStyle).
+		Padding(0, 1).
+		Width(m.width - 2)
+	return style.Render(strings.Join(rows, "\n"))
+}
+
+// scopeView returns the rows of the oscilloscope of a channel, width columns
+// wide, with the latest tick on the right.
+func (m channelsModel) scopeView(ch, width int) []string {
+	grid := make([][]rune, scopeHeight)
+	for y := range grid {
+		grid[y] = []rune(strings.Repeat(" ", width))
+	}
+	scope := m.scopes[ch]
+	offset := width - len(scope)
+	halfHeight := float64(scopeHeight) / 2
+	for i, point := range scope {
+		if offset+i < 0 {
+			continue
+		}
+		// The top row shows the highest output.
+		top := int(math.Floor(halfHeight - point.high*halfHeight))
+		bottom := int(math.Ceil(halfHeight-point.low*halfHeight)) - 1
+		for y := max(top, 0); y <= min(max(bottom, top), scopeHeight-1); y++ {
+			grid[y][offset+i] = '█'
+		}
+	}
+
+	style := scopeStyle
+	if m.muted[ch] {
+		style = mutedStyle
+	}
+	rows := make([]string, scopeHeight)
+	for y := range grid {
+		rows[y] = style.Render(string(grid[y]))
+	}
+	return rows
+}
+
+// vuView returns the VU bar of a channel, width columns wide, on a scale of
+// decibels from vuFloor to full scale.
+func (m channelsModel) vuView(ch, width int) string {
+	var fill int
+	if level := m.levels[ch]; level > 0 {
+		db := 20 * math.Log10(level)
+		fill = int(math.Round(float64(width) * (1 - math.Min(db, 0)/vuFloor)))
+		fill = max(0, min(fill, width))
+	}
+	var b strings.Builder
+	for i := range width {
+		style := vuLowStyle
+		switch {
+		case i >= fill:
+			b.WriteString(vuEmptyStyle.Render("░"))
+			continue
+		case m.muted[ch]:
+			style = mutedStyle
+		case i >= width*9/10:
+			style = vuHighStyle
+		case i >= width*7/10:
+			style = vuMidStyle
+		}
+		b.WriteString(style.Render("█"))
+	}
+	return b.String()
+}
@@ -27,6 +27,6 @@ func (m footerModel) View() string {
 		Width(m.width).
 		Align(lipgloss.Center)
 
-	text := "'tab' toggle pattern or sample view | 'spacebar' Start/Stop Song | 'q' Quit"
+	text := "'tab' pattern/sample/spectrum view | 'spacebar' Start/Stop | '←/→' Prev/Next Order | '1-0' Mute | 'v' Meters | 'q' Quit"
 	return style.Render(text)
 }
@@ -1,6 +1,8 @@
 package ui
 
 import (
+	"fmt"
+	"strings"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -14,47 +16,69 @@ type viewState int
 const (
 	showTracker viewState = iota
 	showSamples
+	showSpectrum
 	showWaveform
 	showQuitConfirmation
 )
 
 type playerStateUpdateMsg player.PlayerStateUpdate
+type telemetryMsg player.Telemetry
 type playerTickMsg struct{}
+type playbackEndedMsg struct{ err error }
 type clearFlashMessageMsg struct{}
 
 type model struct {
 	module      module.Module
 	player      *player.Player
-	audioPlayer *player.OtoPlayer
+	audioPlayer player.AudioPlayer
 	stopChan    chan struct{}
+	isStarted   bool
 	isPlaying   bool
-	duration    int
 	lastUpdate  player.PlayerStateUpdate
+	// telemetry is set as the telemetry channel of the player while the
+	// channel strips are shown.
+	telemetry    chan player.Telemetry
+	showChannels bool
+
+	// elapsed is the playing time up to resumedAt, when playback was last
+	// started or resumed.
+	elapsed   time.Duration
+	resumedAt time.Time
 
 	width, height int
 	activeView    viewState
 	previousView  viewState
 	flashMessage  string
+	// spectrumFrame identifies the frames of the spectrum view since it was
+	// last shown, so that the frames scheduled before are dropped.
+	spectrumFrame int
 
 	header   headerModel
 	tracker  trackerModel
 	sampler  samplerModel
+	channels channelsModel
+	spectrum spectrumModel
 	waveform waveformModel
 	footer   footerModel
 }
 
-func initialModel(m module.Module, p *player.Player, ap *player.OtoPlayer) model {
-	return model{
+func initialModel(m module.Module, p *player.Player, ap player.AudioPlayer, analyzer *player.SpectrumAnalyzer, telemetry chan player.Telemetry) model {
+	mod := model{
 		module:      m,
 		player:      p,
 		audioPlayer: ap,
+		telemetry:   telemetry,
 		isPlaying:   false,
 		activeView:  showTracker,
 		header:      newHeaderModel(m),
 		tracker:     newTrackerModel(m),
 		sampler:     newSamplerModel(m),
+		channels:    newChannelsModel(m),
+		spectrum:    newSpectrumModel(analyzer, ap.GetSampleRate()),
 		footer:      newFooterModel(),
 	}
+	mod.updateMuted()
+	return mod
 }
 
 func (m model) Init() tea.Cmd {
@@ -75,11 +99,16 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.header.width = width
 		m.footer.width = width - 2
 		mainViewHeight := height - m.header.height() - m.footer.height()
+		m.channels.setWidth(width)
 		m.tracker.width = width
 		m.tracker.height = mainViewHeight
+		if m.showChannels {
+			m.tracker.height -= m.channels.height()
+		}
 		m.sampler.width = width
 		m.sampler.height = mainViewHeight
 		m.sampler.table.SetHeight(mainViewHeight - 4) // account for border and padding
+		m.spectrum.setSize(width, mainViewHeight)
 
 		if m.waveform.sample != nil {
 			m.waveform = newWaveformModel(m.waveform.sample, width, height)
@@ -92,6 +121,10 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, tea.Quit
 			case "n", "N", "esc":
 				m.activeView = m.previousView
+				if m.activeView == showSpectrum {
+					m.spectrumFrame++
+					return m, spectrumTick(m.spectrumFrame)
+				}
 			}
 			return m, nil
 		}
@@ -110,27 +143,104 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.activeView = showQuitConfirmation
 			return m, nil
 		case " ":
-			m.isPlaying = !m.isPlaying
-			if m.isPlaying {
+			switch {
+			case !m.isStarted:
 				m.flashMessage = "Playback started."
+				m.isStarted = true
+				m.player.Resume()
 				m.stopChan = make(chan struct{})
-				go m.player.WriteRaw(m.audioPlayer, m.stopChan)
-			} else {
+				cmds = append(cmds, play(m.player, m.audioPlayer, m.stopChan))
+			case m.isPlaying:
 				m.flashMessage = "Playback paused."
-				if m.stopChan != nil {
-					close(m.stopChan)
+				m.player.Pause()
+				m.elapsed = m.playTime()
+			default:
+				m.flashMessage = "Playback resumed."
+				m.player.Resume()
+			}
+			m.isPlaying = !m.isPlaying
+			m.resumedAt = time.Now()
+			cmds = append(cmds, tea.Tick(2*time.Second, func(t time.Time) tea.Msg {
+				return clearFlashMessageMsg{}
+			}))
+		case "left", "right":
+			order := m.player.Position().Order - 1
+			if key == "right" {
+				order += 2
+			}
+			if err := m.player.Seek(order, 0); err != nil {
+				m.flashMessage = "No more orders."
+			} else {
+				m.channels.reset()
+				m.lastUpdate = m.player.Position()
+				m.elapsed = m.player.Elapsed()
+				m.resumedAt = time.Now()
+				m.tracker.update(m.lastUpdate)
+				m.header.update(m.lastUpdate, m.seconds())
+				m.flashMessage = fmt.Sprintf("Jumped to order %d.", m.lastUpdate.Order)
+			}
+			cmds = append(cmds, tea.Tick(2*time.Second, func(t time.Time) tea.Msg {
+				return clearFlashMessageMsg{}
+			}))
+		case "1", "2", "3", "4", "5", "6", "7", "8", "9", "0",
+			"!", "@", "#", "$", "%", "^", "&", "*", "(", ")":
+			ch, solo := channelKey(key)
+			if ch >= m.module.NumChannels() {
+				m.flashMessage = fmt.Sprintf("No channel %d.", ch+1)
+			} else if solo {
+				m.player.Solo(ch)
+				m.flashMessage = "All channels unmuted."
+				for other := range m.module.NumChannels() {
+					if other != ch && m.player.ChannelMuted(other) {
+						m.flashMessage = fmt.Sprintf("Channel %d soloed.", ch+1)
+						break
+					}
+				}
+			} else {
+				m.player.SetChannelMute(ch, !m.player.ChannelMuted(ch))
+				m.flashMessage = fmt.Sprintf("Channel %d unmuted.", ch+1)
+				if m.player.ChannelMuted(ch) {
+					m.flashMessage = fmt.Sprintf("Channel %d muted.", ch+1)
 				}
 			}
+			m.updateMuted()
 			cmds = append(cmds, tea.Tick(2*time.Second, func(t time.Time) tea.Msg {
 				return clearFlashMessageMsg{}
 			}))
+		case "v":
+			m.showChannels = !m.showChannels
+			m.channels.reset()
+			if m.showChannels {
+				m.player.SetTelemetry(m.telemetry)
+				m.tracker.height -= m.channels.height()
+			} else {
+				m.player.SetTelemetry(nil)
+				m.tracker.height += m.channels.height()
+			}
 		case "tab":
-			if m.activeView == showTracker {
+			switch m.activeView {
+			case showTracker:
 				m.activeView = showSamples
 				m.sampler.table.Focus()
-			} else {
-				m.activeView = showTracker
+			case showSamples:
+				m.activeView = showSpectrum
 				m.sampler.table.Blur()
+				m.spectrumFrame++
+				cmds = append(cmds, spectrumTick(m.spectrumFrame))
+			default:
+				m.activeView = showTracker
+			}
+		case "[", "]":
+			if m.activeView == showSpectrum {
+				delta := smoothingStep
+				if key == "[" {
+					delta = -delta
+				}
+				m.spectrum.adjustSmoothing(delta)
+				m.flashMessage = fmt.Sprintf("Smoothing %.0f%%.", m.spectrum.smoothing*100)
+				cmds = append(cmds, tea.Tick(2*time.Second, func(t time.Time) tea.Msg {
+					return clearFlashMessageMsg{}
+				}))
 			}
 		case "enter":
 			if m.activeView == showSamples {
@@ -154,16 +264,43 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case playerStateUpdateMsg:
 		m.lastUpdate = player.PlayerStateUpdate(msg)
 		m.tracker.update(m.lastUpdate)
-		m.header.update(m.lastUpdate, m.duration)
+		m.header.update(m.lastUpdate, m.seconds())
 		return m, nil
 
+	case telemetryMsg:
+		if m.showChannels {
+			m.channels.receive(player.Telemetry(msg))
+			m.channels.update(m.playTime())
+		}
+		return m, nil
+
+	case spectrumFrameMsg:
+		if m.activeView != showSpectrum || msg.id != m.spectrumFrame {
+			return m, nil
+		}
+		m.spectrum.update()
+		return m, spectrumTick(msg.id)
+
 	case playerTickMsg:
 		if m.isPlaying {
-			m.duration++
-			m.header.update(m.lastUpdate, m.duration)
+			m.header.update(m.lastUpdate, m.seconds())
 		}
 		return m, nil
 
+	case playbackEndedMsg:
+		m.isStarted = false
+		m.isPlaying = false
+		m.elapsed = 0
+		m.channels.reset()
+		m.spectrum.analyzer.Reset()
+		m.flashMessage = "Playback finished."
+		if msg.err != nil {
+			m.flashMessage = fmt.Sprintf("Playback failed: %v", msg.err)
+		}
+		return m, tea.Tick(2*time.Second, func(t time.Time) tea.Msg {
+			return clearFlashMessageMsg{}
+		})
+
 	case clearFlashMessageMsg:
 		m.flashMessage = ""
 		return m, nil
@@ -177,6 +314,54 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, tea.Batch(cmds...)
 }
 
+// channelKey returns the channel toggled by a number key, where '1' to '9'
+// and '0' select channels 1 to 10, and whether the key was shifted to solo it.
+func channelKey(key string) (ch int, solo bool) {
+	const keys, shifted = "1234567890", "!@#$%^&*()"
+	if ch = strings.Index(shifted, key); ch >= 0 {
+		return ch, true
+	}
+	return strings.Index(keys, key), false
+}
+
+// updateMuted shows the channels muted in the player in the tracker view and
+// the channel strips.
+func (m *model) updateMuted() {
+	for ch := range m.tracker.muted {
+		m.tracker.muted[ch] = m.player.ChannelMuted(ch)
+		m.channels.muted[ch] = m.tracker.muted[ch]
+	}
+}
+
+// play runs the player until the song ends or stopChan is closed.
+func play(p *player.Player, ap player.AudioPlayer, stopChan chan struct{}) tea.Cmd {
+	return func() tea.Msg {
+		return playbackEndedMsg{err: p.WriteRaw(ap, stopChan)}
+	}
+}
+
+// playTime returns the playing time, which does not advance while paused.
+func (m model) playTime() time.Duration {
+	if !m.isPlaying {
+		return m.elapsed
+	}
+	return m.elapsed + time.Since(m.resumedAt)
+}
+
+// seconds returns the playing time in whole seconds, as shown in the header.
+func (m model) seconds() int {
+	return int(m.playTime().Seconds())
+}
+
+// trackerView returns the tracker view, below the channel strips if they are
+// shown.
+func (m model) trackerView() string {
+	if !m.showChannels {
+		return m.tracker.View()
+	}
+	return lipgloss.JoinVertical(lipgloss.Left, m.channels.View(), m.tracker.View())
+}
+
 func (m model) View() string {
 	if m.width == 0 {
 		return "loading..."
@@ -185,18 +370,22 @@ func (m model) View() string {
 	var mainView string
 	switch m.activeView {
 	case showTracker:
-		mainView = m.tracker.View()
+		mainView = m.trackerView()
 	case showSamples:
 		mainView = m.sampler.View()
+	case showSpectrum:
+		mainView = m.spectrum.View()
 	case showWaveform:
 		mainView = m.waveform.View()
 	case showQuitConfirmation:
 		// Keep the background view
 		switch m.previousView {
 		case showTracker:
-			mainView = m.tracker.View()
+			mainView = m.trackerView()
 		case showSamples:
 			mainView = m.sampler.View()
+		case showSpectrum:
+			mainView = m.spectrum.View()
 		}
 	}
 
@@ -0,0 +1,161 @@
+package ui
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/jesseward/impulse/internal/player"
+)
+
+var peakStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("15"))
+
+const (
+	spectrumMinFreq  = 40
+	spectrumMaxFreq  = 16000
+	spectrumFloor    = -72 // level in dB of an empty bar
+	spectrumFPS      = 30
+	peakHoldFrames   = spectrumFPS // that a peak holds before it falls
+	peakFall         = 1.5         // dB per frame
+	defaultSmoothing = 0.5
+	maxSmoothing     = 0.9
+	smoothingStep    = 0.1
+)
+
+// barBlocks are the blocks of the top of a bar, in eighths of a row.
+var barBlocks = []rune(" ▁▂▃▄▅▆▇█")
+
+type spectrumFrameMsg struct{ id int }
+
+// spectrumModel shows the spectrum of the master output as bars with peak
+// hold, in bands that are evenly spaced in log frequency.
+type spectrumModel struct {
+	analyzer *player.SpectrumAnalyzer
+	maxFreq  float64
+	width    int
+	height   int
+	bands    []float64 // measured in the last frame
+	levels   []float64 // smoothed levels of the bars
+	peaks    []float64
+	peakAge  []int
+	// smoothing is the part of the previous level of a bar kept in each
+	// frame, from 0 for none to maxSmoothing.
+	smoothing float64
+}
+
+func newSpectrumModel(analyzer *player.SpectrumAnalyzer, sampleRate int) spectrumModel {
+	return spectrumModel{
+		analyzer:  analyzer,
+		maxFreq:   math.Min(spectrumMaxFreq, float64(sampleRate)/2),
+		smoothing: defaultSmoothing,
+	}
+}
+
+// spectrumTick schedules the next frame of the spectrum view.
+func spectrumTick(id int) tea.Cmd {
+	return tea.Tick(time.Second/spectrumFPS, func(time.Time) tea.Msg {
+		return spectrumFrameMsg{id: id}
+	})
+}
+
+// setSize fits a bar for every other column of width.
+func (m *spectrumModel) setSize(width, height int) {
+	m.width = width
+	m.height = height
+	bars := max((width-2-2)/2, 1) // subtract border and padding
+	if bars == len(m.bands) {
+		return
+	}
+	m.bands = make([]float64, bars)
+	m.levels = make([]float64, bars)
+	m.peaks = make([]float64, bars)
+	m.peakAge = make([]int, bars)
+	for i := range m.levels {
+		m.levels[i], m.peaks[i] = spectrumFloor, spectrumFloor
+	}
+}
+
+// adjustSmoothing changes the smoothing by delta, within its range.
+func (m *spectrumModel) adjustSmoothing(delta float64) {
+	m.smoothing = math.Max(0, math.Min(m.smoothing+delta, maxSmoothing))
+	m.smoothing = math.Round(m.smoothing*10) / 10
+}
+
+// update measures the spectrum for a frame of the view.
+func (m *spectrumModel) update() {
+	m.analyzer.Bands(m.bands, spectrumMinFreq, m.maxFreq)
+	for i, band := range m.bands {
+		m.levels[i] = m.smoothing*m.levels[i] + (1-m.smoothing)*math.Max(band, spectrumFloor)
+		if m.levels[i] >= m.peaks[i] {
+			m.peaks[i], m.peakAge[i] = m.levels[i], 0
+		} else if m.peakAge[i]++; m.peakAge[i] > peakHoldFrames {
+			m.peaks[i] = math.Max(m.peaks[i]-peakFall, m.levels[i])
+		}
+	}
+}
+
+func (m spectrumModel) View() string {
+	var b strings.Builder
+	title := titleStyle.Render("Spectrum")
+	info := mutedStyle.Render(fmt.Sprintf(" smoothing %.0f%% ('[' / ']')", m.smoothing*100))
+	b.WriteString(title + info + "\n")
+
+	rows := max(m.height-2-2, 1) // subtract border, title and axis
+	for row := rows - 1; row >= 0; row-- {
+		style := vuLowStyle
+		switch {
+		case row >= rows*9/10:
+			style = vuHighStyle
+		case row >= rows*7/10:
+			style = vuMidStyle
+		}
+		var line strings.Builder
+		for i, level := range m.levels {
+			eighths := int(math.Round((level - spectrumFloor) / -spectrumFloor * float64(rows*8)))
+			peakRow := int((m.peaks[i] - spectrumFloor) / -spectrumFloor * float64(rows))
+			block := barBlocks[max(0, min(eighths-row*8, 8))]
+			switch {
+			case block != ' ':
+				line.WriteString(style.Render(string(block)))
+			case row == min(peakRow, rows-1) && m.peaks[i] > spectrumFloor:
+				line.WriteString(peakStyle.Render("▔"))
+			default:
+				line.WriteString(" ")
+			}
+			line.WriteString(" ")
+		}
+		b.WriteString(line.String() + "\n")
+	}
+	b.WriteString(mutedStyle.Render(m.axis()))
+
+	style := lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder(), true).
+		Inherit(borderColorStyle).
+		Padding(0, 1).
+		Width(m.width - 2).
+		Height(m.height - 2)
+	return style.Render(b.String())
+}
+
+// axis returns the labels of the frequencies under the bars of their bands.
+func (m spectrumModel) axis() string {
+	line := []rune(strings.Repeat(" ", len(m.bands)*2))
+	bandsPerOctave := float64(len(m.bands)) / math.Log2(m.maxFreq/spectrumMinFreq)
+	next := 0
+	for _, freq := range []float64{50, 100, 200, 500, 1000, 2000, 5000, 10000} {
+		label := fmt.Sprintf("%.0f", freq)
+		if freq >= 1000 {
+			label = fmt.Sprintf("%.0fk", freq/1000)
+		}
+		col := int(math.Log2(freq/spectrumMinFreq)*bandsPerOctave) * 2
+		if col < next || col+len(label) > len(line) {
+			continue
+		}
+		copy(line[col:], []rune(label))
+		next = col + len(label) + 1
+	}
+	return string(line)
+}
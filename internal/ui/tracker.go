@@ -13,6 +13,7 @@ var (
 	noteStyle       = lipgloss.NewStyle().Foreground(lipgloss.Color("45"))
 	instrumentStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("27"))
 	effectStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("21"))
+	mutedStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
 )
 
 const (
@@ -28,11 +29,13 @@ type trackerModel struct {
 	height  int
 	row     int
 	pattern int
+	muted   []bool // channels muted in the player
 }
 
 func newTrackerModel(m module.Module) trackerModel {
 	return trackerModel{
 		module: m,
+		muted:  make([]bool, m.NumChannels()),
 	}
 }
 
@@ -41,6 +44,10 @@ func (m *trackerModel) update(state player.PlayerStateUpdate) {
 	m.pattern = state.Pattern
 }
 
+func (m trackerModel) isMuted(ch int) bool {
+	return ch < len(m.muted) && m.muted[ch]
+}
+
 func (m trackerModel) View() string {
 	if m.module == nil || m.pattern >= m.module.NumPatterns() {
 		return ""
@@ -58,14 +65,18 @@ func (m trackerModel) View() string {
 
 	// Header
 	headerStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FFFFFF"))
-	header := " "
+	header := headerStyle.Render(" ")
 	for ch := range numChannelsToDisplay {
-		header += fmt.Sprintf("    Chan %-4d", ch+1)
+		style := headerStyle
+		if m.isMuted(ch) {
+			style = mutedStyle
+		}
+		header += style.Render(fmt.Sprintf("    Chan %-4d", ch+1))
 	}
 	if m.module.NumChannels() > numChannelsToDisplay {
-		header += "..."
+		header += headerStyle.Render("...")
 	}
-	b.WriteString(headerStyle.Render(header) + "\n")
+	b.WriteString(header + "\n")
 
 	// availableHeight is the total height of the component, minus border, padding and header row
 	availableHeight := m.height - 4 - 1
@@ -83,6 +94,10 @@ func (m trackerModel) View() string {
 
 			for ch := range numChannelsToDisplay {
 				cellData := m.module.PatternCell(m.pattern, patternRow, ch)
+				noteStyle, instrumentStyle, effectStyle := noteStyle, instrumentStyle, effectStyle
+				if m.isMuted(ch) {
+					noteStyle, instrumentStyle, effectStyle = mutedStyle, mutedStyle, mutedStyle
+				}
 				noteStr := noteStyle.Copy().Inherit(rowStyle).Render(cellData.HumanNote)
 				instrumentStr := instrumentStyle.Copy().Inherit(rowStyle).Render(fmt.Sprintf("%02X", cellData.Instrument))
 				effectStr := effectStyle.Copy().Inherit(rowStyle).Render(fmt.Sprintf("%X%02X", cellData.Effect, cellData.EffectParam))
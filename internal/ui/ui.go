@@ -23,16 +23,25 @@ var (
 	borderColorStyle = lipgloss.NewStyle().BorderForeground(lipgloss.Color("15"))
 )
 
-func New(m module.Module) {
+func New(m module.Module, opts player.PlayerOptions, muted []int) {
 	stateUpdateChan := make(chan player.PlayerStateUpdate)
-	opts := player.DefaultPlayerOptions()
 	p := player.NewPlayer(m, func(format string, a ...interface{}) {}, stateUpdateChan, opts)
+	for _, ch := range muted {
+		p.SetChannelMute(ch, true)
+	}
 	audioPlayer, err := player.NewOtoPlayer(opts)
 	if err != nil {
 		panic(err)
 	}
 
-	mod := initialModel(m, p, audioPlayer)
+	// The channel strips drop the telemetry that does not fit while the
+	// program is busy.
+	telemetryChan := make(chan player.Telemetry, 64)
+	// The spectrum view analyzes the audio as it is written to the audio
+	// player, ahead of it by the buffer of the audio player.
+	analyzer := player.NewSpectrumAnalyzer(opts)
+	sink := player.NewTeePlayer(audioPlayer, analyzer)
+	mod := initialModel(m, p, sink, analyzer, telemetryChan)
 
 	program := tea.NewProgram(mod, tea.WithAltScreen(), tea.WithMouseAllMotion())
 
@@ -43,6 +52,12 @@ func New(m module.Module) {
 		}
 	}()
 
+	go func() {
+		for t := range telemetryChan {
+			program.Send(telemetryMsg(t))
+		}
+	}()
+
 	// Goroutine for the timer
 	go func() {
 		ticker := time.NewTicker(1 * time.Second)
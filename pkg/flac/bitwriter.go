@@ -0,0 +1,119 @@
+package flac
+
+// bitWriter packs values most significant bit first.
+type bitWriter struct {
+	buf   []byte
+	acc   uint64 // bits that do not fill a byte yet
+	nbits uint   // number of bits in acc
+}
+
+// writeBits writes the n low bits of v, for n up to 32.
+func (w *bitWriter) writeBits(v uint64, n uint) {
+	if n == 0 {
+		return
+	}
+	w.acc = w.acc<<n | v&(1<<n-1)
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.nbits -= 8
+		w.buf = append(w.buf, byte(w.acc>>w.nbits))
+	}
+	w.acc &= 1<<w.nbits - 1
+}
+
+// writeSigned writes v as an n bit two's complement number.
+func (w *bitWriter) writeSigned(v int64, n uint) {
+	w.writeBits(uint64(v), n)
+}
+
+// writeUnary writes q zero bits followed by a one bit.
+func (w *bitWriter) writeUnary(q uint64) {
+	for ; q >= 32; q -= 32 {
+		w.writeBits(0, 32)
+	}
+	w.writeBits(1, uint(q)+1)
+}
+
+// writeRice writes v with the Rice code of parameter k, after folding it to
+// an unsigned number.
+func (w *bitWriter) writeRice(v int64, k uint) {
+	u := fold(v)
+	w.writeUnary(u >> k)
+	w.writeBits(u, k)
+}
+
+// append writes all the bits of o.
+func (w *bitWriter) append(o *bitWriter) {
+	for _, b := range o.buf {
+		w.writeBits(uint64(b), 8)
+	}
+	w.writeBits(o.acc, o.nbits)
+}
+
+// len returns the number of bits written.
+func (w *bitWriter) len() int {
+	return len(w.buf)*8 + int(w.nbits)
+}
+
+// align pads the output with zero bits to a byte boundary.
+func (w *bitWriter) align() {
+	if w.nbits > 0 {
+		w.writeBits(0, 8-w.nbits)
+	}
+}
+
+func (w *bitWriter) reset() {
+	w.buf, w.acc, w.nbits = w.buf[:0], 0, 0
+}
+
+// fold maps signed residuals to unsigned numbers, interleaving the positive
+// and negative ones.
+func fold(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+var (
+	crc8Table  [256]uint8
+	crc16Table [256]uint16
+)
+
+func init() {
+	for i := range 256 {
+		c8 := uint8(i)
+		c16 := uint16(i) << 8
+		for range 8 {
+			if c8&0x80 != 0 {
+				c8 = c8<<1 ^ 0x07
+			} else {
+				c8 <<= 1
+			}
+			if c16&0x8000 != 0 {
+				c16 = c16<<1 ^ 0x8005
+			} else {
+				c16 <<= 1
+			}
+		}
+		crc8Table[i] = c8
+		crc16Table[i] = c16
+	}
+}
+
+// crc8 returns the CRC-8 of a frame header, with the polynomial
+// x^8 + x^2 + x + 1.
+func crc8(data []byte) uint8 {
+	var c uint8
+	for _, b := range data {
+		c = crc8Table[c^b]
+	}
+	return c
+}
+
+// crc16 returns the CRC-16 of a frame, with the polynomial
+// x^16 + x^15 + x^2 + 1.
+func crc16(data []byte) uint16 {
+	var c uint16
+	for _, b := range data {
+		c = c<<8 ^ crc16Table[byte(c>>8)^b]
+	}
+	return c
+}
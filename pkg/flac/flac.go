@@ -0,0 +1,309 @@
+// Package flac encodes audio in the Free Lossless Audio Codec format.
+//
+// The encoder writes fixed size blocks of BlockSize samples. Every channel of a
+// block is predicted with the best of the fixed and linear predictors, and the
+// residual is Rice coded in partitions. Stereo audio is also tried as mid and
+// side channels.
+package flac
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// BlockSize is the number of samples per channel in a frame.
+const BlockSize = 4096
+
+// Vendor is written to the Vorbis comment block of every stream.
+const Vendor = "impulse"
+
+// streamInfoOffset is the offset of the STREAMINFO block data, after the
+// "fLaC" marker and the block header.
+const streamInfoOffset = 8
+
+// Encoder writes samples to a FLAC stream.
+type Encoder struct {
+	w             io.Writer
+	sampleRate    int
+	channels      int
+	bitsPerSample int
+
+	block        [][]int32 // pending samples of each channel
+	frameNumber  uint64
+	totalSamples uint64
+	minFrameSize int
+	maxFrameSize int
+	md5          hash.Hash
+	md5Buf       []byte
+	frame        bitWriter
+	err          error
+}
+
+// NewEncoder writes the header of a FLAC stream to w, and returns an encoder
+// for its samples. Comments are Vorbis comments of the form NAME=value. If w
+// is an io.WriteSeeker, the stream length and checksum are written to the
+// header when the encoder is closed.
+func NewEncoder(w io.Writer, sampleRate, channels, bitsPerSample int, comments []string) (*Encoder, error) {
+	if sampleRate <= 0 || sampleRate >= 1<<20 {
+		return nil, fmt.Errorf("flac: unsupported sample rate %d", sampleRate)
+	}
+	if channels < 1 || channels > 8 {
+		return nil, fmt.Errorf("flac: unsupported number of channels %d", channels)
+	}
+	if bitsPerSample < 4 || bitsPerSample > 24 {
+		return nil, fmt.Errorf("flac: unsupported sample size of %d bits", bitsPerSample)
+	}
+
+	e := &Encoder{
+		w:             w,
+		sampleRate:    sampleRate,
+		channels:      channels,
+		bitsPerSample: bitsPerSample,
+		block:         make([][]int32, channels),
+		md5:           md5.New(),
+	}
+	for ch := range e.block {
+		e.block[ch] = make([]int32, 0, BlockSize)
+	}
+
+	var header bytes.Buffer
+	header.WriteString("fLaC")
+	writeBlockHeader(&header, 0, false, 34)
+	header.Write(e.streamInfo())
+	vorbis := vorbisComment(Vendor, comments)
+	writeBlockHeader(&header, 4, true, len(vorbis))
+	header.Write(vorbis)
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// writeBlockHeader writes the header of a metadata block.
+func writeBlockHeader(b *bytes.Buffer, blockType byte, last bool, length int) {
+	if last {
+		blockType |= 0x80
+	}
+	b.Write([]byte{blockType, byte(length >> 16), byte(length >> 8), byte(length)})
+}
+
+// streamInfo returns the STREAMINFO block, with the stream length, frame sizes
+// and checksum known so far.
+func (e *Encoder) streamInfo() []byte {
+	minBlock, maxBlock := BlockSize, BlockSize
+	if e.frameNumber == 1 {
+		minBlock, maxBlock = int(e.totalSamples), int(e.totalSamples)
+	}
+	var w bitWriter
+	w.writeBits(uint64(minBlock), 16)
+	w.writeBits(uint64(maxBlock), 16)
+	w.writeBits(uint64(e.minFrameSize), 24)
+	w.writeBits(uint64(e.maxFrameSize), 24)
+	w.writeBits(uint64(e.sampleRate), 20)
+	w.writeBits(uint64(e.channels-1), 3)
+	w.writeBits(uint64(e.bitsPerSample-1), 5)
+	w.writeBits(e.totalSamples>>32, 4)
+	w.writeBits(e.totalSamples, 32)
+	if e.totalSamples > 0 {
+		w.buf = e.md5.Sum(w.buf)
+	} else {
+		w.buf = append(w.buf, make([]byte, md5.Size)...)
+	}
+	return w.buf
+}
+
+// vorbisComment returns a VORBIS_COMMENT block, whose lengths are little
+// endian unlike the rest of the stream.
+func vorbisComment(vendor string, comments []string) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, uint32(len(vendor)))
+	b.WriteString(vendor)
+	binary.Write(&b, binary.LittleEndian, uint32(len(comments)))
+	for _, c := range comments {
+		binary.Write(&b, binary.LittleEndian, uint32(len(c)))
+		b.WriteString(c)
+	}
+	return b.Bytes()
+}
+
+// Write encodes interleaved samples, whose number must be a multiple of the
+// number of channels.
+func (e *Encoder) Write(samples []int32) error {
+	if e.err != nil {
+		return e.err
+	}
+	if len(samples)%e.channels != 0 {
+		return errors.New("flac: partial sample frame")
+	}
+	e.updateMD5(samples)
+	for i := 0; i < len(samples); i += e.channels {
+		for ch := range e.block {
+			e.block[ch] = append(e.block[ch], samples[i+ch])
+		}
+		if len(e.block[0]) == BlockSize {
+			if e.err = e.writeFrame(); e.err != nil {
+				return e.err
+			}
+		}
+	}
+	return nil
+}
+
+// updateMD5 adds samples to the checksum of the stream, which is computed over
+// little-endian samples.
+func (e *Encoder) updateMD5(samples []int32) {
+	size := (e.bitsPerSample + 7) / 8
+	e.md5Buf = e.md5Buf[:0]
+	for _, s := range samples {
+		for i := range size {
+			e.md5Buf = append(e.md5Buf, byte(s>>(8*i)))
+		}
+	}
+	e.md5.Write(e.md5Buf)
+}
+
+// Close encodes the remaining samples. If the output is an io.WriteSeeker,
+// the STREAMINFO block is rewritten with the length and checksum of the
+// stream, and the output is left at its end. The output is not closed.
+func (e *Encoder) Close() error {
+	if e.err != nil {
+		return e.err
+	}
+	if len(e.block[0]) > 0 {
+		if e.err = e.writeFrame(); e.err != nil {
+			return e.err
+		}
+	}
+	seeker, ok := e.w.(io.WriteSeeker)
+	if !ok {
+		return nil
+	}
+	if _, err := seeker.Seek(streamInfoOffset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := seeker.Write(e.streamInfo()); err != nil {
+		return err
+	}
+	_, err := seeker.Seek(0, io.SeekEnd)
+	return err
+}
+
+// writeFrame encodes the pending samples as a frame.
+func (e *Encoder) writeFrame() error {
+	n := len(e.block[0])
+	w := &e.frame
+	w.reset()
+
+	// Frame header
+	blockSizeCode := blockSizeCode(n)
+	sampleRateCode := sampleRateCode(e.sampleRate)
+	var subframes []*bitWriter
+	var assignment uint64
+	if e.channels == 2 {
+		assignment, subframes = encodeStereo(e.block[0], e.block[1], e.bitsPerSample)
+	} else {
+		assignment = uint64(e.channels - 1)
+		for _, samples := range e.block {
+			subframes = append(subframes, encodeSubframe(samples, e.bitsPerSample))
+		}
+	}
+	w.writeBits(0xFFF8, 16) // Sync code, fixed block size
+	w.writeBits(blockSizeCode, 4)
+	w.writeBits(sampleRateCode, 4)
+	w.writeBits(assignment, 4)
+	w.writeBits(sampleSizeCode(e.bitsPerSample), 3)
+	w.writeBits(0, 1)
+	writeUTF8(w, e.frameNumber)
+	switch blockSizeCode {
+	case 6:
+		w.writeBits(uint64(n-1), 8)
+	case 7:
+		w.writeBits(uint64(n-1), 16)
+	}
+	w.writeBits(uint64(crc8(w.buf)), 8)
+
+	for _, s := range subframes {
+		w.append(s)
+	}
+	w.align()
+	w.writeBits(uint64(crc16(w.buf)), 16)
+
+	if _, err := e.w.Write(w.buf); err != nil {
+		return err
+	}
+	if e.minFrameSize == 0 || len(w.buf) < e.minFrameSize {
+		e.minFrameSize = len(w.buf)
+	}
+	e.maxFrameSize = max(e.maxFrameSize, len(w.buf))
+	e.frameNumber++
+	e.totalSamples += uint64(n)
+	for ch := range e.block {
+		e.block[ch] = e.block[ch][:0]
+	}
+	return nil
+}
+
+// blockSizeCode returns the frame header code of a block size. Codes 6 and 7
+// store the block size after the frame number in 8 or 16 bits.
+func blockSizeCode(n int) uint64 {
+	switch n {
+	case 192:
+		return 1
+	case 576, 1152, 2304, 4608:
+		return uint64(2 + log2(n/576))
+	case 256, 512, 1024, 2048, 4096, 8192, 16384, 32768:
+		return uint64(8 + log2(n/256))
+	}
+	if n <= 256 {
+		return 6
+	}
+	return 7
+}
+
+func log2(n int) int {
+	var k int
+	for ; n > 1; n >>= 1 {
+		k++
+	}
+	return k
+}
+
+// sampleRateCode returns the frame header code of a sample rate, or 0 to take
+// it from the STREAMINFO block.
+func sampleRateCode(rate int) uint64 {
+	codes := map[int]uint64{
+		88200: 1, 176400: 2, 192000: 3, 8000: 4, 16000: 5, 22050: 6,
+		24000: 7, 32000: 8, 44100: 9, 48000: 10, 96000: 11,
+	}
+	return codes[rate]
+}
+
+// sampleSizeCode returns the frame header code of a sample size, or 0 to take
+// it from the STREAMINFO block.
+func sampleSizeCode(bits int) uint64 {
+	codes := map[int]uint64{8: 1, 12: 2, 16: 4, 20: 5, 24: 6}
+	return codes[bits]
+}
+
+// writeUTF8 writes a frame number in the variable length encoding of UTF-8,
+// extended to 36 bits.
+func writeUTF8(w *bitWriter, v uint64) {
+	if v < 0x80 {
+		w.writeBits(v, 8)
+		return
+	}
+	n := 2 // number of bytes
+	for v >= 1<<(5*n+1) {
+		n++
+	}
+	w.writeBits((1<<n-1)<<1, uint(n)+1)  // n one bits followed by a zero bit
+	w.writeBits(v>>(6*(n-1)), 7-uint(n)) // the high bits
+	for i := n - 2; i >= 0; i-- {
+		w.writeBits(0x80|(v>>(6*i))&0x3F, 8)
+	}
+}
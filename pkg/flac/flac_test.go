@@ -0,0 +1,364 @@
+package flac
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// bitReader reads the bits of a FLAC stream for the test decoder.
+type bitReader struct {
+	data []byte
+	pos  int // in bits
+}
+
+func (r *bitReader) bits(n int) uint64 {
+	var v uint64
+	for range n {
+		if r.pos/8 >= len(r.data) {
+			panic(io.ErrUnexpectedEOF)
+		}
+		v = v<<1 | uint64(r.data[r.pos/8]>>(7-r.pos%8)&1)
+		r.pos++
+	}
+	return v
+}
+
+func (r *bitReader) signed(n int) int64 {
+	v := r.bits(n)
+	if n > 0 && v&(1<<(n-1)) != 0 {
+		return int64(v) - 1<<n
+	}
+	return int64(v)
+}
+
+func (r *bitReader) rice(k int) int64 {
+	var q uint64
+	for r.bits(1) == 0 {
+		q++
+	}
+	u := q<<k | r.bits(k)
+	return int64(u>>1) ^ -int64(u&1)
+}
+
+type streamInfo struct {
+	sampleRate, channels, bps int
+	totalSamples              uint64
+	md5                       []byte
+}
+
+// decode is a minimal FLAC decoder for the streams of the encoder, which
+// checks the CRCs and returns the interleaved samples.
+func decode(t *testing.T, data []byte) (info streamInfo, comments []string, samples []int32) {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("fLaC")) {
+		t.Fatalf("missing fLaC marker")
+	}
+	pos := 4
+	for last := false; !last; {
+		header := data[pos : pos+4]
+		last = header[0]&0x80 != 0
+		length := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+		block := data[pos+4 : pos+4+length]
+		switch header[0] & 0x7F {
+		case 0:
+			r := &bitReader{data: block}
+			r.bits(16 + 16 + 24 + 24)
+			info.sampleRate = int(r.bits(20))
+			info.channels = int(r.bits(3)) + 1
+			info.bps = int(r.bits(5)) + 1
+			info.totalSamples = r.bits(36)
+			info.md5 = block[18:34]
+		case 4:
+			vendor := binary.LittleEndian.Uint32(block)
+			p := 4 + int(vendor)
+			count := binary.LittleEndian.Uint32(block[p:])
+			p += 4
+			for range count {
+				n := int(binary.LittleEndian.Uint32(block[p:]))
+				comments = append(comments, string(block[p+4:p+4+n]))
+				p += 4 + n
+			}
+		}
+		pos += 4 + length
+	}
+
+	for pos < len(data) {
+		r := &bitReader{data: data[pos:]}
+		if sync := r.bits(16); sync != 0xFFF8 {
+			t.Fatalf("frame at %d: sync code %#x", pos, sync)
+		}
+		bsCode, srCode, assignment, ssCode := r.bits(4), r.bits(4), r.bits(4), r.bits(3)
+		r.bits(1)
+		if srCode != 9 || ssCode != sampleSizeCode(info.bps) {
+			t.Fatalf("frame at %d: sample rate code %d, sample size code %d", pos, srCode, ssCode)
+		}
+		// Frame number, in UTF-8 encoding
+		first := r.bits(8)
+		for mask := uint64(0x40); first&0x80 != 0 && first&mask != 0; mask >>= 1 {
+			r.bits(8)
+		}
+		var n int
+		switch {
+		case bsCode == 1:
+			n = 192
+		case bsCode >= 2 && bsCode <= 5:
+			n = 576 << (bsCode - 2)
+		case bsCode == 6:
+			n = int(r.bits(8)) + 1
+		case bsCode == 7:
+			n = int(r.bits(16)) + 1
+		case bsCode >= 8:
+			n = 256 << (bsCode - 8)
+		}
+		if crc := crc8(data[pos : pos+r.pos/8]); uint64(crc) != r.bits(8) {
+			t.Fatalf("frame at %d: header CRC mismatch", pos)
+		}
+
+		channels := make([][]int64, info.channels)
+		for ch := range channels {
+			bps := info.bps
+			if (assignment == leftSide || assignment == midSide) && ch == 1 || assignment == rightSide && ch == 0 {
+				bps++
+			}
+			channels[ch] = decodeSubframe(t, r, n, bps)
+		}
+		for i := range n {
+			switch assignment {
+			case leftSide:
+				channels[1][i] = channels[0][i] - channels[1][i]
+			case rightSide:
+				channels[0][i] += channels[1][i]
+			case midSide:
+				mid, side := channels[0][i]<<1|channels[1][i]&1, channels[1][i]
+				channels[0][i], channels[1][i] = (mid+side)>>1, (mid-side)>>1
+			}
+		}
+		if r.pos%8 != 0 {
+			r.bits(8 - r.pos%8)
+		}
+		end := pos + r.pos/8
+		if crc := crc16(data[pos:end]); uint64(crc) != r.bits(16) {
+			t.Fatalf("frame at %d: CRC mismatch", pos)
+		}
+		pos = end + 2
+		for i := range n {
+			for ch := range channels {
+				samples = append(samples, int32(channels[ch][i]))
+			}
+		}
+	}
+	return info, comments, samples
+}
+
+func decodeSubframe(t *testing.T, r *bitReader, n, bps int) []int64 {
+	t.Helper()
+	r.bits(1)
+	kind := int(r.bits(6))
+	if r.bits(1) != 0 {
+		t.Fatal("unexpected wasted bits")
+	}
+	out := make([]int64, n)
+	switch {
+	case kind == 0:
+		v := r.signed(bps)
+		for i := range out {
+			out[i] = v
+		}
+	case kind == 1:
+		for i := range out {
+			out[i] = r.signed(bps)
+		}
+	case kind >= 8 && kind <= 12:
+		order := kind - 8
+		for i := range order {
+			out[i] = r.signed(bps)
+		}
+		decodeResidual(r, out, order)
+		coefs := [][]int64{{}, {1}, {2, -1}, {3, -3, 1}, {4, -6, 4, -1}}[order]
+		for i := order; i < n; i++ {
+			for j, c := range coefs {
+				out[i] += c * out[i-j-1]
+			}
+		}
+	case kind >= 32:
+		order := kind - 31
+		for i := range order {
+			out[i] = r.signed(bps)
+		}
+		precision := int(r.bits(4)) + 1
+		shift := r.signed(5)
+		coefs := make([]int64, order)
+		for i := range coefs {
+			coefs[i] = r.signed(precision)
+		}
+		decodeResidual(r, out, order)
+		for i := order; i < n; i++ {
+			var sum int64
+			for j, c := range coefs {
+				sum += c * out[i-j-1]
+			}
+			out[i] += sum >> shift
+		}
+	default:
+		t.Fatalf("unexpected subframe type %d", kind)
+	}
+	return out
+}
+
+func decodeResidual(r *bitReader, out []int64, order int) {
+	paramBits := 4 + int(r.bits(2))
+	partitionOrder := int(r.bits(4))
+	i := order
+	for p := range 1 << partitionOrder {
+		k := int(r.bits(paramBits))
+		count := len(out) >> partitionOrder
+		if p == 0 {
+			count -= order
+		}
+		for range count {
+			out[i] = r.rice(k)
+			i++
+		}
+	}
+}
+
+// encode encodes samples to a FLAC stream in memory.
+func encode(t *testing.T, samples []int32, channels, bps int, comments []string) []byte {
+	t.Helper()
+	f, err := os.Create(filepath.Join(t.TempDir(), "test.flac"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	e, err := NewEncoder(f, 44100, channels, bps, comments)
+	if err != nil {
+		t.Fatalf("NewEncoder() failed: %v", err)
+	}
+	// Write in uneven chunks to cross block boundaries.
+	for len(samples) > 0 {
+		n := min(len(samples), 1000*channels)
+		if err := e.Write(samples[:n]); err != nil {
+			t.Fatalf("Write() failed: %v", err)
+		}
+		samples = samples[n:]
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestEncoder_roundTrip(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	signals := map[string]func(i, ch, bps int) int32{
+		"tone": func(i, ch, bps int) int32 {
+			amp := float64(int(1)<<(bps-1)) * 0.8
+			return int32(amp * math.Sin(float64(i)*0.01*float64(ch+1)))
+		},
+		"noise": func(i, ch, bps int) int32 {
+			return int32(rng.IntN(1<<bps) - 1<<(bps-1))
+		},
+		"silence": func(i, ch, bps int) int32 { return 0 },
+		"full scale": func(i, ch, bps int) int32 {
+			if (i/50)%2 == 0 {
+				return int32(1<<(bps-1) - 1)
+			}
+			return int32(-1 << (bps - 1))
+		},
+	}
+	for name, signal := range signals {
+		for _, format := range []struct{ channels, bps, frames int }{
+			{2, 16, 3*BlockSize + 123},
+			{1, 8, 1000},
+			{2, 24, BlockSize},
+			{3, 16, 2*BlockSize - 1},
+		} {
+			t.Run(fmt.Sprintf("%s/%dch/%dbit", name, format.channels, format.bps), func(t *testing.T) {
+				var samples []int32
+				for i := range format.frames {
+					for ch := range format.channels {
+						samples = append(samples, signal(i, ch, format.bps))
+					}
+				}
+				info, _, got := decode(t, encode(t, samples, format.channels, format.bps, nil))
+				if info.channels != format.channels || info.bps != format.bps || info.sampleRate != 44100 {
+					t.Errorf("STREAMINFO = %+v, want %d channels of %d bits", info, format.channels, format.bps)
+				}
+				if info.totalSamples != uint64(format.frames) {
+					t.Errorf("STREAMINFO has %d samples, want %d", info.totalSamples, format.frames)
+				}
+				if !slices.Equal(got, samples) {
+					t.Fatalf("decoded %d samples that differ from the %d encoded", len(got), len(samples))
+				}
+				var raw []byte
+				for _, s := range samples {
+					for i := range (format.bps + 7) / 8 {
+						raw = append(raw, byte(s>>(8*i)))
+					}
+				}
+				if sum := md5.Sum(raw); !bytes.Equal(info.md5, sum[:]) {
+					t.Errorf("STREAMINFO MD5 = %x, want %x", info.md5, sum)
+				}
+			})
+		}
+	}
+}
+
+func TestEncoder_comments(t *testing.T) {
+	want := []string{"TITLE=space debris", "COMMENT=a\nb"}
+	_, comments, _ := decode(t, encode(t, make([]int32, 200), 2, 16, want))
+	if !slices.Equal(comments, want) {
+		t.Errorf("comments = %q, want %q", comments, want)
+	}
+}
+
+func TestEncoder_compression(t *testing.T) {
+	samples := make([]int32, 10*BlockSize*2)
+	for i := range samples {
+		samples[i] = int32(20000 * math.Sin(float64(i/2)*0.05) * math.Sin(float64(i/2)*0.0003))
+	}
+	data := encode(t, samples, 2, 16, nil)
+	if ratio := float64(len(data)) / float64(len(samples)*2); ratio > 0.5 {
+		t.Errorf("a tone compressed to %.0f%% of its size, want less than 50%%", ratio*100)
+	}
+}
+
+func TestUpdateComments(t *testing.T) {
+	samples := make([]int32, 3000*2)
+	for i := range samples {
+		samples[i] = int32(i % 100)
+	}
+	data := encode(t, samples, 2, 16, []string{"TITLE=space debris", "REPLAYGAIN_TRACK_GAIN=1.00 dB"})
+
+	var out bytes.Buffer
+	err := UpdateComments(bytes.NewReader(data), &out, func(comments []string) []string {
+		return append(comments[:1], "REPLAYGAIN_TRACK_GAIN=-3.50 dB")
+	})
+	if err != nil {
+		t.Fatalf("UpdateComments() failed: %v", err)
+	}
+	_, comments, got := decode(t, out.Bytes())
+	if want := []string{"TITLE=space debris", "REPLAYGAIN_TRACK_GAIN=-3.50 dB"}; !slices.Equal(comments, want) {
+		t.Errorf("comments = %q, want %q", comments, want)
+	}
+	if !slices.Equal(got, samples) {
+		t.Errorf("decoded %d samples that differ from the %d encoded", len(got), len(samples))
+	}
+
+	if err := UpdateComments(bytes.NewReader([]byte("RIFF")), io.Discard, nil); err == nil {
+		t.Error("UpdateComments() of a WAV file succeeded, want an error")
+	}
+}
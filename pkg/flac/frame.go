@@ -0,0 +1,353 @@
+package flac
+
+import (
+	"math"
+	"math/bits"
+)
+
+// Channel assignments of stereo frames.
+const (
+	leftRight = 1
+	leftSide  = 8
+	rightSide = 9
+	midSide   = 10
+)
+
+const (
+	// maxFixedOrder is the highest order of the fixed predictors.
+	maxFixedOrder = 4
+	// maxLPCOrder is the highest order of the linear predictors tried.
+	maxLPCOrder = 8
+	// lpcPrecision is the number of bits of the quantized LPC coefficients.
+	lpcPrecision = 15
+	// maxPartitionOrder is the highest order of the Rice partitions tried.
+	maxPartitionOrder = 8
+)
+
+// encodeStereo encodes a stereo block with the channel assignment that gives
+// the smallest frame.
+func encodeStereo(left, right []int32, bps int) (uint64, []*bitWriter) {
+	mid := make([]int32, len(left))
+	side := make([]int32, len(left))
+	for i := range left {
+		mid[i] = int32((int64(left[i]) + int64(right[i])) >> 1)
+		side[i] = left[i] - right[i]
+	}
+	l := encodeSubframe(left, bps)
+	r := encodeSubframe(right, bps)
+	m := encodeSubframe(mid, bps)
+	s := encodeSubframe(side, bps+1)
+
+	assignment, subframes, size := uint64(leftRight), []*bitWriter{l, r}, l.len()+r.len()
+	for _, c := range []struct {
+		assignment uint64
+		a, b       *bitWriter
+	}{
+		{leftSide, l, s},
+		{rightSide, s, r},
+		{midSide, m, s},
+	} {
+		if n := c.a.len() + c.b.len(); n < size {
+			assignment, subframes, size = c.assignment, []*bitWriter{c.a, c.b}, n
+		}
+	}
+	return assignment, subframes
+}
+
+// encodeSubframe encodes the samples of one channel with the predictor that
+// gives the smallest subframe.
+func encodeSubframe(samples []int32, bps int) *bitWriter {
+	w := &bitWriter{}
+	constant := true
+	for _, s := range samples[1:] {
+		if s != samples[0] {
+			constant = false
+			break
+		}
+	}
+	if constant {
+		w.writeBits(0, 8) // CONSTANT
+		w.writeSigned(int64(samples[0]), uint(bps))
+		return w
+	}
+
+	// VERBATIM is the fallback for noise that cannot be predicted. The other
+	// subframes are compared by their estimated size and only the smallest
+	// one is written.
+	bestBits := 8 + len(samples)*bps
+	writeBest := func(w *bitWriter) {
+		w.writeBits(0x02, 8)
+		for _, s := range samples {
+			w.writeSigned(int64(s), uint(bps))
+		}
+	}
+	residual := make([]int64, len(samples))
+	bestResidual := make([]int64, len(samples))
+
+	for order := 0; order <= maxFixedOrder && order < len(samples); order++ {
+		fixedResidual(samples, order, residual)
+		plan := planResidual(residual[order:], len(samples), order)
+		if bits := 8 + order*bps + plan.bits; bits < bestBits {
+			residual, bestResidual = bestResidual, residual
+			r := bestResidual
+			bestBits = bits
+			writeBest = func(w *bitWriter) {
+				w.writeBits(uint64(0x08|order)<<1, 8) // FIXED
+				for _, s := range samples[:order] {
+					w.writeSigned(int64(s), uint(bps))
+				}
+				plan.write(w, r[order:])
+			}
+		}
+	}
+
+	if coefs := lpcCoefficients(samples, min(maxLPCOrder, len(samples)-1), bps); coefs != nil {
+		order := len(coefs)
+		q, shift, ok := quantizeCoefficients(coefs)
+		if ok && lpcResidual(samples, q, shift, residual) {
+			plan := planResidual(residual[order:], len(samples), order)
+			if bits := 8 + order*bps + 9 + order*lpcPrecision + plan.bits; bits < bestBits {
+				r := residual
+				writeBest = func(w *bitWriter) {
+					w.writeBits(uint64(0x20|(order-1))<<1, 8) // LPC
+					for _, s := range samples[:order] {
+						w.writeSigned(int64(s), uint(bps))
+					}
+					w.writeBits(lpcPrecision-1, 4)
+					w.writeSigned(int64(shift), 5)
+					for _, c := range q {
+						w.writeSigned(int64(c), lpcPrecision)
+					}
+					plan.write(w, r[order:])
+				}
+			}
+		}
+	}
+	writeBest(w)
+	return w
+}
+
+// fixedResidual computes the residual of a fixed polynomial predictor of the
+// given order, from the order sample on.
+func fixedResidual(samples []int32, order int, residual []int64) {
+	for i := order; i < len(samples); i++ {
+		x0 := int64(samples[i])
+		switch order {
+		case 0:
+			residual[i] = x0
+		case 1:
+			residual[i] = x0 - int64(samples[i-1])
+		case 2:
+			residual[i] = x0 - 2*int64(samples[i-1]) + int64(samples[i-2])
+		case 3:
+			residual[i] = x0 - 3*int64(samples[i-1]) + 3*int64(samples[i-2]) - int64(samples[i-3])
+		case 4:
+			residual[i] = x0 - 4*int64(samples[i-1]) + 6*int64(samples[i-2]) - 4*int64(samples[i-3]) + int64(samples[i-4])
+		}
+	}
+}
+
+// lpcCoefficients returns the linear predictor of up to maxOrder that is
+// expected to give the smallest subframe, found with the Levinson-Durbin
+// recursion from the autocorrelation of the samples under a Welch window.
+func lpcCoefficients(samples []int32, maxOrder, bps int) []float64 {
+	if maxOrder < 1 {
+		return nil
+	}
+	n := len(samples)
+	windowed := make([]float64, n)
+	for i, s := range samples {
+		x := (2*float64(i) - float64(n-1)) / float64(n+1)
+		windowed[i] = float64(s) * (1 - x*x)
+	}
+	autoc := make([]float64, maxOrder+1)
+	for lag := range autoc {
+		var sum float64
+		for i := lag; i < n; i++ {
+			sum += windowed[i] * windowed[i-lag]
+		}
+		autoc[lag] = sum
+	}
+	if autoc[0] == 0 {
+		return nil
+	}
+
+	var best []float64
+	bestBits := math.Inf(1)
+	lpc := make([]float64, maxOrder)
+	err := autoc[0]
+	for order := 0; order < maxOrder; order++ {
+		r := -autoc[order+1]
+		for j := range order {
+			r -= lpc[j] * autoc[order-j]
+		}
+		r /= err
+		lpc[order] = r
+		for j := range order / 2 {
+			lpc[j], lpc[order-1-j] = lpc[j]+r*lpc[order-1-j], lpc[order-1-j]+r*lpc[j]
+		}
+		if order%2 != 0 {
+			lpc[order/2] += lpc[order/2] * r
+		}
+		err *= 1 - r*r
+
+		// The residual of a predictor with the error err takes about
+		// log2(err/n)/2 bits per sample.
+		residualBits := 0.0
+		if err > 0 {
+			residualBits = math.Max(0.5*math.Log2(err*0.5/float64(n)), 0)
+		}
+		if bits := residualBits*float64(n-order-1) + float64((order+1)*(bps+lpcPrecision)); bits < bestBits {
+			// The predictor is the negated filter coefficients.
+			best = make([]float64, order+1)
+			for j := range best {
+				best[j] = -lpc[j]
+			}
+			bestBits = bits
+		}
+		if err <= 0 {
+			break
+		}
+	}
+	return best
+}
+
+// quantizeCoefficients quantizes LPC coefficients to lpcPrecision bits with
+// the returned shift. It reports false if the coefficients are out of range.
+func quantizeCoefficients(coefs []float64) ([]int32, int, bool) {
+	var cmax float64
+	for _, c := range coefs {
+		cmax = math.Max(cmax, math.Abs(c))
+	}
+	if cmax == 0 || math.IsNaN(cmax) || math.IsInf(cmax, 0) {
+		return nil, 0, false
+	}
+	_, exp := math.Frexp(cmax) // cmax < 2^exp
+	shift := lpcPrecision - 1 - exp
+	if shift < 0 {
+		return nil, 0, false
+	}
+	shift = min(shift, 15)
+
+	const qmax = 1<<(lpcPrecision-1) - 1
+	q := make([]int32, len(coefs))
+	var e float64
+	for i, c := range coefs {
+		e += c * float64(int(1)<<shift)
+		v := math.Max(math.Min(math.Round(e), qmax), -qmax-1)
+		q[i] = int32(v)
+		e -= v
+	}
+	return q, shift, true
+}
+
+// lpcResidual computes the residual of a quantized linear predictor, from the
+// order sample on. It reports false if the residual does not fit the 32 bits
+// that decoders use.
+func lpcResidual(samples []int32, q []int32, shift int, residual []int64) bool {
+	order := len(q)
+	for i := order; i < len(samples); i++ {
+		var sum int64
+		for j, c := range q {
+			sum += int64(c) * int64(samples[i-j-1])
+		}
+		r := int64(samples[i]) - sum>>shift
+		if r > math.MaxInt32 || r < math.MinInt32 {
+			return false
+		}
+		residual[i] = r
+	}
+	return true
+}
+
+// ricePlan is the partitioned Rice coding of a residual.
+type ricePlan struct {
+	bits           int // estimated size
+	partitionOrder int
+	params         []uint
+	blockSize      int
+	order          int // of the predictor, which shortens the first partition
+}
+
+// planResidual chooses the partition order and Rice parameters that give the
+// fewest bits for a residual.
+func planResidual(residual []int64, blockSize, order int) ricePlan {
+	// Sums of the folded residual in the partitions of the highest order
+	// that the block size allows.
+	maxOrder := 0
+	for maxOrder < maxPartitionOrder && blockSize%(2<<maxOrder) == 0 && blockSize>>(maxOrder+1) > order {
+		maxOrder++
+	}
+	sums := make([]uint64, 1<<maxOrder)
+	partitionSize := blockSize >> maxOrder
+	start := 0
+	for p := range sums {
+		end := (p+1)*partitionSize - order
+		var sum uint64
+		for _, r := range residual[start:end] {
+			sum += fold(r)
+		}
+		sums[p], start = sum, end
+	}
+
+	best := ricePlan{bits: math.MaxInt, blockSize: blockSize, order: order}
+	for po := maxOrder; po >= 0; po-- {
+		if po < maxOrder {
+			for i := range 1 << po {
+				sums[i] = sums[2*i] + sums[2*i+1]
+			}
+			sums = sums[:1<<po]
+		}
+		bits := 6
+		params := make([]uint, len(sums))
+		for i, sum := range sums {
+			n := blockSize >> po
+			if i == 0 {
+				n -= order
+			}
+			params[i] = riceParameter(sum, n)
+			bits += riceBits(sum, n, params[i])
+		}
+		if bits < best.bits {
+			best.bits, best.partitionOrder, best.params = bits, po, params
+		}
+	}
+	return best
+}
+
+// write writes a residual as planned.
+func (p ricePlan) write(w *bitWriter, residual []int64) {
+	method, paramBits := uint64(0), uint(4)
+	for _, k := range p.params {
+		if k > 14 {
+			method, paramBits = 1, 5
+		}
+	}
+	w.writeBits(method, 2)
+	w.writeBits(uint64(p.partitionOrder), 4)
+	partitionSize := p.blockSize >> p.partitionOrder
+	start := 0
+	for i, k := range p.params {
+		end := (i+1)*partitionSize - p.order
+		w.writeBits(uint64(k), paramBits)
+		for _, r := range residual[start:end] {
+			w.writeRice(r, k)
+		}
+		start = end
+	}
+}
+
+// riceParameter estimates the best Rice parameter for n values whose folded
+// sum is sum, which is near the logarithm of their mean.
+func riceParameter(sum uint64, n int) uint {
+	if n == 0 {
+		return 0
+	}
+	return uint(min(max(bits.Len64(sum/uint64(n))-1, 0), 30))
+}
+
+// riceBits estimates the number of bits of n values Rice coded with parameter
+// k, including the parameter.
+func riceBits(sum uint64, n int, k uint) int {
+	return 5 + n*(int(k)+1) + int(sum>>k)
+}
@@ -0,0 +1,109 @@
+package flac
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// metadataBlock is a metadata block read from a stream.
+type metadataBlock struct {
+	blockType byte
+	data      []byte
+}
+
+// UpdateComments copies the FLAC stream of r to w with its Vorbis comments
+// replaced by the result of update, which is given the current comments. The
+// other metadata blocks and the frames are copied unchanged, and a
+// VORBIS_COMMENT block is added if the stream has none.
+func UpdateComments(r io.Reader, w io.Writer, update func(comments []string) []string) error {
+	marker := make([]byte, 4)
+	if _, err := io.ReadFull(r, marker); err != nil {
+		return err
+	}
+	if string(marker) != "fLaC" {
+		return errors.New("flac: missing fLaC marker")
+	}
+
+	var blocks []metadataBlock
+	comment := -1
+	for last := false; !last; {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return err
+		}
+		last = header[0]&0x80 != 0
+		block := metadataBlock{
+			blockType: header[0] & 0x7F,
+			data:      make([]byte, int(header[1])<<16|int(header[2])<<8|int(header[3])),
+		}
+		if _, err := io.ReadFull(r, block.data); err != nil {
+			return err
+		}
+		if block.blockType == 4 {
+			comment = len(blocks)
+		}
+		blocks = append(blocks, block)
+	}
+
+	vendor, comments := Vendor, []string(nil)
+	if comment >= 0 {
+		var err error
+		if vendor, comments, err = parseVorbisComment(blocks[comment].data); err != nil {
+			return err
+		}
+	} else {
+		comment = len(blocks)
+		blocks = append(blocks, metadataBlock{blockType: 4})
+	}
+	blocks[comment].data = vorbisComment(vendor, update(comments))
+	if len(blocks[comment].data) >= 1<<24 {
+		return errors.New("flac: Vorbis comments too long")
+	}
+
+	var header bytes.Buffer
+	header.WriteString("fLaC")
+	for i, block := range blocks {
+		writeBlockHeader(&header, block.blockType, i == len(blocks)-1, len(block.data))
+		header.Write(block.data)
+	}
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
+	}
+	_, err := io.Copy(w, r)
+	return err
+}
+
+// parseVorbisComment returns the vendor string and the comments of a
+// VORBIS_COMMENT block.
+func parseVorbisComment(data []byte) (string, []string, error) {
+	errCorrupt := errors.New("flac: corrupt VORBIS_COMMENT block")
+	next := func() (string, bool) {
+		if len(data) < 4 {
+			return "", false
+		}
+		n := binary.LittleEndian.Uint32(data)
+		if uint64(n) > uint64(len(data)-4) {
+			return "", false
+		}
+		s := string(data[4 : 4+n])
+		data = data[4+n:]
+		return s, true
+	}
+	vendor, ok := next()
+	if !ok || len(data) < 4 {
+		return "", nil, errCorrupt
+	}
+	count := binary.LittleEndian.Uint32(data)
+	data = data[4:]
+	var comments []string
+	for range count {
+		c, ok := next()
+		if !ok {
+			return "", nil, errCorrupt
+		}
+		comments = append(comments, c)
+	}
+	return vendor, comments, nil
+}
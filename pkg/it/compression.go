@@ -0,0 +1,170 @@
+package it
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// IT214 (and its delta-of-delta variant IT215) stores samples in blocks of
+// variable bit width codes. Each block is prefixed with its packed length and
+// decodes to at most 0x8000 8-bit or 0x4000 16-bit samples. The decoder
+// follows itsex.c from Schism Tracker.
+
+// bitReader reads little-endian bit fields from a compressed block. Reading
+// past the end of the block yields zero bits, which matches the behaviour of
+// Impulse Tracker when it encounters short blocks.
+type bitReader struct {
+	buf    []byte
+	pos    int
+	bits   uint32
+	remain uint
+}
+
+func (b *bitReader) read(n uint) uint32 {
+	var value uint32
+	for i := uint(0); i < n; i++ {
+		if b.remain == 0 {
+			b.bits = 0
+			if b.pos < len(b.buf) {
+				b.bits = uint32(b.buf[b.pos])
+				b.pos++
+			}
+			b.remain = 8
+		}
+		value |= (b.bits & 1) << i
+		b.bits >>= 1
+		b.remain--
+	}
+	return value
+}
+
+func readBlock(r io.Reader) (*bitReader, error) {
+	var length uint16
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return nil, fmt.Errorf("reading compressed block length: %w", err)
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, fmt.Errorf("reading compressed block: %w", err)
+	}
+	return &bitReader{buf: buf}, nil
+}
+
+// nextWidth returns the bit width selected by a width change code.
+func nextWidth(value uint32, width uint) uint {
+	if uint(value) < width {
+		return uint(value)
+	}
+	return uint(value) + 1
+}
+
+// decompress8 decodes length IT214/IT215 compressed 8-bit samples.
+func decompress8(r io.Reader, length int, it215 bool) ([]int16, error) {
+	out := make([]int16, 0, length)
+	for len(out) < length {
+		br, err := readBlock(r)
+		if err != nil {
+			return nil, err
+		}
+		blockLength := min(0x8000, length-len(out))
+		width := uint(9)
+		var d1, d2 int8
+		for n := 0; n < blockLength; {
+			if width == 0 || width > 9 {
+				return nil, fmt.Errorf("invalid bit width %d in compressed sample", width)
+			}
+			value := br.read(width)
+			switch {
+			case width < 7:
+				if value == 1<<(width-1) {
+					width = nextWidth(br.read(3)+1, width)
+					continue
+				}
+			case width < 9:
+				border := (uint32(0xFF) >> (9 - width)) - 4
+				if value > border && value <= border+8 {
+					width = nextWidth(value-border, width)
+					continue
+				}
+			default:
+				if value&0x100 != 0 {
+					width = uint((value + 1) & 0xFF)
+					continue
+				}
+			}
+
+			var delta int8
+			if width < 8 {
+				shift := 8 - width
+				delta = int8(byte(value)<<shift) >> shift
+			} else {
+				delta = int8(value)
+			}
+			d1 += delta
+			d2 += d1
+			if it215 {
+				out = append(out, int16(d2)<<8)
+			} else {
+				out = append(out, int16(d1)<<8)
+			}
+			n++
+		}
+	}
+	return out, nil
+}
+
+// decompress16 decodes length IT214/IT215 compressed 16-bit samples.
+func decompress16(r io.Reader, length int, it215 bool) ([]int16, error) {
+	out := make([]int16, 0, length)
+	for len(out) < length {
+		br, err := readBlock(r)
+		if err != nil {
+			return nil, err
+		}
+		blockLength := min(0x4000, length-len(out))
+		width := uint(17)
+		var d1, d2 int16
+		for n := 0; n < blockLength; {
+			if width == 0 || width > 17 {
+				return nil, fmt.Errorf("invalid bit width %d in compressed sample", width)
+			}
+			value := br.read(width)
+			switch {
+			case width < 7:
+				if value == 1<<(width-1) {
+					width = nextWidth(br.read(4)+1, width)
+					continue
+				}
+			case width < 17:
+				border := (uint32(0xFFFF) >> (17 - width)) - 8
+				if value > border && value <= border+16 {
+					width = nextWidth(value-border, width)
+					continue
+				}
+			default:
+				if value&0x10000 != 0 {
+					width = uint((value + 1) & 0xFF)
+					continue
+				}
+			}
+
+			var delta int16
+			if width < 16 {
+				shift := 16 - width
+				delta = int16(uint16(value)<<shift) >> shift
+			} else {
+				delta = int16(value)
+			}
+			d1 += delta
+			d2 += d1
+			if it215 {
+				out = append(out, d2)
+			} else {
+				out = append(out, d1)
+			}
+			n++
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,430 @@
+package it
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jesseward/impulse/pkg/module"
+)
+
+// Header flags.
+const (
+	FlagStereo       = 1 << 0
+	FlagVol0MixOpt   = 1 << 1
+	FlagInstruments  = 1 << 2
+	FlagLinearSlides = 1 << 3
+	FlagOldEffects   = 1 << 4
+	FlagCompatGxx    = 1 << 5
+)
+
+// Special header flags.
+const (
+	SpecialMessage = 1 << 0
+)
+
+// Sample flags.
+const (
+	SampleAssociated      = 1 << 0
+	Sample16Bit           = 1 << 1
+	SampleStereo          = 1 << 2
+	SampleCompressed      = 1 << 3
+	SampleLoop            = 1 << 4
+	SampleSustainLoop     = 1 << 5
+	SamplePingPong        = 1 << 6
+	SampleSustainPingPong = 1 << 7
+)
+
+// Sample conversion flags.
+const (
+	ConvertSigned    = 1 << 0
+	ConvertBigEndian = 1 << 1
+	ConvertDelta     = 1 << 2
+)
+
+// Envelope flags.
+const (
+	EnvelopeOn          = 1 << 0
+	EnvelopeLoop        = 1 << 1
+	EnvelopeSustainLoop = 1 << 2
+	EnvelopeFilter      = 1 << 7
+)
+
+// New Note Actions.
+const (
+	NNACut      = 0
+	NNAContinue = 1
+	NNANoteOff  = 2
+	NNANoteFade = 3
+)
+
+// Duplicate Check Types.
+const (
+	DCTOff        = 0
+	DCTNote       = 1
+	DCTSample     = 2
+	DCTInstrument = 3
+)
+
+// Duplicate Check Actions.
+const (
+	DCACut      = 0
+	DCANoteOff  = 1
+	DCANoteFade = 2
+)
+
+// Pattern note values. Regular notes are stored as 1-120 (C-0 to B-9) so that
+// zero can mean "no note", in line with the XM package.
+const (
+	NoteNone = 0
+	NoteFade = 253
+	NoteCut  = 254
+	NoteOff  = 255
+)
+
+// VolumeNone marks an empty volume column.
+const VolumeNone = 255
+
+// Header represents the fixed-size portion of the IT file header.
+// See ITTECH.TXT "Impulse Header Layout".
+type Header struct {
+	Signature        [4]byte // IMPM
+	SongName         [26]byte
+	PatternHighlight uint16
+	OrderCount       uint16
+	InstrumentCount  uint16
+	SampleCount      uint16
+	PatternCount     uint16
+	CreatedWith      uint16
+	CompatibleWith   uint16
+	Flags            uint16
+	Special          uint16
+	GlobalVolume     byte
+	MixVolume        byte
+	InitialSpeed     byte
+	InitialTempo     byte
+	Separation       byte
+	PitchWheelDepth  byte
+	MessageLength    uint16
+	MessageOffset    uint32
+	Reserved         uint32
+	ChannelPan       [64]byte
+	ChannelVolume    [64]byte
+}
+
+// EnvelopeNode is a single point of an instrument envelope.
+type EnvelopeNode struct {
+	Value int8
+	Tick  uint16
+}
+
+// Envelope represents a volume, panning or pitch envelope of an instrument.
+type Envelope struct {
+	Flags        byte
+	NumNodes     byte
+	LoopBegin    byte
+	LoopEnd      byte
+	SustainBegin byte
+	SustainEnd   byte
+	Nodes        [25]EnvelopeNode
+	Reserved     byte
+}
+
+// Enabled reports whether the envelope is switched on.
+func (e *Envelope) Enabled() bool {
+	return e.Flags&EnvelopeOn != 0 && e.NumNodes > 0
+}
+
+// HasLoop reports whether the envelope loop is enabled.
+func (e *Envelope) HasLoop() bool {
+	return e.Flags&EnvelopeLoop != 0 && e.LoopBegin <= e.LoopEnd && e.LoopEnd < e.NumNodes
+}
+
+// HasSustainLoop reports whether the envelope sustain loop is enabled.
+func (e *Envelope) HasSustainLoop() bool {
+	return e.Flags&EnvelopeSustainLoop != 0 && e.SustainBegin <= e.SustainEnd && e.SustainEnd < e.NumNodes
+}
+
+// IsFilter reports whether a pitch envelope drives the resonant filter instead of the pitch.
+func (e *Envelope) IsFilter() bool {
+	return e.Flags&EnvelopeFilter != 0
+}
+
+// KeyboardEntry maps a played note to the note and sample that are actually triggered.
+type KeyboardEntry struct {
+	Note   byte
+	Sample byte
+}
+
+// Instrument represents an IT instrument.
+// See ITTECH.TXT "Impulse Instrument Format".
+type Instrument struct {
+	Filename             string
+	Name                 string
+	NewNoteAction        byte
+	DuplicateCheckType   byte
+	DuplicateCheckAction byte
+	FadeOut              uint16
+	PitchPanSeparation   int8
+	PitchPanCenter       byte
+	GlobalVolume         byte
+	DefaultPan           byte // 0-64, bit 7 set means "don't use"
+	RandomVolume         byte
+	RandomPan            byte
+	FilterCutoff         byte // bit 7 set means the cutoff is used
+	FilterResonance      byte // bit 7 set means the resonance is used
+	Keyboard             [120]KeyboardEntry
+	VolumeEnvelope       Envelope
+	PanningEnvelope      Envelope
+	PitchEnvelope        Envelope
+}
+
+// Sample represents an IT sample.
+// See ITTECH.TXT "Impulse Sample Format".
+type Sample struct {
+	Filename         string
+	GlobalVolume     byte
+	flags            byte
+	volume           byte
+	name             string
+	Convert          byte
+	DefaultPan       byte // 0-64, bit 7 set means the value is used
+	length           uint32
+	loopBegin        uint32
+	loopEnd          uint32
+	C5Speed          uint32
+	SustainLoopBegin uint32
+	SustainLoopEnd   uint32
+	pointer          uint32
+	VibratoSpeed     byte
+	VibratoDepth     byte
+	VibratoRate      byte
+	VibratoType      byte
+	data             []int16
+}
+
+// Cell represents a single entry in a pattern for a single channel.
+type Cell struct {
+	Note         byte
+	Instrument   byte
+	VolumePan    byte
+	Command      byte
+	CommandValue byte
+}
+
+// Pattern represents an IT pattern with a variable number of rows.
+type Pattern struct {
+	Rows [][]Cell
+}
+
+// Module represents a parsed Impulse Tracker module.
+type Module struct {
+	Header      Header
+	Orders      []byte
+	Message     string
+	Instruments []*Instrument
+	Patterns    []*Pattern
+	samples     []*Sample
+	numChannels int
+}
+
+var noteTable = [12]string{"C-", "C#", "D-", "D#", "E-", "F-", "F#", "G-", "G#", "A-", "A#", "B-"}
+
+// NoteToString returns the tracker representation of a pattern note.
+func NoteToString(note byte) string {
+	switch note {
+	case NoteNone:
+		return module.EmptyNote
+	case NoteOff:
+		return "==="
+	case NoteCut:
+		return "^^^"
+	case NoteFade:
+		return "~~~"
+	}
+	if note > 120 {
+		return module.EmptyNote
+	}
+	return fmt.Sprintf("%s%d", noteTable[(note-1)%12], (note-1)/12)
+}
+
+// Name returns the name of the module.
+func (m *Module) Name() string {
+	return strings.TrimRight(string(m.Header.SongName[:]), "\x00")
+}
+
+func (m *Module) Type() string {
+	return "Impulse Tracker"
+}
+
+// SongLength returns the number of entries in the order list up to the end-of-song marker.
+func (m *Module) SongLength() int {
+	return len(m.PatternOrder())
+}
+
+// NumChannels returns the number of channels used by the patterns.
+func (m *Module) NumChannels() int {
+	return m.numChannels
+}
+
+// NumPatterns returns the number of patterns in the module.
+func (m *Module) NumPatterns() int {
+	return len(m.Patterns)
+}
+
+func (m *Module) NumRows(pattern int) int {
+	if pattern < 0 || pattern >= len(m.Patterns) {
+		return 0
+	}
+	return len(m.Patterns[pattern].Rows)
+}
+
+// Samples returns a slice of the module's samples.
+func (m *Module) Samples() []module.Sample {
+	samples := make([]module.Sample, len(m.samples))
+	for i, s := range m.samples {
+		samples[i] = s
+	}
+	return samples
+}
+
+// PatternOrder returns the order list up to the end-of-song marker (255).
+// Skip markers (254) are kept so that position jumps keep pointing at the
+// right entry; the player ignores them as out of range patterns.
+func (m *Module) PatternOrder() []int {
+	order := make([]int, 0, len(m.Orders))
+	for _, o := range m.Orders {
+		if o == 255 {
+			break
+		}
+		order = append(order, int(o))
+	}
+	return order
+}
+
+func (m *Module) DefaultSpeed() int {
+	return int(m.Header.InitialSpeed)
+}
+
+func (m *Module) DefaultBPM() int {
+	return int(m.Header.InitialTempo)
+}
+
+// UsesInstruments reports whether notes are played through instruments rather than samples.
+func (m *Module) UsesInstruments() bool {
+	return m.Header.Flags&FlagInstruments != 0
+}
+
+// Sample returns the 1-based sample n, or nil if it does not exist.
+func (m *Module) Sample(n int) *Sample {
+	if n < 1 || n > len(m.samples) {
+		return nil
+	}
+	return m.samples[n-1]
+}
+
+// Instrument returns the 1-based instrument n, or nil if it does not exist.
+func (m *Module) Instrument(n int) *Instrument {
+	if n < 1 || n > len(m.Instruments) {
+		return nil
+	}
+	return m.Instruments[n-1]
+}
+
+// PatternCell returns a generic representation of a pattern cell.
+func (m *Module) PatternCell(pattern, row, channel int) module.Cell {
+	if pattern < 0 || pattern >= len(m.Patterns) || channel >= m.numChannels {
+		return module.Cell{}
+	}
+	p := m.Patterns[pattern]
+	if row < 0 || row >= len(p.Rows) || channel >= len(p.Rows[row]) {
+		return module.Cell{}
+	}
+	cell := p.Rows[row][channel]
+	return module.Cell{
+		HumanNote:   NoteToString(cell.Note),
+		Note:        cell.Note,
+		Instrument:  cell.Instrument,
+		Volume:      cell.VolumePan,
+		Effect:      cell.Command,
+		EffectParam: cell.CommandValue,
+	}
+}
+
+// Name returns the name of the sample.
+func (s *Sample) Name() string {
+	return s.name
+}
+
+// Length returns the length of the sample in sample frames.
+func (s *Sample) Length() uint32 {
+	return s.length
+}
+
+// Volume returns the default volume of the sample (0-64).
+func (s *Sample) Volume() uint8 {
+	return s.volume
+}
+
+// LoopStart returns the starting position of the sample loop.
+func (s *Sample) LoopStart() uint32 {
+	return s.loopBegin
+}
+
+// LoopEnd returns the end position of the sample loop.
+func (s *Sample) LoopEnd() uint32 {
+	return s.loopEnd
+}
+
+// LoopLength returns the length of the sample loop, or 0 if the loop is disabled.
+func (s *Sample) LoopLength() uint32 {
+	if !s.HasLoop() {
+		return 0
+	}
+	return s.loopEnd - s.loopBegin
+}
+
+// Finetune returns the C-5 playback frequency of the sample.
+func (s *Sample) Finetune() uint32 {
+	return s.C5Speed
+}
+
+func (s *Sample) Flags() byte {
+	return s.flags
+}
+
+func (s *Sample) IsPingPong() bool {
+	return s.flags&SamplePingPong != 0
+}
+
+func (s *Sample) RelativeNote() int8 {
+	return 0
+}
+
+// Panning returns the default panning of the sample scaled to 0-255, or the
+// centre position if the sample does not override the channel panning.
+func (s *Sample) Panning() byte {
+	if s.DefaultPan&0x80 == 0 {
+		return 128
+	}
+	return byte(min(int(s.DefaultPan&0x7F)*4, 255))
+}
+
+// Data returns the sample data as 16-bit signed integers. Stereo samples are mixed down to mono.
+func (s *Sample) Data() []int16 {
+	return s.data
+}
+
+// HasLoop reports whether the sample loop is enabled.
+func (s *Sample) HasLoop() bool {
+	return s.flags&SampleLoop != 0 && s.loopEnd > s.loopBegin && s.loopEnd <= s.length
+}
+
+// HasSustainLoop reports whether the sample sustain loop is enabled.
+func (s *Sample) HasSustainLoop() bool {
+	return s.flags&SampleSustainLoop != 0 && s.SustainLoopEnd > s.SustainLoopBegin && s.SustainLoopEnd <= s.length
+}
+
+// IsSustainPingPong reports whether the sustain loop is bidirectional.
+func (s *Sample) IsSustainPingPong() bool {
+	return s.flags&SampleSustainPingPong != 0
+}
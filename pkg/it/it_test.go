@@ -0,0 +1,333 @@
+package it
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/jesseward/impulse/pkg/module"
+)
+
+// bitWriter packs little-endian bit fields, mirroring bitReader.
+type bitWriter struct {
+	buf  []byte
+	bits uint
+}
+
+func (w *bitWriter) write(value uint32, n uint) {
+	for i := uint(0); i < n; i++ {
+		if w.bits%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		w.buf[len(w.buf)-1] |= byte((value>>i)&1) << (w.bits % 8)
+		w.bits++
+	}
+}
+
+func (w *bitWriter) block() []byte {
+	out := binary.LittleEndian.AppendUint16(nil, uint16(len(w.buf)))
+	return append(out, w.buf...)
+}
+
+func put(buf *bytes.Buffer, v any) {
+	binary.Write(buf, binary.LittleEndian, v)
+}
+
+// buildTestModule assembles a small IT module with one instrument, an
+// uncompressed sample, an IT214 compressed sample and a single pattern.
+func buildTestModule(t *testing.T) []byte {
+	t.Helper()
+
+	header := Header{
+		OrderCount:      3,
+		InstrumentCount: 1,
+		SampleCount:     2,
+		PatternCount:    1,
+		CreatedWith:     0x0214,
+		CompatibleWith:  0x0214,
+		Flags:           FlagStereo | FlagInstruments | FlagLinearSlides,
+		GlobalVolume:    128,
+		MixVolume:       48,
+		InitialSpeed:    6,
+		InitialTempo:    125,
+		Separation:      128,
+	}
+	copy(header.Signature[:], "IMPM")
+	copy(header.SongName[:], "IT TEST SONG")
+	for i := range header.ChannelPan {
+		header.ChannelPan[i] = 32
+		header.ChannelVolume[i] = 64
+	}
+
+	orders := []byte{0, 254, 255}
+	offsetTableSize := 4 * (1 + 2 + 1)
+	instrumentOffset := 0xC0 + len(orders) + offsetTableSize
+	instrumentSize := binary.Size(instrumentHeader{})
+	sample1Offset := instrumentOffset + instrumentSize
+	sampleSize := binary.Size(sampleHeader{})
+	sample2Offset := sample1Offset + sampleSize
+	patternOffset := sample2Offset + sampleSize
+
+	// Pattern: row 0 channel 0 plays C-5 with instrument 1, volume 32 and effect A06.
+	// Row 1 channel 2 plays a note off and row 2 channel 0 reuses the previous mask.
+	var packed []byte
+	packed = append(packed, 0x81, 0x0F, 60, 1, 32, 1, 6)
+	packed = append(packed, 0)
+	packed = append(packed, 0x83, 0x01, 255)
+	packed = append(packed, 0)
+	packed = append(packed, 0x01) // channel 0 reuses mask 0x0F
+	packed = append(packed, 61, 1, 64, 0, 0)
+	packed = append(packed, 0)
+	patternBytes := new(bytes.Buffer)
+	put(patternBytes, uint16(len(packed)))
+	put(patternBytes, uint16(32))
+	put(patternBytes, [4]byte{})
+	patternBytes.Write(packed)
+
+	sample1Data := patternOffset + patternBytes.Len()
+	pcm := []byte{0, 10, 20, 246} // signed 8-bit
+	sample2Data := sample1Data + len(pcm)
+
+	// IT214 compressed 8-bit sample: three 9-bit deltas, a switch to 5-bit width and two 5-bit deltas.
+	bw := &bitWriter{}
+	bw.write(5, 9)
+	bw.write(5, 9)
+	bw.write(0xF6, 9)  // -10
+	bw.write(0x104, 9) // width -> 5
+	bw.write(3, 5)     // +3
+	bw.write(0x1E, 5)  // -2
+	compressed := bw.block()
+
+	buf := new(bytes.Buffer)
+	put(buf, header)
+	buf.Write(orders)
+	put(buf, uint32(instrumentOffset))
+	put(buf, uint32(sample1Offset))
+	put(buf, uint32(sample2Offset))
+	put(buf, uint32(patternOffset))
+
+	inst := instrumentHeader{
+		NNA:             NNANoteFade,
+		DCT:             DCTNote,
+		DCA:             DCANoteOff,
+		FadeOut:         256,
+		GlobalVolume:    128,
+		DefaultPan:      32 | 0x80,
+		FilterCutoff:    0x80 | 100,
+		FilterResonance: 0x80 | 20,
+	}
+	copy(inst.Signature[:], "IMPI")
+	copy(inst.Name[:], "lead")
+	for n := range inst.Keyboard {
+		inst.Keyboard[n] = KeyboardEntry{Note: byte(n), Sample: 1}
+	}
+	inst.Keyboard[61].Sample = 2
+	inst.VolumeEnvelope.Flags = EnvelopeOn | EnvelopeSustainLoop
+	inst.VolumeEnvelope.NumNodes = 3
+	inst.VolumeEnvelope.SustainBegin = 1
+	inst.VolumeEnvelope.SustainEnd = 1
+	inst.VolumeEnvelope.Nodes[0] = EnvelopeNode{Value: 0, Tick: 0}
+	inst.VolumeEnvelope.Nodes[1] = EnvelopeNode{Value: 64, Tick: 10}
+	inst.VolumeEnvelope.Nodes[2] = EnvelopeNode{Value: 0, Tick: 20}
+	inst.PitchEnvelope.Flags = EnvelopeOn | EnvelopeFilter
+	put(buf, inst)
+
+	s1 := sampleHeader{
+		GlobalVolume: 64,
+		Flags:        SampleAssociated | SampleLoop,
+		Volume:       48,
+		Convert:      ConvertSigned,
+		DefaultPan:   0x80 | 16,
+		Length:       uint32(len(pcm)),
+		LoopBegin:    1,
+		LoopEnd:      4,
+		C5Speed:      8363,
+	}
+	copy(s1.Signature[:], "IMPS")
+	copy(s1.Name[:], "raw")
+	s1.SamplePointer = uint32(sample1Data)
+	put(buf, s1)
+
+	s2 := sampleHeader{
+		GlobalVolume:  64,
+		Flags:         SampleAssociated | SampleCompressed,
+		Volume:        64,
+		Convert:       ConvertSigned,
+		Length:        5,
+		C5Speed:       22050,
+		SamplePointer: uint32(sample2Data),
+	}
+	copy(s2.Signature[:], "IMPS")
+	copy(s2.Name[:], "packed")
+	put(buf, s2)
+
+	if buf.Len() != patternOffset {
+		t.Fatalf("pattern offset mismatch: %d != %d", buf.Len(), patternOffset)
+	}
+	buf.Write(patternBytes.Bytes())
+	buf.Write(pcm)
+	buf.Write(compressed)
+	return buf.Bytes()
+}
+
+func TestRead(t *testing.T) {
+	mod, err := Read(bytes.NewReader(buildTestModule(t)))
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+
+	if mod.Name() != "IT TEST SONG" {
+		t.Errorf("Name() = %q, want %q", mod.Name(), "IT TEST SONG")
+	}
+	if mod.Type() != "Impulse Tracker" {
+		t.Errorf("Type() = %q", mod.Type())
+	}
+	if got := mod.PatternOrder(); !reflect.DeepEqual(got, []int{0, 254}) {
+		t.Errorf("PatternOrder() = %v, want [0 254]", got)
+	}
+	if mod.NumChannels() != 3 {
+		t.Errorf("NumChannels() = %d, want 3", mod.NumChannels())
+	}
+	if mod.NumRows(0) != 32 {
+		t.Errorf("NumRows(0) = %d, want 32", mod.NumRows(0))
+	}
+	if !mod.UsesInstruments() {
+		t.Error("UsesInstruments() = false, want true")
+	}
+
+	inst := mod.Instrument(1)
+	if inst == nil || inst.Name != "lead" {
+		t.Fatalf("Instrument(1) = %+v", inst)
+	}
+	if inst.NewNoteAction != NNANoteFade || inst.DuplicateCheckType != DCTNote || inst.DuplicateCheckAction != DCANoteOff {
+		t.Errorf("unexpected NNA/DCT/DCA: %d/%d/%d", inst.NewNoteAction, inst.DuplicateCheckType, inst.DuplicateCheckAction)
+	}
+	if !inst.VolumeEnvelope.Enabled() || !inst.VolumeEnvelope.HasSustainLoop() || inst.VolumeEnvelope.HasLoop() {
+		t.Errorf("unexpected volume envelope flags %08b", inst.VolumeEnvelope.Flags)
+	}
+	if inst.VolumeEnvelope.Nodes[1] != (EnvelopeNode{Value: 64, Tick: 10}) {
+		t.Errorf("unexpected envelope node %+v", inst.VolumeEnvelope.Nodes[1])
+	}
+	if !inst.PitchEnvelope.IsFilter() {
+		t.Error("pitch envelope should be a filter envelope")
+	}
+	if inst.Keyboard[61] != (KeyboardEntry{Note: 61, Sample: 2}) {
+		t.Errorf("unexpected keyboard entry %+v", inst.Keyboard[61])
+	}
+
+	s1 := mod.Sample(1)
+	if s1.Name() != "raw" || s1.Volume() != 48 || s1.Finetune() != 8363 {
+		t.Errorf("unexpected sample 1 header: %q vol %d c5 %d", s1.Name(), s1.Volume(), s1.Finetune())
+	}
+	if s1.LoopLength() != 3 || s1.Panning() != 64 {
+		t.Errorf("unexpected sample 1 loop length %d panning %d", s1.LoopLength(), s1.Panning())
+	}
+	if want := []int16{0, 10 << 8, 20 << 8, -10 << 8}; !reflect.DeepEqual(s1.Data(), want) {
+		t.Errorf("sample 1 data = %v, want %v", s1.Data(), want)
+	}
+
+	s2 := mod.Sample(2)
+	if want := []int16{5 << 8, 10 << 8, 0, 3 << 8, 1 << 8}; !reflect.DeepEqual(s2.Data(), want) {
+		t.Errorf("sample 2 data = %v, want %v", s2.Data(), want)
+	}
+
+	cell := mod.PatternCell(0, 0, 0)
+	want := module.Cell{HumanNote: "C-5", Note: 61, Instrument: 1, Volume: 32, Effect: 1, EffectParam: 6}
+	if cell != want {
+		t.Errorf("PatternCell(0, 0, 0) = %+v, want %+v", cell, want)
+	}
+	if cell := mod.PatternCell(0, 1, 2); cell.Note != NoteOff || cell.HumanNote != "===" || cell.Volume != VolumeNone {
+		t.Errorf("PatternCell(0, 1, 2) = %+v, want note off", cell)
+	}
+	if cell := mod.PatternCell(0, 2, 0); cell.Note != 62 || cell.Instrument != 1 || cell.Volume != 64 {
+		t.Errorf("PatternCell(0, 2, 0) = %+v, want C#5 with reused mask", cell)
+	}
+}
+
+func TestRead_BadSignature(t *testing.T) {
+	data := buildTestModule(t)
+	copy(data, "XXXX")
+	if _, err := Read(bytes.NewReader(data)); err == nil {
+		t.Fatal("Read() error = nil, want signature error")
+	}
+}
+
+func TestRead_EnvelopeNodeCount(t *testing.T) {
+	data := buildTestModule(t)
+	// The volume envelope follows the keyboard, at the end of the instrument
+	// header but for the panning and pitch envelopes.
+	instrumentOffset := int(binary.LittleEndian.Uint32(data[0xC0+3:]))
+	numNodes := instrumentOffset + binary.Size(instrumentHeader{}) - 3*binary.Size(Envelope{}) + 1
+	if data[numNodes] != 3 {
+		t.Fatalf("volume envelope node count at %d = %d, want 3", numNodes, data[numNodes])
+	}
+	data[numNodes] = 0xFF
+
+	mod, err := Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if got := mod.Instrument(1).VolumeEnvelope.NumNodes; got != 25 {
+		t.Errorf("VolumeEnvelope.NumNodes = %d, want 25", got)
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	t.Run("IT215 8-bit", func(t *testing.T) {
+		bw := &bitWriter{}
+		for _, d := range []uint32{1, 1, 0xFF} { // d1: 1, 2, 1 -> d2: 1, 3, 4
+			bw.write(d, 9)
+		}
+		got, err := decompress8(bytes.NewReader(bw.block()), 3, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []int16{1 << 8, 3 << 8, 4 << 8}; !reflect.DeepEqual(got, want) {
+			t.Errorf("decompress8() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("IT214 16-bit", func(t *testing.T) {
+		bw := &bitWriter{}
+		bw.write(1000, 17)
+		bw.write(0xFFFF, 17)  // -1
+		bw.write(0x1000B, 17) // width -> 12
+		bw.write(0xFFE, 12)   // -2
+		got, err := decompress16(bytes.NewReader(bw.block()), 3, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []int16{1000, 999, 997}; !reflect.DeepEqual(got, want) {
+			t.Errorf("decompress16() = %v, want %v", got, want)
+		}
+	})
+}
+
+func TestNoteToString(t *testing.T) {
+	tests := []struct {
+		note     byte
+		expected string
+	}{
+		{NoteNone, "..."},
+		{1, "C-0"},
+		{61, "C-5"},
+		{120, "B-9"},
+		{NoteOff, "==="},
+		{NoteCut, "^^^"},
+		{NoteFade, "~~~"},
+	}
+	for _, tt := range tests {
+		if got := NoteToString(tt.note); got != tt.expected {
+			t.Errorf("NoteToString(%d) = %q, want %q", tt.note, got, tt.expected)
+		}
+	}
+}
+
+func TestModule_ImplementsModule(t *testing.T) {
+	var _ module.Module = (*Module)(nil)
+}
+
+func TestSample_ImplementsSample(t *testing.T) {
+	var _ module.Sample = (*Sample)(nil)
+}
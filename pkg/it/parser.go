@@ -0,0 +1,541 @@
+package it
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// instrumentHeader is the on-disk layout of an instrument written by IT 2.00 and later.
+type instrumentHeader struct {
+	Signature          [4]byte // IMPI
+	DOSFilename        [12]byte
+	Zero               byte
+	NNA                byte
+	DCT                byte
+	DCA                byte
+	FadeOut            uint16
+	PitchPanSeparation int8
+	PitchPanCenter     byte
+	GlobalVolume       byte
+	DefaultPan         byte
+	RandomVolume       byte
+	RandomPan          byte
+	TrackerVersion     uint16
+	NumSamples         byte
+	Reserved           byte
+	Name               [26]byte
+	FilterCutoff       byte
+	FilterResonance    byte
+	MIDIChannel        byte
+	MIDIProgram        byte
+	MIDIBank           uint16
+	Keyboard           [120]KeyboardEntry
+	VolumeEnvelope     Envelope
+	PanningEnvelope    Envelope
+	PitchEnvelope      Envelope
+}
+
+// oldInstrumentHeader is the on-disk layout of an instrument written by trackers older than IT 2.00.
+type oldInstrumentHeader struct {
+	Signature        [4]byte // IMPI
+	DOSFilename      [12]byte
+	Zero             byte
+	Flags            byte
+	VolumeLoopBegin  byte
+	VolumeLoopEnd    byte
+	SustainLoopBegin byte
+	SustainLoopEnd   byte
+	Reserved         [2]byte
+	FadeOut          uint16
+	NNA              byte
+	DuplicateCheck   byte
+	TrackerVersion   uint16
+	NumSamples       byte
+	Reserved2        byte
+	Name             [26]byte
+	Reserved3        [6]byte
+	Keyboard         [120]KeyboardEntry
+	VolumeEnvelope   [200]byte
+	Nodes            [25][2]byte // tick, magnitude
+}
+
+// sampleHeader is the on-disk layout of a sample header.
+type sampleHeader struct {
+	Signature        [4]byte // IMPS
+	DOSFilename      [12]byte
+	Zero             byte
+	GlobalVolume     byte
+	Flags            byte
+	Volume           byte
+	Name             [26]byte
+	Convert          byte
+	DefaultPan       byte
+	Length           uint32
+	LoopBegin        uint32
+	LoopEnd          uint32
+	C5Speed          uint32
+	SustainLoopBegin uint32
+	SustainLoopEnd   uint32
+	SamplePointer    uint32
+	VibratoSpeed     byte
+	VibratoDepth     byte
+	VibratoRate      byte
+	VibratoType      byte
+}
+
+// Probe returns a confidence score from 0 to 100 that header, the start of a
+// file, is an Impulse Tracker module.
+func Probe(header []byte) int {
+	if len(header) >= 4 && string(header[:4]) == "IMPM" {
+		return 100
+	}
+	return 0
+}
+
+// Read parses an Impulse Tracker module from r. Instruments, samples and
+// patterns are addressed through absolute file offsets, so readers that do not
+// support seeking are buffered into memory first.
+func Read(r io.Reader) (*Module, error) {
+	seeker, ok := r.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read IT data: %w", err)
+		}
+		seeker = bytes.NewReader(data)
+	}
+
+	m := &Module{}
+	if err := binary.Read(seeker, binary.LittleEndian, &m.Header); err != nil {
+		return nil, fmt.Errorf("error reading IT header: %w", err)
+	}
+	if string(m.Header.Signature[:]) != "IMPM" {
+		return nil, errors.New("not an Impulse Tracker module: missing IMPM signature")
+	}
+
+	m.Orders = make([]byte, m.Header.OrderCount)
+	if _, err := io.ReadFull(seeker, m.Orders); err != nil {
+		return nil, fmt.Errorf("error reading orders: %w", err)
+	}
+
+	instrumentOffsets := make([]uint32, m.Header.InstrumentCount)
+	if err := binary.Read(seeker, binary.LittleEndian, &instrumentOffsets); err != nil {
+		return nil, fmt.Errorf("error reading instrument offsets: %w", err)
+	}
+	sampleOffsets := make([]uint32, m.Header.SampleCount)
+	if err := binary.Read(seeker, binary.LittleEndian, &sampleOffsets); err != nil {
+		return nil, fmt.Errorf("error reading sample offsets: %w", err)
+	}
+	patternOffsets := make([]uint32, m.Header.PatternCount)
+	if err := binary.Read(seeker, binary.LittleEndian, &patternOffsets); err != nil {
+		return nil, fmt.Errorf("error reading pattern offsets: %w", err)
+	}
+
+	if m.Header.Special&SpecialMessage != 0 && m.Header.MessageLength > 0 {
+		if err := m.readMessage(seeker); err != nil {
+			return nil, err
+		}
+	}
+
+	m.Instruments = make([]*Instrument, m.Header.InstrumentCount)
+	for i, offset := range instrumentOffsets {
+		if _, err := seeker.Seek(int64(offset), io.SeekStart); err != nil {
+			return nil, fmt.Errorf("seeking to instrument %d: %w", i, err)
+		}
+		inst := &Instrument{}
+		var err error
+		if m.Header.CompatibleWith < 0x200 {
+			err = inst.parseOld(seeker)
+		} else {
+			err = inst.parse(seeker)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading instrument %d: %w", i, err)
+		}
+		m.Instruments[i] = inst
+	}
+
+	m.samples = make([]*Sample, m.Header.SampleCount)
+	for i, offset := range sampleOffsets {
+		if _, err := seeker.Seek(int64(offset), io.SeekStart); err != nil {
+			return nil, fmt.Errorf("seeking to sample %d: %w", i, err)
+		}
+		s := &Sample{}
+		if err := s.parseHeader(seeker); err != nil {
+			return nil, fmt.Errorf("reading sample %d: %w", i, err)
+		}
+		if err := s.parseData(seeker); err != nil {
+			return nil, fmt.Errorf("reading sample data for sample %d: %w", i, err)
+		}
+		m.samples[i] = s
+	}
+
+	m.Patterns = make([]*Pattern, m.Header.PatternCount)
+	for i, offset := range patternOffsets {
+		p := &Pattern{}
+		if offset == 0 {
+			// A zero offset denotes an empty 64 row pattern.
+			p.Rows = make([][]Cell, 64)
+			for row := range p.Rows {
+				p.Rows[row] = emptyRow(64)
+			}
+		} else {
+			if _, err := seeker.Seek(int64(offset), io.SeekStart); err != nil {
+				return nil, fmt.Errorf("seeking to pattern %d: %w", i, err)
+			}
+			channels, err := p.parse(seeker)
+			if err != nil {
+				return nil, fmt.Errorf("reading pattern %d: %w", i, err)
+			}
+			m.numChannels = max(m.numChannels, channels)
+		}
+		m.Patterns[i] = p
+	}
+
+	if m.numChannels == 0 {
+		m.numChannels = 1
+	}
+	for _, p := range m.Patterns {
+		for row := range p.Rows {
+			p.Rows[row] = p.Rows[row][:m.numChannels]
+		}
+	}
+
+	return m, nil
+}
+
+func (m *Module) readMessage(r io.ReadSeeker) error {
+	if _, err := r.Seek(int64(m.Header.MessageOffset), io.SeekStart); err != nil {
+		return fmt.Errorf("seeking to song message: %w", err)
+	}
+	message := make([]byte, m.Header.MessageLength)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return fmt.Errorf("reading song message: %w", err)
+	}
+	text := strings.TrimRight(string(message), "\x00")
+	m.Message = strings.ReplaceAll(text, "\r", "\n")
+	return nil
+}
+
+func (i *Instrument) parse(r io.Reader) error {
+	var header instrumentHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	if string(header.Signature[:]) != "IMPI" {
+		return errors.New("missing IMPI signature")
+	}
+	i.Filename = strings.TrimRight(string(header.DOSFilename[:]), "\x00")
+	i.Name = strings.TrimRight(string(header.Name[:]), "\x00")
+	i.NewNoteAction = header.NNA
+	i.DuplicateCheckType = header.DCT
+	i.DuplicateCheckAction = header.DCA
+	i.FadeOut = header.FadeOut
+	i.PitchPanSeparation = header.PitchPanSeparation
+	i.PitchPanCenter = header.PitchPanCenter
+	i.GlobalVolume = header.GlobalVolume
+	i.DefaultPan = header.DefaultPan
+	i.RandomVolume = header.RandomVolume
+	i.RandomPan = header.RandomPan
+	i.FilterCutoff = header.FilterCutoff
+	i.FilterResonance = header.FilterResonance
+	i.Keyboard = header.Keyboard
+	i.VolumeEnvelope = header.VolumeEnvelope
+	i.PanningEnvelope = header.PanningEnvelope
+	i.PitchEnvelope = header.PitchEnvelope
+	// A corrupt instrument may count more nodes than an envelope holds.
+	for _, env := range []*Envelope{&i.VolumeEnvelope, &i.PanningEnvelope, &i.PitchEnvelope} {
+		env.NumNodes = min(env.NumNodes, byte(len(env.Nodes)))
+	}
+	return nil
+}
+
+// parseOld converts a pre-2.00 instrument into the current instrument layout.
+// Old instruments only carry a volume envelope and an on/off duplicate note check.
+func (i *Instrument) parseOld(r io.Reader) error {
+	var header oldInstrumentHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	if string(header.Signature[:]) != "IMPI" {
+		return errors.New("missing IMPI signature")
+	}
+	i.Filename = strings.TrimRight(string(header.DOSFilename[:]), "\x00")
+	i.Name = strings.TrimRight(string(header.Name[:]), "\x00")
+	i.NewNoteAction = header.NNA
+	if header.DuplicateCheck != 0 {
+		i.DuplicateCheckType = DCTNote
+	}
+	// The old fadeout counts down from 512 instead of 1024.
+	i.FadeOut = header.FadeOut * 2
+	i.GlobalVolume = 128
+	i.DefaultPan = 32 | 0x80
+	i.PitchPanCenter = 60
+	i.Keyboard = header.Keyboard
+
+	env := &i.VolumeEnvelope
+	env.Flags = header.Flags & (EnvelopeOn | EnvelopeLoop | EnvelopeSustainLoop)
+	env.LoopBegin = header.VolumeLoopBegin
+	env.LoopEnd = header.VolumeLoopEnd
+	env.SustainBegin = header.SustainLoopBegin
+	env.SustainEnd = header.SustainLoopEnd
+	for _, node := range header.Nodes {
+		if node[0] == 0xFF {
+			break
+		}
+		env.Nodes[env.NumNodes] = EnvelopeNode{Value: int8(node[1]), Tick: uint16(node[0])}
+		env.NumNodes++
+	}
+	return nil
+}
+
+func (s *Sample) parseHeader(r io.Reader) error {
+	var header sampleHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	if string(header.Signature[:]) != "IMPS" {
+		return errors.New("missing IMPS signature")
+	}
+	s.Filename = strings.TrimRight(string(header.DOSFilename[:]), "\x00")
+	s.GlobalVolume = header.GlobalVolume
+	s.flags = header.Flags
+	s.volume = header.Volume
+	s.name = strings.TrimRight(string(header.Name[:]), "\x00")
+	s.Convert = header.Convert
+	s.DefaultPan = header.DefaultPan
+	s.length = header.Length
+	s.loopBegin = header.LoopBegin
+	s.loopEnd = header.LoopEnd
+	s.C5Speed = header.C5Speed
+	s.SustainLoopBegin = header.SustainLoopBegin
+	s.SustainLoopEnd = header.SustainLoopEnd
+	s.pointer = header.SamplePointer
+	s.VibratoSpeed = header.VibratoSpeed
+	s.VibratoDepth = header.VibratoDepth
+	s.VibratoRate = header.VibratoRate
+	s.VibratoType = header.VibratoType
+	return nil
+}
+
+func (s *Sample) parseData(r io.ReadSeeker) error {
+	if s.flags&SampleAssociated == 0 || s.length == 0 {
+		return nil
+	}
+	if _, err := r.Seek(int64(s.pointer), io.SeekStart); err != nil {
+		return err
+	}
+
+	numChannels := 1
+	if s.flags&SampleStereo != 0 {
+		numChannels = 2
+	}
+	is16Bit := s.flags&Sample16Bit != 0
+	length := int(s.length)
+
+	channels := make([][]int16, numChannels)
+	for ch := range channels {
+		var err error
+		switch {
+		case s.flags&SampleCompressed != 0 && is16Bit:
+			channels[ch], err = decompress16(r, length, s.Convert&ConvertDelta != 0)
+		case s.flags&SampleCompressed != 0:
+			channels[ch], err = decompress8(r, length, s.Convert&ConvertDelta != 0)
+		default:
+			channels[ch], err = s.readPCM(r, length, is16Bit)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if numChannels == 1 {
+		s.data = channels[0]
+		return nil
+	}
+	s.data = make([]int16, length)
+	for j := range s.data {
+		s.data[j] = int16((int32(channels[0][j]) + int32(channels[1][j])) / 2)
+	}
+	return nil
+}
+
+// readPCM reads uncompressed sample data, honouring the sign, byte order and delta conversion flags.
+func (s *Sample) readPCM(r io.Reader, length int, is16Bit bool) ([]int16, error) {
+	bytesPerSample := 1
+	if is16Bit {
+		bytesPerSample = 2
+	}
+	raw := make([]byte, length*bytesPerSample)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, err
+	}
+
+	signed := s.Convert&ConvertSigned != 0
+	data := make([]int16, length)
+	var old int16
+	for j := range data {
+		var v int16
+		if is16Bit {
+			var u uint16
+			if s.Convert&ConvertBigEndian != 0 {
+				u = binary.BigEndian.Uint16(raw[j*2:])
+			} else {
+				u = binary.LittleEndian.Uint16(raw[j*2:])
+			}
+			if !signed {
+				u ^= 0x8000
+			}
+			v = int16(u)
+		} else {
+			u := raw[j]
+			if !signed {
+				u ^= 0x80
+			}
+			v = int16(int8(u))
+		}
+		if s.Convert&ConvertDelta != 0 {
+			if is16Bit {
+				v += old
+			} else {
+				v = int16(int8(v + old))
+			}
+			old = v
+		}
+		if is16Bit {
+			data[j] = v
+		} else {
+			data[j] = v << 8
+		}
+	}
+	return data, nil
+}
+
+func emptyRow(numChannels int) []Cell {
+	row := make([]Cell, numChannels)
+	for ch := range row {
+		row[ch].VolumePan = VolumeNone
+	}
+	return row
+}
+
+// parse unpacks a pattern and returns the number of channels referenced by its data.
+func (p *Pattern) parse(r io.Reader) (int, error) {
+	var header struct {
+		Length   uint16
+		Rows     uint16
+		Reserved [4]byte
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return 0, fmt.Errorf("reading pattern header: %w", err)
+	}
+	packed := make([]byte, header.Length)
+	if _, err := io.ReadFull(r, packed); err != nil {
+		return 0, fmt.Errorf("reading packed pattern data: %w", err)
+	}
+
+	p.Rows = make([][]Cell, header.Rows)
+	for row := range p.Rows {
+		p.Rows[row] = emptyRow(64)
+	}
+
+	var lastMask [64]byte
+	var last [64]Cell
+	i := 0
+	next := func() (byte, error) {
+		if i >= len(packed) {
+			return 0, errors.New("packed pattern data is truncated")
+		}
+		b := packed[i]
+		i++
+		return b, nil
+	}
+
+	numChannels := 0
+	row := 0
+	for row < int(header.Rows) && i < len(packed) {
+		channelVariable, _ := next()
+		if channelVariable == 0 {
+			row++
+			continue
+		}
+		ch := int(channelVariable-1) & 63
+		if channelVariable&0x80 != 0 {
+			mask, err := next()
+			if err != nil {
+				return 0, err
+			}
+			lastMask[ch] = mask
+		}
+		mask := lastMask[ch]
+		cell := &p.Rows[row][ch]
+
+		if mask&0x01 != 0 {
+			note, err := next()
+			if err != nil {
+				return 0, err
+			}
+			switch {
+			case note < 120:
+				note++
+			case note == 255:
+				note = NoteOff
+			case note == 254:
+				note = NoteCut
+			default:
+				note = NoteFade
+			}
+			last[ch].Note = note
+			cell.Note = note
+		}
+		if mask&0x02 != 0 {
+			instrument, err := next()
+			if err != nil {
+				return 0, err
+			}
+			last[ch].Instrument = instrument
+			cell.Instrument = instrument
+		}
+		if mask&0x04 != 0 {
+			volume, err := next()
+			if err != nil {
+				return 0, err
+			}
+			last[ch].VolumePan = volume
+			cell.VolumePan = volume
+		}
+		if mask&0x08 != 0 {
+			command, err := next()
+			if err != nil {
+				return 0, err
+			}
+			value, err := next()
+			if err != nil {
+				return 0, err
+			}
+			last[ch].Command = command
+			last[ch].CommandValue = value
+			cell.Command = command
+			cell.CommandValue = value
+		}
+		if mask&0x10 != 0 {
+			cell.Note = last[ch].Note
+		}
+		if mask&0x20 != 0 {
+			cell.Instrument = last[ch].Instrument
+		}
+		if mask&0x40 != 0 {
+			cell.VolumePan = last[ch].VolumePan
+		}
+		if mask&0x80 != 0 {
+			cell.Command = last[ch].Command
+			cell.CommandValue = last[ch].CommandValue
+		}
+		numChannels = max(numChannels, ch+1)
+	}
+	return numChannels, nil
+}
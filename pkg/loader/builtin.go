@@ -0,0 +1,39 @@
+package loader
+
+import (
+	"io"
+
+	"github.com/jesseward/impulse/pkg/it"
+	"github.com/jesseward/impulse/pkg/module"
+	"github.com/jesseward/impulse/pkg/protracker"
+	"github.com/jesseward/impulse/pkg/s3m"
+	"github.com/jesseward/impulse/pkg/xm"
+)
+
+// The built-in formats, which every program that imports the loader can load.
+func init() {
+	Register(Format{
+		Name:       "FastTracker II Extended Module",
+		Extensions: []string{"xm"},
+		Probe:      xm.Probe,
+		Open:       func(r io.ReadSeeker) (module.Module, error) { return xm.Read(r) },
+	})
+	Register(Format{
+		Name:       "Impulse Tracker",
+		Extensions: []string{"it"},
+		Probe:      it.Probe,
+		Open:       func(r io.ReadSeeker) (module.Module, error) { return it.Read(r) },
+	})
+	Register(Format{
+		Name:       "Scream Tracker 3",
+		Extensions: []string{"s3m"},
+		Probe:      s3m.Probe,
+		Open:       func(r io.ReadSeeker) (module.Module, error) { return s3m.Parse(r) },
+	})
+	Register(Format{
+		Name:       "Protracker",
+		Extensions: []string{"mod"},
+		Probe:      protracker.Probe,
+		Open:       func(r io.ReadSeeker) (module.Module, error) { return protracker.Read(r) },
+	})
+}
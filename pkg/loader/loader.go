@@ -5,13 +5,16 @@ import (
 	"errors"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/jesseward/impulse/pkg/module"
-	"github.com/jesseward/impulse/pkg/protracker"
-	"github.com/jesseward/impulse/pkg/s3m"
-	"github.com/jesseward/impulse/pkg/xm"
 )
 
+// The signatures of the formats of the original loader. The format packages
+// now detect their modules.
+//
+// Deprecated: Use the Probe functions of the format packages, or Formats.
 var (
 	MagicMK   = []byte{'M', '.', 'K', '.'}
 	MagicM4   = []byte{'M', '!', 'K', '!'}
@@ -24,44 +27,41 @@ var (
 	MagicXM   = []byte{'E', 'x', 't', 'e', 'n', 'd', 'e', 'd', ' ', 'M', 'o', 'd', 'u', 'l', 'e', ':', ' '}
 )
 
-// Load detects the file type of a music module and loads it.
+// Load detects the file type of a music module and loads it. The file
+// extension is used to break ties between equally confident formats.
 func Load(file *os.File) (module.Module, error) {
-	// Read the first 1084 bytes of the file, which should be enough to identify the file type.
-	buffer := make([]byte, 1084)
-	_, err := file.Read(buffer)
-	if err != nil && err != io.EOF {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(file.Name()), "."))
+	return load(file, ext)
+}
+
+// LoadBytes detects the file type of a music module held in memory and loads it.
+func LoadBytes(data []byte) (module.Module, error) {
+	return LoadReader(bytes.NewReader(data))
+}
+
+// LoadReader detects the file type of a music module and loads it. The module
+// is expected to start at offset 0 of r.
+func LoadReader(r io.ReadSeeker) (module.Module, error) {
+	return load(r, "")
+}
+
+func load(r io.ReadSeeker, ext string) (module.Module, error) {
+	// Read the start of the file, which should be enough to identify the file type.
+	buffer := make([]byte, ProbeSize)
+	n, err := io.ReadFull(r, buffer)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
 		return nil, err
 	}
 
-	// Reset the file pointer to the beginning of the file.
-	_, err = file.Seek(0, 0)
+	// Reset the reader to the beginning of the module.
+	_, err = r.Seek(0, io.SeekStart)
 	if err != nil {
 		return nil, err
 	}
 
-	// Check for XM magic at offset 0
-	if len(buffer) >= 17 {
-		if bytes.Equal(buffer[0:17], MagicXM) {
-			return xm.Read(file)
-		}
+	format, ok := detect(buffer[:n], ext)
+	if !ok {
+		return nil, errors.New("unknown file type")
 	}
-
-	// Check for S3M magic number at offset 44
-	if len(buffer) >= 48 {
-		if bytes.Equal(buffer[44:48], MagicSCRM) {
-			return s3m.Read(file)
-		}
-	}
-
-	// Check for MOD magic number at offset 1080
-	if len(buffer) >= 1084 {
-		if bytes.Equal(buffer[1080:1084], MagicMK) ||
-			bytes.Equal(buffer[1080:1084], MagicM4) ||
-			bytes.Equal(buffer[1080:1084], MagicFLT4) ||
-			bytes.Equal(buffer[1080:1084], Magic4CHN) {
-			return protracker.Read(file)
-		}
-	}
-
-	return nil, errors.New("unknown file type")
+	return format.Open(r)
 }
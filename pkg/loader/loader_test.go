@@ -1,8 +1,11 @@
-package loader
+package loader_test
 
 import (
 	"os"
+	"slices"
 	"testing"
+
+	"github.com/jesseward/impulse/pkg/loader"
 )
 
 func TestLoad_S3M(t *testing.T) {
@@ -12,7 +15,7 @@ func TestLoad_S3M(t *testing.T) {
 	}
 	defer file.Close()
 
-	module, err := Load(file)
+	module, err := loader.Load(file)
 	if err != nil {
 		t.Fatalf("Load() error = %v, wantErr nil", err)
 	}
@@ -24,3 +27,45 @@ func TestLoad_S3M(t *testing.T) {
 		t.Errorf("Load() module.Type() = %v, want S3M", module.Type())
 	}
 }
+
+func TestLoadBytes(t *testing.T) {
+	tests := []struct {
+		file     string
+		wantType string
+	}{
+		{"../../examples/acid_atmosphere_q-sou.s3m", "S3M"},
+		{"../../examples/space_debris.mod", "Protracker"},
+		{"../../examples/volume-envelope.xm", "FastTracker II Extended Module"},
+	}
+	for _, tt := range tests {
+		data, err := os.ReadFile(tt.file)
+		if err != nil {
+			t.Fatalf("Failed to read test file: %v", err)
+		}
+		module, err := loader.LoadBytes(data)
+		if err != nil {
+			t.Fatalf("LoadBytes(%s) error = %v, wantErr nil", tt.file, err)
+		}
+		if module.Type() != tt.wantType {
+			t.Errorf("LoadBytes(%s) module.Type() = %v, want %v", tt.file, module.Type(), tt.wantType)
+		}
+	}
+}
+
+func TestLoadBytes_Unknown(t *testing.T) {
+	if _, err := loader.LoadBytes([]byte("not a module")); err == nil {
+		t.Error("LoadBytes() error = nil, want error for unknown data")
+	}
+}
+
+func TestFormats_builtin(t *testing.T) {
+	// The built-in formats are registered without importing their packages.
+	var names []string
+	for _, f := range loader.Formats() {
+		names = append(names, f.Name)
+	}
+	want := []string{"FastTracker II Extended Module", "Impulse Tracker", "Scream Tracker 3", "Protracker"}
+	if !slices.Equal(names, want) {
+		t.Errorf("Formats() = %q, want %q", names, want)
+	}
+}
@@ -0,0 +1,91 @@
+package loader
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/jesseward/impulse/pkg/module"
+)
+
+// ProbeSize is the number of bytes from the start of a file that are passed to
+// Format.Probe.
+const ProbeSize = 1084
+
+// Format describes a module format that the loader can detect and open.
+type Format struct {
+	// Name is the human readable name of the format.
+	Name string
+	// Extensions lists the file extensions used by the format, without the dot.
+	Extensions []string
+	// Probe inspects up to ProbeSize bytes from the start of a file and returns
+	// a confidence score from 0 (not this format) to 100 (certain).
+	Probe func(header []byte) int
+	// Open parses a module of this format. r is positioned at the start of the file.
+	Open func(r io.ReadSeeker) (module.Module, error)
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   []Format
+)
+
+// Register makes a format available to Load, LoadReader and LoadBytes, in
+// addition to the built-in MOD, S3M, XM and IT formats. It panics if the format
+// has no Probe or Open function, or if a format with the same name is already
+// registered.
+func Register(f Format) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	if f.Probe == nil || f.Open == nil {
+		panic(fmt.Sprintf("loader: format %q registered without Probe or Open", f.Name))
+	}
+	for _, existing := range formats {
+		if existing.Name == f.Name {
+			panic(fmt.Sprintf("loader: format %q registered twice", f.Name))
+		}
+	}
+	formats = append(formats, f)
+}
+
+// unregister removes the format with name, for tests.
+func unregister(name string) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	formats = slices.DeleteFunc(formats, func(f Format) bool { return f.Name == name })
+}
+
+// Formats returns the registered formats in registration order.
+func Formats() []Format {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	return append([]Format(nil), formats...)
+}
+
+// detect returns the registered format with the highest confidence for
+// header. Ties are resolved in favour of a format using ext, and then in
+// registration order.
+func detect(header []byte, ext string) (Format, bool) {
+	var best Format
+	bestScore := 0
+	for _, f := range Formats() {
+		score := f.Probe(header) * 2
+		if score > 0 && hasExtension(f, ext) {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = f, score
+		}
+	}
+	return best, bestScore > 0
+}
+
+func hasExtension(f Format, ext string) bool {
+	for _, e := range f.Extensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package loader
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/jesseward/impulse/pkg/module"
+)
+
+func TestRegister(t *testing.T) {
+	before := len(Formats())
+	format := Format{
+		Name:       "Test Format",
+		Extensions: []string{"tst"},
+		Probe: func(header []byte) int {
+			if len(header) >= 4 && string(header[:4]) == "TEST" {
+				return 50
+			}
+			return 0
+		},
+		Open: func(r io.ReadSeeker) (module.Module, error) {
+			return nil, errors.New("test format opened")
+		},
+	}
+	Register(format)
+	t.Cleanup(func() { unregister(format.Name) })
+	if got := len(Formats()); got != before+1 {
+		t.Fatalf("len(Formats()) = %d, want %d", got, before+1)
+	}
+
+	_, err := LoadBytes([]byte("TEST data"))
+	if err == nil || err.Error() != "test format opened" {
+		t.Errorf("LoadBytes() error = %v, want the registered Open to be used", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Register() of a duplicate format did not panic")
+		}
+	}()
+	Register(format)
+}
+
+func TestUnregister(t *testing.T) {
+	before := len(Formats())
+	Register(Format{
+		Name:  "Test Format",
+		Probe: func([]byte) int { return 0 },
+		Open:  func(io.ReadSeeker) (module.Module, error) { return nil, nil },
+	})
+	unregister("Test Format")
+	if got := len(Formats()); got != before {
+		t.Errorf("len(Formats()) after unregister() = %d, want %d", got, before)
+	}
+}
@@ -1,22 +1,145 @@
 package protracker
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
 )
 
-// Read reads and parses a MOD file from the given reader.
+// Probe returns a confidence score from 0 to 100 that header, the start of a
+// file, is a Protracker module. The format tag is stored at offset 1080.
+func Probe(header []byte) int {
+	if len(header) < 1084 {
+		return 0
+	}
+	if _, ok := ChannelsForTag(string(header[1080:1084])); ok {
+		return 100
+	}
+	return probeSoundtracker(header)
+}
+
+// probeSoundtracker returns a confidence score for a 15 sample Soundtracker
+// module. These have no signature, so the header fields and the start of the
+// first pattern are checked for values that the original trackers could save.
+func probeSoundtracker(header []byte) int {
+	if len(header) < soundtrackerHeaderSize+4 {
+		return 0
+	}
+	if !validName(header[:20]) {
+		return 0
+	}
+	totalLength := 0
+	for i := 0; i < 15; i++ {
+		s := header[20+i*30 : 50+i*30]
+		length := int(binary.BigEndian.Uint16(s[22:24]))
+		if !validName(s[:22]) || s[24] != 0 || s[25] > 64 || length > 32768 {
+			return 0
+		}
+		totalLength += length
+	}
+	if totalLength == 0 {
+		return 0
+	}
+	if songLength := header[470]; songLength == 0 || songLength > 128 {
+		return 0
+	}
+	for _, order := range header[472:600] {
+		if order > 63 {
+			return 0
+		}
+	}
+	for off := soundtrackerHeaderSize; off+4 <= len(header); off += 4 {
+		sample := header[off]&0xF0 | header[off+2]>>4
+		period := uint16(header[off]&0x0F)<<8 | uint16(header[off+1])
+		if sample > 15 || (period != 0 && (period < 108 || period > 907)) {
+			return 0
+		}
+	}
+	return 40
+}
+
+// validName reports whether name is printable text padded with zero bytes.
+func validName(name []byte) bool {
+	for _, b := range name {
+		if b == 0 {
+			return true
+		}
+		if b < 32 || b == 127 {
+			return false
+		}
+	}
+	return true
+}
+
+// ChannelsForTag returns the number of channels used by a module with the
+// given format tag, and false if the tag is not a known MOD signature.
+//
+// Besides the 4 channel Protracker/Noisetracker tags this covers the
+// FastTracker "nCHN" and "nnCH" tags (2-32 channels), TakeTracker "nnCN" and
+// "TDZn", Startrekker "FLT4"/"FLT8", Oktalyzer "OKTA"/"OCTA" and Falcon
+// "CD61"/"CD81".
+func ChannelsForTag(tag string) (int, bool) {
+	if len(tag) != 4 {
+		return 0, false
+	}
+	switch tag {
+	case "M.K.", "M!K!", "M&K!", "N.T.", "FLT4", "NSMS", "LARD":
+		return 4, true
+	case "FLT8", "OKTA", "OCTA", "CD81":
+		return 8, true
+	case "CD61":
+		return 6, true
+	}
+	isDigit := func(b byte) bool { return b >= '0' && b <= '9' }
+	switch {
+	case isDigit(tag[0]) && tag[1:] == "CHN":
+		if n := int(tag[0] - '0'); n >= 2 {
+			return n, true
+		}
+	case isDigit(tag[0]) && isDigit(tag[1]) && (tag[2:] == "CH" || tag[2:] == "CN"):
+		if n := int(tag[0]-'0')*10 + int(tag[1]-'0'); n >= 2 && n <= 32 {
+			return n, true
+		}
+	case tag[:3] == "TDZ" && isDigit(tag[3]):
+		if n := int(tag[3] - '0'); n >= 2 {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// soundtrackerHeaderSize is the size of the 15 sample module header, which
+// lacks the 16 extra sample slots and the format tag.
+const soundtrackerHeaderSize = 600
+
+// Read reads and parses a MOD file from the given reader. Modules without a
+// known format tag that look like 15 sample Soundtracker modules are read
+// using the Soundtracker layout.
 func Read(r io.Reader) (*ModFile, error) {
-	m := &ModFile{}
+	header := make([]byte, 1084)
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	header = header[:n]
+	r = io.MultiReader(bytes.NewReader(header), r)
+
+	m := &ModFile{numSamples: 31}
+	if n < 1084 || Probe(header) < 100 {
+		if probeSoundtracker(header) > 0 {
+			m.numSamples = 15
+			m.soundtracker = true
+		}
+	}
 
 	// Read the module name
 	if _, err := io.ReadFull(r, m.songName[:]); err != nil {
 		return nil, err
 	}
 
-	// Read the 31 samples
-	for i := range 31 {
+	// Read the sample headers
+	for i := range m.numSamples {
 		var sampleBytes [30]byte
 		if _, err := io.ReadFull(r, sampleBytes[:]); err != nil {
 			return nil, err
@@ -28,9 +151,17 @@ func Read(r io.Reader) (*ModFile, error) {
 			finetune -= 16
 		}
 		m.samples[i].finetune = finetune
-		m.samples[i].volume = min(sampleBytes[25], 64)
+		m.samples[i].finetuneHigh = sampleBytes[24] & 0xF0
+		m.samples[i].volume = sampleBytes[25]
 		m.samples[i].loopStart = uint32(binary.BigEndian.Uint16(sampleBytes[26:28])) * 2
 		m.samples[i].loopLength = uint32(binary.BigEndian.Uint16(sampleBytes[28:30])) * 2
+
+		// Ultimate Soundtracker stores the loop start in bytes rather than words.
+		s := &m.samples[i]
+		if m.soundtracker && s.loopStart+s.loopLength > s.length && s.loopStart/2+s.loopLength <= s.length {
+			s.loopStart /= 2
+			s.loopStartInBytes = true
+		}
 	}
 
 	// Read song length and unused byte
@@ -47,17 +178,24 @@ func Read(r io.Reader) (*ModFile, error) {
 	}
 
 	// Read magic ID and determine number of channels
-	if _, err := io.ReadFull(r, m.MagicID[:]); err != nil {
-		return nil, err
-	}
 	m.numChannels = 4 // Default
-	switch string(m.MagicID[:]) {
-	case "6CHN":
-		m.numChannels = 6
-	case "8CHN", "OCTA":
-		m.numChannels = 8
-	case "FLT8":
-		m.numChannels = 8
+	if !m.soundtracker {
+		if _, err := io.ReadFull(r, m.MagicID[:]); err != nil {
+			return nil, err
+		}
+	}
+	if n, ok := ChannelsForTag(string(m.MagicID[:])); ok {
+		m.numChannels = n
+	}
+
+	// Startrekker FLT8 modules store each 8 channel pattern as two consecutive
+	// 4 channel patterns, and the order list refers to the first of each pair.
+	isFLT8 := string(m.MagicID[:]) == "FLT8"
+	if isFLT8 {
+		for i := range m.patternOrder {
+			m.orderHalves[i] = m.patternOrder[i] % 2
+			m.patternOrder[i] /= 2
+		}
 	}
 
 	// Determine the number of patterns
@@ -73,21 +211,41 @@ func Read(r io.Reader) (*ModFile, error) {
 	m.Patterns = make([][]ChannelSequence, numPatterns)
 	for i := 0; i < numPatterns; i++ {
 		m.Patterns[i] = make([]ChannelSequence, 64*m.numChannels)
+		if isFLT8 {
+			for half := 0; half < 2; half++ {
+				for row := 0; row < 64; row++ {
+					for ch := 0; ch < 4; ch++ {
+						if err := readCell(r, &m.Patterns[i][row*8+half*4+ch]); err != nil {
+							return nil, err
+						}
+					}
+				}
+			}
+			continue
+		}
 		for j := 0; j < 64*m.numChannels; j++ {
-			var cellBytes [4]byte
-			if _, err := io.ReadFull(r, cellBytes[:]); err != nil {
+			if err := readCell(r, &m.Patterns[i][j]); err != nil {
 				return nil, err
 			}
-			m.Patterns[i][j].SampleNumber = (cellBytes[0] & 0xF0) | (cellBytes[2] >> 4)
-			m.Patterns[i][j].Period = (uint16(cellBytes[0]&0x0F) << 8) | uint16(cellBytes[1])
-			m.Patterns[i][j].Effect.Command = cellBytes[2] & 0x0F
-			m.Patterns[i][j].Effect.X = (cellBytes[3] & 0xF0) >> 4
-			m.Patterns[i][j].Effect.Y = cellBytes[3] & 0x0F
+		}
+	}
+
+	// Ultimate Soundtracker only knows arpeggio (1) and pitch bend (2), while
+	// the later Soundtrackers use the Protracker effect numbering.
+	if m.soundtracker {
+		m.ultimate = true
+		for _, pattern := range m.Patterns {
+			for _, cell := range pattern {
+				command, param := cell.Effect.Command, cell.Effect.X<<4|cell.Effect.Y
+				if command > 2 || (command == 0 && param != 0) {
+					m.ultimate = false
+				}
+			}
 		}
 	}
 
 	// Read sample data
-	for i, s := range m.samples {
+	for i, s := range m.samples[:m.numSamples] {
 		if s.length > 0 {
 			sampleData := make([]byte, s.length)
 			if _, err := io.ReadFull(r, sampleData); err != nil {
@@ -100,5 +258,28 @@ func Read(r io.Reader) (*ModFile, error) {
 		}
 	}
 
+	// Keep anything stored after the sample data so that Write can reproduce the file.
+	trailer, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(trailer) > 0 {
+		m.trailer = trailer
+	}
+
 	return m, nil
 }
+
+// readCell reads a single 4 byte pattern cell.
+func readCell(r io.Reader, cell *ChannelSequence) error {
+	var cellBytes [4]byte
+	if _, err := io.ReadFull(r, cellBytes[:]); err != nil {
+		return err
+	}
+	cell.SampleNumber = (cellBytes[0] & 0xF0) | (cellBytes[2] >> 4)
+	cell.Period = (uint16(cellBytes[0]&0x0F) << 8) | uint16(cellBytes[1])
+	cell.Effect.Command = cellBytes[2] & 0x0F
+	cell.Effect.X = (cellBytes[3] & 0xF0) >> 4
+	cell.Effect.Y = cellBytes[3] & 0x0F
+	return nil
+}
@@ -15,13 +15,15 @@ var PeriodToNote = map[uint16]string{
 
 // Sample represents the metadata for a single sample in the MOD file.
 type Sample struct {
-	name       [22]byte
-	length     uint32
-	finetune   int8
-	volume     uint8
-	loopStart  uint32
-	loopLength uint32
-	data       []int16
+	name             [22]byte
+	length           uint32
+	finetune         int8
+	finetuneHigh     byte // unused upper nibble of the finetune byte
+	volume           uint8
+	loopStart        uint32
+	loopLength       uint32
+	loopStartInBytes bool // Ultimate Soundtracker loop start
+	data             []int16
 }
 
 // ChannelAudio represents a single cell in a pattern.
@@ -38,9 +40,16 @@ type ModFile struct {
 	songLength   uint8
 	Unused       uint8
 	patternOrder [128]uint8
+	// orderHalves holds the low bit of each entry of an FLT8 order list, which
+	// numbers 4 channel patterns, for Write to restore.
+	orderHalves  [128]uint8
 	MagicID      [4]byte
 	Patterns     [][]ChannelSequence
 	numChannels  int
+	numSamples   int
+	soundtracker bool
+	ultimate     bool
+	trailer      []byte // data following the samples
 }
 
 func (m *ModFile) PatternCell(pattern, row, channel int) module.Cell {
@@ -101,8 +110,8 @@ func (m *ModFile) NoteToString(note byte) string {
 }
 
 func (m *ModFile) Samples() []module.Sample {
-	samples := make([]module.Sample, 0, len(m.samples))
-	for i := range m.samples {
+	samples := make([]module.Sample, 0, m.numSamples)
+	for i := range m.samples[:m.numSamples] {
 		samples = append(samples, &m.samples[i])
 	}
 	return samples
@@ -112,10 +121,28 @@ func (m *ModFile) DefaultSpeed() int {
 	return 6
 }
 
+// DefaultBPM returns the initial tempo. Soundtracker modules store a timer
+// value in the byte that later became the restart position, where 120 means
+// the default tempo.
 func (m *ModFile) DefaultBPM() int {
+	if m.soundtracker && m.Unused != 0 && m.Unused != 120 && m.Unused < 240 {
+		return int(709379.0 * 125.0 / 50.0 / (float64(240-int(m.Unused)) * 122.0))
+	}
 	return 125
 }
 
+// IsSoundtracker reports whether the module uses the 15 sample Soundtracker layout.
+func (m *ModFile) IsSoundtracker() bool {
+	return m.soundtracker
+}
+
+// IsUltimateSoundtracker reports whether the module is a Soundtracker module
+// that uses the Ultimate Soundtracker effects, where effect 1 is arpeggio and
+// effect 2 is pitch bend.
+func (m *ModFile) IsUltimateSoundtracker() bool {
+	return m.ultimate
+}
+
 func (s *Sample) Data() []int16 {
 	return s.data
 }
@@ -132,7 +159,7 @@ func (s *Sample) Length() uint32 {
 
 // GetVolume returns the volume of the sample.
 func (s *Sample) Volume() uint8 {
-	return s.volume
+	return min(s.volume, 64)
 }
 
 // GetLoopStart returns the loop start of the sample.
@@ -2,6 +2,8 @@ package protracker
 
 import (
 	"bytes"
+	"encoding/binary"
+	"os"
 	"reflect"
 	"testing"
 
@@ -191,3 +193,189 @@ func TestEffectString(t *testing.T) {
 		})
 	}
 }
+
+// buildMultiChannelMod returns a module with the given tag, no samples and a
+// single pattern (or pattern pair for FLT8) where every cell stores its
+// channel number as the sample number.
+func buildMultiChannelMod(tag string, numChannels int, flt8 bool) []byte {
+	data := make([]byte, 1080) // Name, sample headers and an order list of pattern 0
+	data[950] = 1              // Song length
+	data = append(data, []byte(tag)...)
+
+	if flt8 {
+		for half := 0; half < 2; half++ {
+			for row := 0; row < 64; row++ {
+				for ch := 0; ch < 4; ch++ {
+					data = append(data, 0, 0, byte(half*4+ch+1)<<4, 0)
+				}
+			}
+		}
+		return data
+	}
+	for row := 0; row < 64; row++ {
+		for ch := 0; ch < numChannels; ch++ {
+			data = append(data, byte(ch+1)&0xF0, 0, byte(ch+1)<<4, 0)
+		}
+	}
+	return data
+}
+
+func TestRead_MultiChannel(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want int
+		flt8 bool
+	}{
+		{"6CHN", 6, false},
+		{"8CHN", 8, false},
+		{"12CH", 12, false},
+		{"32CH", 32, false},
+		{"16CN", 16, false},
+		{"TDZ3", 3, false},
+		{"CD81", 8, false},
+		{"OKTA", 8, false},
+		{"FLT8", 8, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			data := buildMultiChannelMod(tt.tag, tt.want, tt.flt8)
+			if Probe(data) != 100 {
+				t.Errorf("Probe() = %d, want 100", Probe(data))
+			}
+			mod, err := Read(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("Read() returned an unexpected error: %v", err)
+			}
+			if mod.NumChannels() != tt.want {
+				t.Fatalf("Expected %d channels, got %d", tt.want, mod.NumChannels())
+			}
+			for ch := 0; ch < tt.want; ch++ {
+				if got := mod.PatternCell(0, 63, ch).SampleNumber; int(got) != ch+1 {
+					t.Errorf("channel %d: expected sample number %d, got %d", ch, ch+1, got)
+				}
+			}
+		})
+	}
+}
+
+func TestChannelsForTag(t *testing.T) {
+	for _, tag := range []string{"0CHN", "1CHN", "01CH", "01CN", "TDZ1", "33CH", "XXXX", "TDZ0", "M.K"} {
+		if n, ok := ChannelsForTag(tag); ok {
+			t.Errorf("ChannelsForTag(%q) = %d, true, want false", tag, n)
+		}
+	}
+}
+
+func TestRead_Soundtracker(t *testing.T) {
+	data := make([]byte, soundtrackerHeaderSize)
+	copy(data, "OLD SONG")
+	// Sample 1: 8 words, loop start 10 bytes, loop length 2 words.
+	copy(data[20:], "SAMPLE")
+	binary.BigEndian.PutUint16(data[42:], 8)
+	data[45] = 64
+	binary.BigEndian.PutUint16(data[46:], 10)
+	binary.BigEndian.PutUint16(data[48:], 2)
+	data[470] = 1   // Song length
+	data[471] = 180 // Tempo
+	pattern := make([]byte, 1024)
+	pattern[0], pattern[1], pattern[2], pattern[3] = 0x01, 0xAC, 0x12, 0x20 // C-2, sample 1, pitch bend up
+	data = append(data, pattern...)
+	data = append(data, make([]byte, 16)...)
+
+	if got := Probe(data[:1084]); got == 0 || got >= 100 {
+		t.Errorf("Probe() = %d, want a heuristic score", got)
+	}
+	mod, err := Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Read() returned an unexpected error: %v", err)
+	}
+	if !mod.IsSoundtracker() || !mod.IsUltimateSoundtracker() {
+		t.Errorf("Expected an Ultimate Soundtracker module")
+	}
+	if len(mod.Samples()) != 15 {
+		t.Errorf("Expected 15 samples, got %d", len(mod.Samples()))
+	}
+	if s := mod.Samples()[0]; s.LoopStart() != 10 || s.LoopLength() != 4 {
+		t.Errorf("Expected loop 10+4, got %d+%d", s.LoopStart(), s.LoopLength())
+	}
+	if bpm := mod.DefaultBPM(); bpm != 242 {
+		t.Errorf("Expected tempo 242, got %d", bpm)
+	}
+	if cell := mod.PatternCell(0, 0, 0); cell.Period != 428 || cell.SampleNumber != 1 || cell.Effect != 2 {
+		t.Errorf("Unexpected first cell %+v", cell)
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, mod); err != nil {
+		t.Fatalf("Write() returned an unexpected error: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("Write() output differs from the original module")
+	}
+}
+
+func TestProbe_NotSoundtracker(t *testing.T) {
+	data := make([]byte, 1084)
+	copy(data, "\x01\x02binary junk")
+	if got := Probe(data); got != 0 {
+		t.Errorf("Probe() = %d, want 0", got)
+	}
+}
+
+func TestWrite_RoundTrip(t *testing.T) {
+	for _, file := range []string{"../../examples/space_debris.mod", "../../examples/breakbeat_science.mod"} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Failed to read test file: %v", err)
+		}
+		mod, err := Read(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Read(%s) returned an unexpected error: %v", file, err)
+		}
+		var buf bytes.Buffer
+		if err := Write(&buf, mod); err != nil {
+			t.Fatalf("Write(%s) returned an unexpected error: %v", file, err)
+		}
+		if !bytes.Equal(buf.Bytes(), data) {
+			t.Errorf("Write(%s) output differs from the original file (%d bytes, want %d)", file, buf.Len(), len(data))
+		}
+	}
+}
+
+func TestWrite_MultiChannel(t *testing.T) {
+	for _, tag := range []string{"8CHN", "FLT8"} {
+		data := buildMultiChannelMod(tag, 8, tag == "FLT8")
+		mod, err := Read(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Read(%s) returned an unexpected error: %v", tag, err)
+		}
+		var buf bytes.Buffer
+		if err := Write(&buf, mod); err != nil {
+			t.Fatalf("Write(%s) returned an unexpected error: %v", tag, err)
+		}
+		if !bytes.Equal(buf.Bytes(), data) {
+			t.Errorf("Write(%s) output differs from the original module", tag)
+		}
+	}
+}
+
+func TestWrite_FLT8OddOrder(t *testing.T) {
+	// An odd order entry refers to the second half of a pattern pair, which
+	// plays as the whole pair.
+	data := buildMultiChannelMod("FLT8", 8, true)
+	data[952] = 1
+	mod, err := Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Read() returned an unexpected error: %v", err)
+	}
+	if got := mod.PatternOrder()[0]; got != 0 {
+		t.Errorf("PatternOrder()[0] = %d, want 0", got)
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, mod); err != nil {
+		t.Fatalf("Write() returned an unexpected error: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("Write() output differs from the original module")
+	}
+}
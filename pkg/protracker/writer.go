@@ -0,0 +1,171 @@
+package protracker
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Write serializes a MOD file to w. A module returned by Read is written back
+// byte for byte, as long as it has not been modified.
+func Write(w io.Writer, m *ModFile) error {
+	bw := bufio.NewWriter(w)
+
+	bw.Write(m.songName[:])
+	numSamples := m.numSamples
+	if numSamples == 0 {
+		numSamples = len(m.samples)
+	}
+	for i := range m.samples[:numSamples] {
+		s := &m.samples[i]
+		var sampleBytes [30]byte
+		copy(sampleBytes[0:22], s.name[:])
+		loopStart := s.loopStart / 2
+		if s.loopStartInBytes {
+			loopStart = s.loopStart
+		}
+		binary.BigEndian.PutUint16(sampleBytes[22:24], uint16(s.length/2))
+		sampleBytes[24] = byte(s.finetune)&0x0F | s.finetuneHigh
+		sampleBytes[25] = s.volume
+		binary.BigEndian.PutUint16(sampleBytes[26:28], uint16(loopStart))
+		binary.BigEndian.PutUint16(sampleBytes[28:30], uint16(s.loopLength/2))
+		bw.Write(sampleBytes[:])
+	}
+
+	bw.WriteByte(m.songLength)
+	bw.WriteByte(m.Unused)
+	isFLT8 := !m.soundtracker && string(m.MagicID[:]) == "FLT8"
+	for i, patternIndex := range m.patternOrder {
+		if isFLT8 {
+			patternIndex = patternIndex*2 | m.orderHalves[i]
+		}
+		bw.WriteByte(patternIndex)
+	}
+	if !m.soundtracker {
+		bw.Write(m.MagicID[:])
+	}
+
+	for i, pattern := range m.Patterns {
+		if len(pattern) != 64*m.numChannels {
+			return fmt.Errorf("pattern %d has %d cells, want %d", i, len(pattern), 64*m.numChannels)
+		}
+		if isFLT8 {
+			for half := 0; half < 2; half++ {
+				for row := 0; row < 64; row++ {
+					for ch := 0; ch < 4; ch++ {
+						writeCell(bw, &pattern[row*8+half*4+ch])
+					}
+				}
+			}
+			continue
+		}
+		for j := range pattern {
+			writeCell(bw, &pattern[j])
+		}
+	}
+
+	for i := range m.samples[:numSamples] {
+		for _, v := range m.samples[i].data {
+			bw.WriteByte(byte(v >> 8))
+		}
+	}
+	bw.Write(m.trailer)
+
+	return bw.Flush()
+}
+
+// writeCell writes a single 4 byte pattern cell.
+func writeCell(w *bufio.Writer, cell *ChannelSequence) {
+	w.Write([]byte{
+		cell.SampleNumber&0xF0 | byte(cell.Period>>8)&0x0F,
+		byte(cell.Period),
+		cell.SampleNumber<<4 | cell.Effect.Command&0x0F,
+		cell.Effect.X<<4 | cell.Effect.Y&0x0F,
+	})
+}
+
+// NewSample returns a sample holding data, which uses the 16-bit scale of
+// Sample.Data and is stored with 8 bits. Lengths are in samples and are
+// rounded down to the even values the format can store, except for the
+// sample length which is padded with silence.
+func NewSample(name string, data []int16, volume uint8, finetune int8, loopStart, loopLength uint32) Sample {
+	s := Sample{
+		finetune:   max(-8, min(7, finetune)),
+		volume:     min(volume, 64),
+		loopStart:  loopStart &^ 1,
+		loopLength: loopLength &^ 1,
+	}
+	copy(s.name[:], name)
+	s.data = make([]int16, len(data)+len(data)%2)
+	for i, v := range data {
+		s.data[i] = v &^ 0xFF
+	}
+	s.length = uint32(len(s.data))
+	return s
+}
+
+// New returns a module using the given samples, order list and patterns. Each
+// pattern holds 64 rows of numChannels cells. The format tag is chosen from
+// the number of channels and patterns.
+func New(name string, numChannels int, samples []Sample, order []byte, patterns [][]ChannelSequence) (*ModFile, error) {
+	var tag string
+	switch {
+	case numChannels == 4 && len(patterns) > 64:
+		tag = "M!K!"
+	case numChannels == 4:
+		tag = "M.K."
+	case numChannels >= 2 && numChannels <= 9:
+		tag = fmt.Sprintf("%dCHN", numChannels)
+	case numChannels >= 10 && numChannels <= 32:
+		tag = fmt.Sprintf("%dCH", numChannels)
+	default:
+		return nil, fmt.Errorf("unsupported number of channels: %d", numChannels)
+	}
+	if len(samples) > 31 {
+		return nil, fmt.Errorf("too many samples: %d", len(samples))
+	}
+	if len(order) == 0 || len(order) > 128 {
+		return nil, fmt.Errorf("unsupported song length: %d", len(order))
+	}
+	if len(patterns) > 128 {
+		return nil, fmt.Errorf("too many patterns: %d", len(patterns))
+	}
+	for i, p := range patterns {
+		if len(p) != 64*numChannels {
+			return nil, fmt.Errorf("pattern %d has %d cells, want %d", i, len(p), 64*numChannels)
+		}
+	}
+	for _, o := range order {
+		if int(o) >= len(patterns) {
+			return nil, fmt.Errorf("order list refers to missing pattern %d", o)
+		}
+	}
+
+	m := &ModFile{
+		songLength:  uint8(len(order)),
+		Unused:      127,
+		Patterns:    patterns,
+		numChannels: numChannels,
+		numSamples:  31,
+	}
+	copy(m.songName[:], name)
+	copy(m.samples[:], samples)
+	copy(m.patternOrder[:], order)
+	copy(m.MagicID[:], tag)
+
+	// The number of patterns in the file is taken from the highest entry of
+	// the whole order table, so patterns past the highest one that is played
+	// are referenced after the end of the song.
+	highest := 0
+	for _, o := range order {
+		highest = max(highest, int(o))
+	}
+	if highest < len(patterns)-1 {
+		if len(order) == 128 {
+			return nil, fmt.Errorf("pattern %d is not in the order list", len(patterns)-1)
+		}
+		m.patternOrder[len(order)] = uint8(len(patterns) - 1)
+	}
+	return m, nil
+}
@@ -156,6 +156,9 @@ type PatternEntry struct {
 	Effect     module.Effect
 }
 
+// EmptyEntry is a pattern entry without note, instrument, volume or effect.
+var EmptyEntry = PatternEntry{Note: 255, Volume: 255}
+
 // Pattern represents a pattern with 64 rows.
 type Pattern [][]PatternEntry
 
@@ -239,6 +242,15 @@ func (s *S3M) DefaultBPM() int {
 	return int(s.Header.InitialTempo)
 }
 
+// Probe returns a confidence score from 0 to 100 that header, the start of a
+// file, is an S3M module. The SCRM signature is stored at offset 44.
+func Probe(header []byte) int {
+	if len(header) >= 48 && string(header[44:48]) == "SCRM" {
+		return 100
+	}
+	return 0
+}
+
 // Parse reads an S3M file from an io.Reader and returns a parsed S3M struct.
 func Parse(r io.Reader) (*S3M, error) {
 	var s3m S3M
@@ -352,10 +364,7 @@ func Parse(r io.Reader) (*S3M, error) {
 	s3m.Patterns = make([]Pattern, s3m.Header.PatternCount)
 	for i := 0; i < int(s3m.Header.PatternCount); i++ {
 		if s3m.PatternParapointers[i] == 0 {
-			s3m.Patterns[i] = make(Pattern, 64)
-			for row := 0; row < 64; row++ {
-				s3m.Patterns[i][row] = make([]PatternEntry, s3m.numChannels)
-			}
+			s3m.Patterns[i] = newPattern(s3m.numChannels)
 			continue // Skip empty patterns
 		}
 		offset := int64(s3m.PatternParapointers[i]) * 16
@@ -375,10 +384,7 @@ func Parse(r io.Reader) (*S3M, error) {
 
 		patternReader := bytes.NewReader(patternData)
 
-		s3m.Patterns[i] = make(Pattern, 64)
-		for row := 0; row < 64; row++ {
-			s3m.Patterns[i][row] = make([]PatternEntry, s3m.numChannels)
-		}
+		s3m.Patterns[i] = newPattern(s3m.numChannels)
 
 		row := 0
 		for row < 64 {
@@ -397,8 +403,7 @@ func Parse(r io.Reader) (*S3M, error) {
 
 			channel := int(what & 31)
 
-			var entry PatternEntry
-			entry.Volume = 255
+			entry := EmptyEntry
 
 			if what&32 != 0 {
 				note, _ := patternReader.ReadByte()
@@ -474,6 +479,18 @@ func Parse(r io.Reader) (*S3M, error) {
 	return &s3m, nil
 }
 
+// newPattern returns a pattern of 64 rows of empty entries.
+func newPattern(numChannels int) Pattern {
+	p := make(Pattern, 64)
+	for row := range p {
+		p[row] = make([]PatternEntry, numChannels)
+		for ch := range p[row] {
+			p[row][ch] = EmptyEntry
+		}
+	}
+	return p
+}
+
 // PatternCell returns a generic representation of a pattern cell.
 func (s *S3M) PatternCell(pattern, row, channel int) module.Cell {
 	if pattern >= len(s.Patterns) || row >= 64 || channel >= s.numChannels {
@@ -494,7 +511,12 @@ func (s *S3M) PatternCell(pattern, row, channel int) module.Cell {
 	}
 }
 
-// Read parses an S3M file from an *os.File and returns a module.Module.
+// Read parses an S3M file from an *os.File and returns a module.Module. Parse
+// reads from any io.Reader.
 func Read(file *os.File) (module.Module, error) {
-	return Parse(file)
+	s, err := Parse(file)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
 }
@@ -1,6 +1,7 @@
 package s3m
 
 import (
+	"bytes"
 	"os"
 	"path/filepath"
 	"strings"
@@ -47,6 +48,21 @@ func TestParse(t *testing.T) {
 	}
 }
 
+func TestRead(t *testing.T) {
+	file, err := os.Open(filepath.Join("..", "..", "examples", "acid_atmosphere_q-sou.s3m"))
+	if err != nil {
+		t.Fatalf("failed to open test file: %v", err)
+	}
+	defer file.Close()
+	m, err := Read(file)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if m.Type() != "S3M" {
+		t.Errorf("Read() module.Type() = %q, want S3M", m.Type())
+	}
+}
+
 func TestS3M_ImplementsModule(t *testing.T) {
 	var _ module.Module = (*S3M)(nil)
 }
@@ -97,3 +113,26 @@ func TestNoteToString(t *testing.T) {
 		})
 	}
 }
+
+func TestWrite_RoundTrip(t *testing.T) {
+	data, err := os.ReadFile("../../examples/acid_atmosphere_q-sou.s3m")
+	if err != nil {
+		t.Fatalf("Failed to read test file: %v", err)
+	}
+	s3m, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Parse() returned an unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, s3m); err != nil {
+		t.Fatalf("Write() returned an unexpected error: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		for i := range min(buf.Len(), len(data)) {
+			if buf.Bytes()[i] != data[i] {
+				t.Fatalf("Write() output differs from the original file at offset %d (%d bytes, want %d)", i, buf.Len(), len(data))
+			}
+		}
+		t.Fatalf("Write() output is %d bytes, want %d", buf.Len(), len(data))
+	}
+}
@@ -0,0 +1,331 @@
+package s3m
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// paddingByte fills the gaps between the 16 byte aligned blocks of the file,
+// as Scream Tracker 3 does.
+const paddingByte = 0x80
+
+// Write serializes an S3M module to w. The file is laid out the way Scream
+// Tracker 3 saves it: header and tables, instrument headers, patterns and
+// then sample data, each block aligned to a 16 byte paragraph. The
+// parapointers stored in s are ignored and recomputed, so a module returned by
+// Parse is written back byte for byte as long as it has not been modified.
+func Write(w io.Writer, s *S3M) error {
+	if len(s.Instruments) > 0xFFFF || len(s.Patterns) > 0xFFFF || len(s.Orders) > 0xFFFF {
+		return fmt.Errorf("too many orders, instruments or patterns")
+	}
+
+	header := s.Header
+	header.OrderCount = uint16(len(s.Orders))
+	header.InstrumentCount = uint16(len(s.Instruments))
+	header.PatternCount = uint16(len(s.Patterns))
+
+	packedPatterns := make([][]byte, len(s.Patterns))
+	for i, pattern := range s.Patterns {
+		if i < len(s.PatternParapointers) && s.PatternParapointers[i] == 0 && pattern.isEmpty() {
+			continue
+		}
+		packedPatterns[i] = s.packPattern(pattern)
+	}
+
+	// Lay out the file.
+	offset := 96 + len(s.Orders) + 2*len(s.Instruments) + 2*len(s.Patterns)
+	if header.DefaultPan == 252 {
+		offset += 32
+	}
+	offset = align(offset)
+
+	instrumentPointers := make([]uint16, len(s.Instruments))
+	for i := range s.Instruments {
+		if i < len(s.InstrumentParapointers) && s.InstrumentParapointers[i] == 0 {
+			continue
+		}
+		instrumentPointers[i] = uint16(offset / 16)
+		offset = align(offset + 80)
+	}
+
+	patternPointers := make([]uint16, len(s.Patterns))
+	for i, packed := range packedPatterns {
+		if packed == nil {
+			continue
+		}
+		patternPointers[i] = uint16(offset / 16)
+		offset = align(offset + len(packed))
+	}
+
+	samples := make([][]byte, len(s.Instruments))
+	samplePointers := make([]int, len(s.Instruments))
+	for i := range s.Instruments {
+		inst := &s.Instruments[i]
+		if instrumentPointers[i] == 0 || inst.Type != 1 || inst.length == 0 {
+			continue
+		}
+		samples[i] = s.encodeSample(inst)
+		samplePointers[i] = offset / 16
+		offset = align(offset + len(samples[i]))
+	}
+	if offset/16 > 0xFFFFFF {
+		return fmt.Errorf("module is too large")
+	}
+
+	// Write the blocks in the same order they were laid out.
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, &header)
+	buf.Write(s.Orders)
+	binary.Write(&buf, binary.LittleEndian, instrumentPointers)
+	binary.Write(&buf, binary.LittleEndian, patternPointers)
+	if header.DefaultPan == 252 {
+		var pan [32]byte
+		copy(pan[:], s.DefaultPanPositions)
+		buf.Write(pan[:])
+	}
+	pad(&buf)
+
+	for i := range s.Instruments {
+		if instrumentPointers[i] == 0 {
+			continue
+		}
+		inst := &s.Instruments[i]
+		memSeg := inst.MemSeg
+		if samples[i] != nil {
+			memSeg = [3]byte{byte(samplePointers[i] >> 16), byte(samplePointers[i]), byte(samplePointers[i] >> 8)}
+		}
+		binary.Write(&buf, binary.LittleEndian, &instrumentHeader{
+			Type:        inst.Type,
+			DOSFilename: inst.DOSFilename,
+			MemSeg:      memSeg,
+			Length:      inst.length,
+			LoopBegin:   inst.LoopBegin,
+			LoopEnd:     inst.loopEnd,
+			Volume:      inst.volume,
+			Reserved:    inst.Reserved,
+			Pack:        inst.Pack,
+			Flags:       inst.flags,
+			C2Spd:       inst.C2Spd,
+			Reserved2:   inst.Reserved2,
+			SampleName:  inst.SampleName,
+			Signature:   inst.Signature,
+		})
+		pad(&buf)
+	}
+
+	for _, packed := range packedPatterns {
+		if packed == nil {
+			continue
+		}
+		buf.Write(packed)
+		pad(&buf)
+	}
+
+	lastSample := -1
+	for i, data := range samples {
+		if data != nil {
+			lastSample = i
+		}
+	}
+	for i, data := range samples {
+		if data == nil {
+			continue
+		}
+		buf.Write(data)
+		// The last block of the file is not padded.
+		if i != lastSample {
+			pad(&buf)
+		}
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// packPattern encodes a pattern, including its length prefix.
+func (s *S3M) packPattern(pattern Pattern) []byte {
+	packed := []byte{0, 0}
+	for row := 0; row < 64; row++ {
+		for channel, remapped := range s.ChannelRemap {
+			if remapped < 0 || row >= len(pattern) || remapped >= len(pattern[row]) {
+				continue
+			}
+			entry := pattern[row][remapped]
+			what := byte(channel)
+			if entry.Note != 255 || entry.Instrument != 0 {
+				what |= 32
+			}
+			if entry.Volume != 255 {
+				what |= 64
+			}
+			if entry.Effect.Command != 0 || entry.Effect.X != 0 || entry.Effect.Y != 0 {
+				what |= 128
+			}
+			if what&0xE0 == 0 {
+				continue
+			}
+			packed = append(packed, what)
+			if what&32 != 0 {
+				packed = append(packed, entry.Note, entry.Instrument)
+			}
+			if what&64 != 0 {
+				packed = append(packed, entry.Volume)
+			}
+			if what&128 != 0 {
+				packed = append(packed, entry.Effect.Command, entry.Effect.X<<4|entry.Effect.Y&0x0F)
+			}
+		}
+		packed = append(packed, 0)
+	}
+	binary.LittleEndian.PutUint16(packed, uint16(len(packed)))
+	return packed
+}
+
+// encodeSample converts sample data back to the format stored in the file.
+func (s *S3M) encodeSample(inst *Instrument) []byte {
+	data := make([]byte, 0, inst.length)
+	if inst.flags&4 != 0 {
+		for _, v := range inst.data {
+			u := uint16(v)
+			if !s.SignedSamples {
+				u += 32768
+			}
+			data = binary.LittleEndian.AppendUint16(data, u)
+		}
+	} else {
+		for _, v := range inst.data {
+			b := byte(v >> 8)
+			if !s.SignedSamples {
+				b += 128
+			}
+			data = append(data, b)
+		}
+	}
+	return data
+}
+
+// isEmpty reports whether every entry of a pattern is empty.
+func (p Pattern) isEmpty() bool {
+	for _, row := range p {
+		for _, entry := range row {
+			if entry != EmptyEntry {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// align rounds offset up to the next 16 byte paragraph.
+func align(offset int) int {
+	return (offset + 15) &^ 15
+}
+
+// pad fills buf up to the next 16 byte paragraph.
+func pad(buf *bytes.Buffer) {
+	for buf.Len()%16 != 0 {
+		buf.WriteByte(paddingByte)
+	}
+}
+
+// NewInstrument returns a sample instrument holding data, which uses the
+// 16-bit scale of Instrument.Data and is stored with 8 bits, as Scream Tracker
+// 3 does not play 16-bit samples. The loop points are in samples, and the
+// sample loops when loopEnd is greater than loopBegin.
+func NewInstrument(name string, data []int16, volume byte, c2spd, loopBegin, loopEnd uint32) Instrument {
+	inst := Instrument{
+		Type:      1,
+		length:    uint32(len(data)),
+		LoopBegin: loopBegin,
+		loopEnd:   loopEnd,
+		volume:    min(volume, 64),
+		C2Spd:     c2spd,
+		data:      make([]int16, len(data)),
+	}
+	if loopEnd > loopBegin {
+		inst.flags |= 1
+	}
+	for i, v := range data {
+		inst.data[i] = v &^ 0xFF
+	}
+	copy(inst.SampleName[:], name)
+	copy(inst.Signature[:], "SCRS")
+	return inst
+}
+
+// New returns a module using the given order list, instruments and patterns.
+// Each pattern holds 64 rows of numChannels entries, and the channels are
+// panned left, right, right, left as on the Amiga.
+func New(name string, numChannels int, speed, tempo byte, orders []byte, instruments []Instrument, patterns []Pattern) (*S3M, error) {
+	if numChannels < 1 || numChannels > 32 {
+		return nil, fmt.Errorf("unsupported number of channels: %d", numChannels)
+	}
+	if len(instruments) > 99 {
+		return nil, fmt.Errorf("too many instruments: %d", len(instruments))
+	}
+	if len(patterns) > 100 {
+		return nil, fmt.Errorf("too many patterns: %d", len(patterns))
+	}
+	if len(orders) > 255 {
+		return nil, fmt.Errorf("too many orders: %d", len(orders))
+	}
+	for i, p := range patterns {
+		if len(p) != 64 {
+			return nil, fmt.Errorf("pattern %d has %d rows, want 64", i, len(p))
+		}
+		for _, row := range p {
+			if len(row) != numChannels {
+				return nil, fmt.Errorf("pattern %d has %d channels, want %d", i, len(row), numChannels)
+			}
+		}
+	}
+
+	s := &S3M{
+		Instruments: instruments,
+		Patterns:    patterns,
+		numChannels: numChannels,
+	}
+	copy(s.Header.SongName[:], name)
+	s.Header.Marker1A = 0x1A
+	s.Header.FileType = 16
+	s.Header.TrackerVersion = 0x1320
+	s.Header.SampleType = 2
+	copy(s.Header.Signature[:], "SCRM")
+	s.Header.GlobalVolume = 64
+	s.Header.InitialSpeed = speed
+	s.Header.InitialTempo = tempo
+	s.Header.MasterVolume = 0x80 | 48 // stereo
+
+	// Scream Tracker 3 keeps an even number of orders, padded with end markers.
+	s.Orders = append([]byte(nil), orders...)
+	for len(s.Orders) == 0 || len(s.Orders)%2 != 0 {
+		s.Orders = append(s.Orders, 255)
+	}
+	highestPattern := 0
+	for _, o := range s.Orders {
+		if o < 254 {
+			highestPattern = max(highestPattern, int(o))
+		}
+	}
+	s.ActualPatternCount = highestPattern + 1
+
+	left, right := 0, 0
+	for i := range s.Header.ChannelSettings {
+		s.ChannelRemap[i] = -1
+		s.Header.ChannelSettings[i] = 255
+		if i >= numChannels {
+			continue
+		}
+		s.ChannelRemap[i] = i
+		if i%4 == 0 || i%4 == 3 {
+			s.Header.ChannelSettings[i] = byte(left % 8)
+			left++
+		} else {
+			s.Header.ChannelSettings[i] = byte(8 + right%8)
+			right++
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,369 @@
+package transcode
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/jesseward/impulse/pkg/module"
+	"github.com/jesseward/impulse/pkg/protracker"
+	"github.com/jesseward/impulse/pkg/s3m"
+	"github.com/jesseward/impulse/pkg/xm"
+)
+
+// read converts a module to a song.
+func read(m module.Module, w *warnings) (*song, error) {
+	s := &song{
+		name:     m.Name(),
+		channels: m.NumChannels(),
+		speed:    m.DefaultSpeed(),
+		bpm:      m.DefaultBPM(),
+	}
+
+	var convertCell func(module.Cell) cell
+	switch src := m.(type) {
+	case *protracker.ModFile:
+		for _, smp := range m.Samples() {
+			s.instruments = append(s.instruments, readSample(smp, float64(int8(smp.Finetune()))/8))
+		}
+		ultimate := src.IsUltimateSoundtracker()
+		convertCell = func(c module.Cell) cell { return modCell(c, ultimate) }
+	case *s3m.S3M:
+		for _, smp := range m.Samples() {
+			c2spd := smp.Finetune()
+			if c2spd == 0 {
+				c2spd = 8363
+			}
+			s.instruments = append(s.instruments, readSample(smp, 12*math.Log2(float64(c2spd)/8363)))
+		}
+		convertCell = func(c module.Cell) cell { return s3mCell(c, w) }
+	case *xm.Module:
+		s.linear = src.Header.Flags&1 != 0
+		for i, inst := range src.Instruments {
+			s.instruments = append(s.instruments, readXMInstrument(i+1, inst, w))
+		}
+		convertCell = xmCell
+	default:
+		return nil, fmt.Errorf("cannot transcode %s modules", m.Type())
+	}
+
+	order := m.PatternOrder()
+	if n := m.SongLength(); n < len(order) {
+		order = order[:n]
+	}
+	numPatterns := m.NumPatterns()
+	for _, o := range order {
+		numPatterns = max(numPatterns, o+1)
+	}
+	s.order = order
+	s.patterns = make([]pattern, numPatterns)
+	for i := range s.patterns {
+		rows := m.NumRows(i)
+		if rows == 0 {
+			s.patterns[i] = newPattern(64, s.channels)
+			continue
+		}
+		s.patterns[i] = newPattern(rows, s.channels)
+		for row := 0; row < rows; row++ {
+			for ch := 0; ch < s.channels; ch++ {
+				s.patterns[i][row][ch] = convertCell(m.PatternCell(i, row, ch))
+			}
+		}
+	}
+	return s, nil
+}
+
+// readSample converts a MOD or S3M sample, whose loop points are in samples.
+func readSample(smp module.Sample, tune float64) instrument {
+	inst := instrument{
+		name:       smp.Name(),
+		data:       smp.Data(),
+		sixteenBit: smp.Flags()&4 != 0,
+		volume:     smp.Volume(),
+		panning:    128,
+		tune:       tune,
+		loopStart:  int(smp.LoopStart()),
+		loopLength: int(smp.LoopLength()),
+	}
+	// MOD samples without a loop have a loop length of one word.
+	if inst.loopLength <= 2 {
+		inst.loopStart, inst.loopLength = 0, 0
+	}
+	return inst
+}
+
+// readXMInstrument converts the sample that an XM instrument plays for C-4.
+func readXMInstrument(number int, inst *xm.Instrument, w *warnings) instrument {
+	out := instrument{name: inst.Name, panning: 128}
+	if len(inst.Samples) == 0 {
+		return out
+	}
+	index := 0
+	if int(inst.SampleKeymap[48]) < len(inst.Samples) {
+		index = int(inst.SampleKeymap[48])
+	}
+	if len(inst.Samples) > 1 {
+		w.add("instrument %d has %d samples, only the one played for C-4 was kept", number, len(inst.Samples))
+	}
+	if inst.VolumeType&1 != 0 || inst.PanningType&1 != 0 {
+		w.add("instrument envelopes were dropped")
+	}
+	if inst.VibratoDepth != 0 {
+		w.add("instrument auto-vibrato was dropped")
+	}
+
+	smp := inst.Samples[index]
+	out.data = smp.Data()
+	out.sixteenBit = smp.Flags()&0x10 != 0
+	out.volume = smp.Volume()
+	out.panning = smp.Panning()
+	out.tune = float64(smp.RelativeNote()) + float64(int8(smp.Finetune()))/128
+	if loopType := smp.Flags() & 3; loopType != 0 {
+		out.loopStart, out.loopLength = int(smp.LoopStart()), int(smp.LoopLength())
+		if out.sixteenBit {
+			out.loopStart /= 2
+			out.loopLength /= 2
+		}
+		out.pingPong = loopType == 2
+	}
+	return out
+}
+
+// modPeriods holds the Protracker periods of the notes C-1 to B-3 without
+// finetune, which are XM notes C-3 to B-5.
+var modPeriods = [36]uint16{
+	856, 808, 762, 720, 678, 640, 604, 570, 538, 508, 480, 453,
+	428, 404, 381, 360, 339, 320, 302, 285, 269, 254, 240, 226,
+	214, 202, 190, 180, 170, 160, 151, 143, 135, 127, 120, 113,
+}
+
+// modFirstNote is the XM note of the first entry of modPeriods.
+const modFirstNote = 37
+
+// periodToNote returns the XM note closest to a Protracker period. Period
+// 428 is C-4.
+func periodToNote(period uint16) byte {
+	if period == 0 {
+		return 0
+	}
+	note := 49 + int(math.Round(12*math.Log2(428/float64(period))))
+	return byte(max(1, min(96, note)))
+}
+
+// modCell converts a MOD pattern cell. Ultimate Soundtracker modules only
+// know arpeggio (1) and pitch bend (2).
+func modCell(c module.Cell, ultimate bool) cell {
+	out := cell{
+		note:       periodToNote(c.Period),
+		instrument: c.SampleNumber,
+		volume:     -1,
+		effect:     effect{c.Effect, c.EffectParam},
+	}
+	if ultimate {
+		switch c.Effect {
+		case 1:
+			out.effect = effect{fxArpeggio, c.EffectParam}
+		case 2:
+			if y := c.EffectParam & 0x0F; y != 0 {
+				out.effect = effect{fxPortaDown, y}
+			} else {
+				out.effect = effect{fxPortaUp, c.EffectParam >> 4}
+			}
+		default:
+			out.effect = effect{}
+		}
+	}
+	return out
+}
+
+// s3mCell converts an S3M pattern cell.
+func s3mCell(c module.Cell, w *warnings) cell {
+	out := cell{instrument: c.Instrument, volume: -1}
+	switch {
+	case c.Note == 254:
+		out.note = noteOff
+	case c.Note < 254:
+		out.note = byte(min(96, int(c.Note>>4)*12+int(c.Note&0x0F)+1))
+	}
+	if c.Volume <= 64 {
+		out.volume = int(c.Volume)
+	}
+	out.effect = s3mEffect(c.Effect, c.EffectParam, w)
+	return out
+}
+
+// s3mEffect converts an S3M effect, where command 1 is effect A.
+func s3mEffect(command, param byte, w *warnings) effect {
+	if command == 0 || command > 26 {
+		return effect{}
+	}
+	letter := 'A' + command - 1
+	x, y := param>>4, param&0x0F
+	switch letter {
+	case 'A': // Set speed
+		if param == 0 {
+			return effect{}
+		}
+		if param > 31 {
+			w.add("speeds above 31 (Axx) were limited to 31")
+			param = 31
+		}
+		return effect{fxSpeed, param}
+	case 'B': // Jump to order
+		return effect{fxJump, param}
+	case 'C': // Pattern break
+		return effect{fxBreak, param}
+	case 'D': // Volume slide
+		return s3mVolSlide(param)
+	case 'E', 'F': // Portamento down and up
+		command, fine := byte(fxPortaDown), byte(0x20)
+		if letter == 'F' {
+			command, fine = fxPortaUp, 0x10
+		}
+		switch x {
+		case 0xF:
+			return effect{fxExtended, fine | y}
+		case 0xE:
+			return effect{fxExtraFinePorta, fine | y}
+		}
+		return effect{command, param}
+	case 'G':
+		return effect{fxTonePorta, param}
+	case 'H':
+		return effect{fxVibrato, param}
+	case 'I':
+		return effect{fxTremor, param}
+	case 'J':
+		return effect{fxArpeggio, param}
+	case 'K':
+		return effect{fxVibratoVolSlide, slideParam(param)}
+	case 'L':
+		return effect{fxTonePortaVolSlide, slideParam(param)}
+	case 'O':
+		return effect{fxOffset, param}
+	case 'Q':
+		return effect{fxRetrig, param}
+	case 'R':
+		return effect{fxTremolo, param}
+	case 'S':
+		return s3mSpecial(x, y, w)
+	case 'T':
+		if param < 32 {
+			w.add("tempos below 32 (Txx) were dropped")
+			return effect{}
+		}
+		return effect{fxSpeed, param}
+	case 'U':
+		w.add("fine vibrato (Uxy) was converted to vibrato")
+		return effect{fxVibrato, x<<4 | max(y/4, min(y, 1))}
+	case 'V':
+		return effect{fxGlobalVolume, min(param, 64)}
+	case 'W':
+		return effect{fxGlobalVolSlide, param}
+	case 'X':
+		if param > 0x80 {
+			w.add("surround panning (XA4) was dropped")
+			return effect{}
+		}
+		return effect{fxPanning, byte(min(int(param)*2, 255))}
+	}
+	w.add("S3M effect %cxx has no equivalent and was dropped", letter)
+	return effect{}
+}
+
+// s3mVolSlide converts an S3M volume slide, where DxF and DFx are fine slides.
+func s3mVolSlide(param byte) effect {
+	x, y := param>>4, param&0x0F
+	switch {
+	case y == 0xF && x != 0:
+		return effect{fxExtended, 0xA0 | x}
+	case x == 0xF && y != 0:
+		return effect{fxExtended, 0xB0 | y}
+	}
+	return effect{fxVolSlide, slideParam(param)}
+}
+
+// slideParam returns a volume slide parameter with only one direction set,
+// preferring the slide up as FastTracker II does.
+func slideParam(param byte) byte {
+	if param>>4 != 0 {
+		return param & 0xF0
+	}
+	return param
+}
+
+// s3mSpecial converts an S3M Sxy effect.
+func s3mSpecial(x, y byte, w *warnings) effect {
+	switch x {
+	case 0x0: // Filter
+		return effect{fxExtended, 0x00 | y}
+	case 0x1: // Glissando
+		return effect{fxExtended, 0x30 | y}
+	case 0x2: // Finetune
+		return effect{fxExtended, 0x50 | y}
+	case 0x3: // Vibrato waveform
+		return effect{fxExtended, 0x40 | y}
+	case 0x4: // Tremolo waveform
+		return effect{fxExtended, 0x70 | y}
+	case 0x8: // Panning
+		return effect{fxPanning, y * 17}
+	case 0xB: // Pattern loop
+		return effect{fxExtended, 0x60 | y}
+	case 0xC: // Note cut
+		return effect{fxExtended, 0xC0 | y}
+	case 0xD: // Note delay
+		return effect{fxExtended, 0xD0 | y}
+	case 0xE: // Pattern delay
+		return effect{fxExtended, 0xE0 | y}
+	}
+	w.add("S3M effect S%Xx has no equivalent and was dropped", x)
+	return effect{}
+}
+
+// xmCell converts an XM pattern cell.
+func xmCell(c module.Cell) cell {
+	out := cell{
+		note:       c.Note,
+		instrument: c.Instrument,
+		volume:     -1,
+		effect:     effect{c.Effect, c.EffectParam},
+	}
+	if out.note > noteOff {
+		out.note = 0
+	}
+	switch v := c.Volume; {
+	case v >= 0x10 && v <= 0x50:
+		out.volume = int(v - 0x10)
+	case v >= 0x60:
+		out.volEffect = volumeColumnEffect(v)
+	}
+	return out
+}
+
+// volumeColumnEffect returns the effect equivalent to an XM volume column
+// command.
+func volumeColumnEffect(v byte) effect {
+	x := v & 0x0F
+	switch v >> 4 {
+	case 0x6: // Volume slide down
+		return effect{fxVolSlide, x}
+	case 0x7: // Volume slide up
+		return effect{fxVolSlide, x << 4}
+	case 0x8: // Fine volume slide down
+		return effect{fxExtended, 0xB0 | x}
+	case 0x9: // Fine volume slide up
+		return effect{fxExtended, 0xA0 | x}
+	case 0xA: // Vibrato speed
+		return effect{fxVibrato, x << 4}
+	case 0xB: // Vibrato depth
+		return effect{fxVibrato, x}
+	case 0xC: // Set panning
+		return effect{fxPanning, x * 17}
+	case 0xD: // Panning slide left
+		return effect{fxPanSlide, x}
+	case 0xE: // Panning slide right
+		return effect{fxPanSlide, x << 4}
+	case 0xF: // Tone portamento
+		return effect{fxTonePorta, x << 4}
+	}
+	return effect{}
+}
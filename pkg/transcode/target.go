@@ -0,0 +1,506 @@
+package transcode
+
+import (
+	"math"
+
+	"github.com/jesseward/impulse/pkg/module"
+	"github.com/jesseward/impulse/pkg/protracker"
+	"github.com/jesseward/impulse/pkg/s3m"
+	"github.com/jesseward/impulse/pkg/xm"
+)
+
+// toMOD converts a song to a MOD file.
+func toMOD(s *song, w *warnings) (*protracker.ModFile, error) {
+	s.fitPatterns(64, w)
+	if s.linear {
+		w.add("MOD has no linear frequency table, pitch slides will sound different")
+	}
+
+	instruments := s.instruments
+	if len(instruments) > 31 {
+		w.add("only the first 31 of %d instruments were kept", len(instruments))
+		instruments = instruments[:31]
+	}
+	samples := make([]protracker.Sample, len(instruments))
+	transposes := make([]int, len(instruments))
+	for i, inst := range instruments {
+		samples[i], transposes[i] = modSample(inst, w)
+	}
+
+	// MOD files always start at speed 6 and 125 BPM.
+	if len(s.order) > 0 && len(s.patterns[s.order[0]]) > 0 {
+		row := s.patterns[s.order[0]][0]
+		if s.speed != 6 && !setEffect(row, effect{fxSpeed, byte(max(1, min(31, s.speed)))}) {
+			w.add("the initial speed could not be stored")
+		}
+		if s.bpm != 125 && !setEffect(row, effect{fxSpeed, byte(max(32, min(255, s.bpm)))}) {
+			w.add("the initial tempo could not be stored")
+		}
+	}
+
+	patterns := make([][]protracker.ChannelSequence, len(s.patterns))
+	for i, p := range s.patterns {
+		patterns[i] = make([]protracker.ChannelSequence, 0, 64*s.channels)
+		// The instrument last used on each channel, to transpose its notes.
+		current := make([]int, s.channels)
+		for _, row := range p {
+			for ch, c := range row {
+				if c.instrument > 0 {
+					current[ch] = int(c.instrument)
+				}
+				transpose := 0
+				if current[ch] > 0 && current[ch] <= len(transposes) {
+					transpose = transposes[current[ch]-1]
+				}
+				patterns[i] = append(patterns[i], modCellFrom(c, transpose, len(instruments), w))
+			}
+		}
+	}
+
+	order := make([]byte, len(s.order))
+	for i, o := range s.order {
+		order[i] = byte(o)
+	}
+	return protracker.New(s.name, s.channels, samples, order, patterns)
+}
+
+// modSample converts an instrument to a MOD sample. Tunings beyond the MOD
+// finetune range are moved to the notes, by the returned number of semitones.
+func modSample(inst instrument, w *warnings) (protracker.Sample, int) {
+	data := inst.data
+	if inst.sixteenBit {
+		w.add("16-bit samples were reduced to 8 bits")
+	}
+	if len(data) > 131070 {
+		w.add("samples longer than 131070 bytes were truncated")
+		data = data[:131070]
+	}
+	if inst.pingPong {
+		w.add("ping-pong loops were converted to forward loops")
+	}
+	transpose := 0
+	finetune := math.Round(inst.tune * 8)
+	if finetune < -8 || finetune > 7 {
+		transpose = int(math.Round(inst.tune))
+		finetune = math.Round((inst.tune - float64(transpose)) * 8)
+	}
+	loopStart, loopLength := clampLoop(inst, len(data))
+	return protracker.NewSample(inst.name, data, inst.volume, int8(finetune), uint32(loopStart), uint32(loopLength)), transpose
+}
+
+// modCellFrom converts a cell to a MOD pattern cell, transposing its note by
+// transpose semitones. MOD has no volume column, so volumes and volume column
+// effects use the effect column when it is free.
+func modCellFrom(c cell, transpose, numSamples int, w *warnings) protracker.ChannelSequence {
+	var out protracker.ChannelSequence
+	if int(c.instrument) <= numSamples {
+		out.SampleNumber = c.instrument
+	} else {
+		w.add("notes using instruments beyond the last MOD sample were dropped")
+	}
+
+	e := modEffect(c.effect, w)
+	extra := []effect{modEffect(c.volEffect, w)}
+	if c.volume >= 0 {
+		extra = append(extra, effect{fxVolume, byte(c.volume)})
+	}
+	switch {
+	case c.note == noteOff:
+		extra = append(extra, effect{fxExtended, 0xC0})
+	case c.note > 0:
+		out.Period = noteToPeriod(byte(max(1, min(96, int(c.note)+transpose))), w)
+	}
+	e = mergeEffects(e, extra, "MOD", w)
+
+	out.Effect = module.Effect{Command: e.command, X: e.param >> 4, Y: e.param & 0x0F}
+	return out
+}
+
+// noteToPeriod returns the Protracker period of an XM note, transposed by
+// octaves into the range of MOD.
+func noteToPeriod(note byte, w *warnings) uint16 {
+	n := int(note)
+	if n < modFirstNote || n >= modFirstNote+len(modPeriods) {
+		w.add("notes outside the three octaves of MOD were transposed by octaves")
+	}
+	for n < modFirstNote {
+		n += 12
+	}
+	for n >= modFirstNote+len(modPeriods) {
+		n -= 12
+	}
+	return modPeriods[n-modFirstNote]
+}
+
+// modEffect converts an effect to a MOD effect, whose numbers match the first
+// sixteen XM effects.
+func modEffect(e effect, w *warnings) effect {
+	if e == (effect{}) {
+		return e
+	}
+	x, y := e.param>>4, e.param&0x0F
+	switch e.command {
+	case fxSpeed:
+		if e.param == 0 {
+			return effect{}
+		}
+		return e
+	case fxRetrig:
+		if x != 0 {
+			w.add("multi retrig volume changes (Rxy) were dropped")
+		}
+		return effect{fxExtended, 0x90 | y}
+	case fxExtraFinePorta:
+		w.add("extra fine portamento (Xxy) was converted to fine portamento")
+		if (x == 1 || x == 2) && y >= 4 {
+			return effect{fxExtended, x<<4 | y/4}
+		}
+		return effect{}
+	case fxKeyOff:
+		return effect{fxExtended, 0xC0 | min(e.param, 15)}
+	}
+	if e.command <= fxSpeed {
+		return e
+	}
+	w.add("%s has no equivalent in MOD and was dropped", effectName(e.command))
+	return effect{}
+}
+
+// mergeEffects returns e, or the first of extra when e is empty. Any other
+// effects of extra are dropped with a warning.
+func mergeEffects(e effect, extra []effect, format string, w *warnings) effect {
+	for _, x := range extra {
+		switch {
+		case x == (effect{}):
+		case e == (effect{}):
+			e = x
+		default:
+			w.add("%s was dropped because %s has only one effect column", effectName(x.command), format)
+		}
+	}
+	return e
+}
+
+// clampLoop returns the loop of an instrument limited to length samples.
+func clampLoop(inst instrument, length int) (start, loopLength int) {
+	if inst.loopLength == 0 || inst.loopStart >= length {
+		return 0, 0
+	}
+	return inst.loopStart, min(inst.loopLength, length-inst.loopStart)
+}
+
+// toS3M converts a song to an S3M module.
+func toS3M(s *song, w *warnings) (*s3m.S3M, error) {
+	s.fitPatterns(64, w)
+	if s.linear {
+		w.add("S3M has no linear frequency table, pitch slides will sound different")
+	}
+
+	instruments := s.instruments
+	if len(instruments) > 99 {
+		w.add("only the first 99 of %d instruments were kept", len(instruments))
+		instruments = instruments[:99]
+	}
+	out := make([]s3m.Instrument, len(instruments))
+	for i, inst := range instruments {
+		if inst.sixteenBit {
+			w.add("16-bit samples were reduced to 8 bits")
+		}
+		if inst.pingPong {
+			w.add("ping-pong loops were converted to forward loops")
+		}
+		c2spd := uint32(math.Round(8363 * math.Pow(2, inst.tune/12)))
+		loopStart, loopLength := clampLoop(inst, len(inst.data))
+		out[i] = s3m.NewInstrument(inst.name, inst.data, inst.volume, c2spd, uint32(loopStart), uint32(loopStart+loopLength))
+	}
+
+	patterns := make([]s3m.Pattern, len(s.patterns))
+	for i, p := range s.patterns {
+		patterns[i] = make(s3m.Pattern, len(p))
+		for r, row := range p {
+			patterns[i][r] = make([]s3m.PatternEntry, len(row))
+			for ch, c := range row {
+				patterns[i][r][ch] = s3mEntry(c, len(instruments), w)
+			}
+		}
+	}
+
+	orders := make([]byte, len(s.order))
+	for i, o := range s.order {
+		orders[i] = byte(o)
+	}
+	return s3m.New(s.name, s.channels, byte(max(1, min(255, s.speed))), byte(max(32, min(255, s.bpm))), orders, out, patterns)
+}
+
+// s3mEntry converts a cell to an S3M pattern entry.
+func s3mEntry(c cell, numInstruments int, w *warnings) s3m.PatternEntry {
+	out := s3m.EmptyEntry
+	if int(c.instrument) <= numInstruments {
+		out.Instrument = c.instrument
+	} else {
+		w.add("notes using instruments beyond the last S3M instrument were dropped")
+	}
+	switch {
+	case c.note == noteOff:
+		out.Note = 254
+	case c.note > 0:
+		out.Note = (c.note-1)/12<<4 | (c.note-1)%12
+	}
+
+	e := c.effect
+	if c.volume >= 0 {
+		out.Volume = byte(c.volume)
+	}
+	if e.command == fxVolume {
+		if c.volume >= 0 {
+			w.add("set volume (Cxx) was dropped because the volume column was in use")
+		} else {
+			out.Volume = min(e.param, 64)
+		}
+		e = effect{}
+	}
+	e = mergeEffects(e, []effect{c.volEffect}, "S3M", w)
+
+	letter, param := s3mEffectFrom(e, w)
+	if letter != 0 {
+		out.Effect = module.Effect{Command: letter - 'A' + 1, X: param >> 4, Y: param & 0x0F}
+	}
+	return out
+}
+
+// s3mEffectFrom converts an effect to an S3M effect letter and parameter. The
+// letter is 0 when there is no effect.
+func s3mEffectFrom(e effect, w *warnings) (byte, byte) {
+	if e == (effect{}) {
+		return 0, 0
+	}
+	x, y := e.param>>4, e.param&0x0F
+	switch e.command {
+	case fxArpeggio:
+		return 'J', e.param
+	case fxPortaUp, fxPortaDown:
+		letter := byte('F')
+		if e.command == fxPortaDown {
+			letter = 'E'
+		}
+		// Parameters from E0 are fine slides in S3M.
+		if e.param >= 0xE0 {
+			w.add("portamento speeds above DF were limited to DF")
+			return letter, 0xDF
+		}
+		return letter, e.param
+	case fxTonePorta:
+		return 'G', e.param
+	case fxVibrato:
+		return 'H', e.param
+	case fxTonePortaVolSlide:
+		return 'L', slideParam(e.param)
+	case fxVibratoVolSlide:
+		return 'K', slideParam(e.param)
+	case fxTremolo:
+		return 'R', e.param
+	case fxPanning:
+		return 'X', byte((int(e.param) + 1) / 2)
+	case fxOffset:
+		return 'O', e.param
+	case fxVolSlide:
+		return 'D', slideParam(e.param)
+	case fxJump:
+		return 'B', e.param
+	case fxBreak:
+		return 'C', e.param
+	case fxSpeed:
+		switch {
+		case e.param == 0:
+			return 0, 0
+		case e.param < 32:
+			return 'A', e.param
+		}
+		return 'T', e.param
+	case fxGlobalVolume:
+		return 'V', e.param
+	case fxRetrig:
+		return 'Q', e.param
+	case fxTremor:
+		return 'I', e.param
+	case fxKeyOff:
+		return 'S', 0xC0 | min(e.param, 15)
+	case fxExtraFinePorta:
+		switch x {
+		case 1:
+			return 'F', 0xE0 | y
+		case 2:
+			return 'E', 0xE0 | y
+		}
+		return 0, 0
+	case fxExtended:
+		return s3mExtendedFrom(x, y, w)
+	}
+	w.add("%s has no equivalent in S3M and was dropped", effectName(e.command))
+	return 0, 0
+}
+
+// s3mExtendedFrom converts an XM Exy effect to an S3M effect.
+func s3mExtendedFrom(x, y byte, w *warnings) (byte, byte) {
+	switch x {
+	case 0x0: // Filter
+		return 'S', 0x00 | y
+	case 0x1: // Fine portamento up
+		return 'F', 0xF0 | y
+	case 0x2: // Fine portamento down
+		return 'E', 0xF0 | y
+	case 0x3: // Glissando
+		return 'S', 0x10 | y
+	case 0x4: // Vibrato waveform
+		return 'S', 0x30 | y
+	case 0x5: // Finetune
+		return 'S', 0x20 | y
+	case 0x6: // Pattern loop
+		return 'S', 0xB0 | y
+	case 0x7: // Tremolo waveform
+		return 'S', 0x40 | y
+	case 0x8: // Panning
+		return 'S', 0x80 | y
+	case 0x9: // Retrigger
+		return 'Q', y
+	case 0xA: // Fine volume slide up
+		if y != 0 {
+			return 'D', y<<4 | 0x0F
+		}
+	case 0xB: // Fine volume slide down
+		if y != 0 {
+			return 'D', 0xF0 | y
+		}
+	case 0xC: // Note cut
+		return 'S', 0xC0 | y
+	case 0xD: // Note delay
+		return 'S', 0xD0 | y
+	case 0xE: // Pattern delay
+		return 'S', 0xE0 | y
+	}
+	w.add("effect E%X has no equivalent in S3M and was dropped", x)
+	return 0, 0
+}
+
+// toXM converts a song to an XM module.
+func toXM(s *song, w *warnings) (*xm.Module, error) {
+	instruments := s.instruments
+	if len(instruments) > 128 {
+		w.add("only the first 128 of %d instruments were kept", len(instruments))
+		instruments = instruments[:128]
+	}
+	out := make([]*xm.Instrument, len(instruments))
+	for i, inst := range instruments {
+		var samples []*xm.Sample
+		if len(inst.data) > 0 {
+			var flags byte
+			loopStart, loopLength := clampLoop(inst, len(inst.data))
+			if loopLength > 0 {
+				flags = 1
+				if inst.pingPong {
+					flags = 2
+				}
+			}
+			if inst.sixteenBit {
+				flags |= 0x10
+			}
+			relativeNote := math.Round(inst.tune)
+			finetune := math.Round((inst.tune - relativeNote) * 128)
+			samples = append(samples, xm.NewSample(inst.name, inst.data, flags, inst.volume,
+				int8(max(-128, min(127, finetune))), int8(max(-96, min(95, relativeNote))),
+				inst.panning, uint32(loopStart), uint32(loopLength)))
+		}
+		out[i] = xm.NewInstrument(inst.name, samples)
+	}
+
+	patterns := make([]*xm.Pattern, len(s.patterns))
+	for i, p := range s.patterns {
+		patterns[i] = &xm.Pattern{NumRows: uint16(len(p)), Notes: make([][]xm.Note, len(p))}
+		for r, row := range p {
+			patterns[i].Notes[r] = make([]xm.Note, len(row))
+			for ch, c := range row {
+				patterns[i].Notes[r][ch] = xmNote(c, len(instruments), w)
+			}
+		}
+	}
+
+	order := make([]byte, len(s.order))
+	for i, o := range s.order {
+		order[i] = byte(o)
+	}
+	m, err := xm.New(s.name, s.channels, uint16(s.speed), uint16(s.bpm), order, patterns, out)
+	if err != nil {
+		return nil, err
+	}
+	if !s.linear {
+		m.Header.Flags &^= 1
+	}
+	return m, nil
+}
+
+// xmNote converts a cell to an XM note.
+func xmNote(c cell, numInstruments int, w *warnings) xm.Note {
+	out := xm.Note{Note: c.note, EffectType: c.effect.command, EffectParam: c.effect.param}
+	if int(c.instrument) <= numInstruments {
+		out.Instrument = c.instrument
+	} else {
+		w.add("notes using instruments beyond the last XM instrument were dropped")
+	}
+	switch {
+	case c.volume >= 0:
+		out.Volume = 0x10 + byte(min(c.volume, 64))
+	case c.volEffect != (effect{}):
+		if v, ok := volumeColumn(c.volEffect); ok {
+			out.Volume = v
+		} else if out.EffectType == 0 && out.EffectParam == 0 {
+			out.EffectType, out.EffectParam = c.volEffect.command, c.volEffect.param
+		} else {
+			w.add("%s was dropped because the effect column was in use", effectName(c.volEffect.command))
+		}
+	}
+	return out
+}
+
+// volumeColumn returns the XM volume column command equivalent to e.
+func volumeColumn(e effect) (byte, bool) {
+	x, y := e.param>>4, e.param&0x0F
+	switch e.command {
+	case fxVolSlide:
+		if x == 0 {
+			return 0x60 | y, true
+		}
+		if y == 0 {
+			return 0x70 | x, true
+		}
+	case fxExtended:
+		switch x {
+		case 0xA:
+			return 0x90 | y, true
+		case 0xB:
+			return 0x80 | y, true
+		}
+	case fxVibrato:
+		if y == 0 {
+			return 0xA0 | x, true
+		}
+		if x == 0 {
+			return 0xB0 | y, true
+		}
+	case fxPanning:
+		if e.param%17 == 0 {
+			return 0xC0 | e.param/17, true
+		}
+	case fxPanSlide:
+		if x == 0 {
+			return 0xD0 | y, true
+		}
+		if y == 0 {
+			return 0xE0 | x, true
+		}
+	case fxTonePorta:
+		if y == 0 {
+			return 0xF0 | x, true
+		}
+	}
+	return 0, false
+}
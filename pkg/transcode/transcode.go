@@ -0,0 +1,299 @@
+// Package transcode converts modules between the MOD, S3M and XM formats.
+//
+// A module is first read into a format neutral song, whose notes and effects
+// use the FastTracker II numbering, as XM effects are a superset of the MOD
+// effects. The song is then written in the target format. Anything that the
+// target format cannot represent exactly is approximated or dropped, and
+// reported as a warning.
+package transcode
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jesseward/impulse/pkg/module"
+	"github.com/jesseward/impulse/pkg/protracker"
+	"github.com/jesseward/impulse/pkg/s3m"
+	"github.com/jesseward/impulse/pkg/xm"
+)
+
+// Target formats.
+const (
+	MOD = "mod"
+	S3M = "s3m"
+	XM  = "xm"
+)
+
+// noteOff is the XM key off note. It is also used for the S3M note cut.
+const noteOff = 97
+
+// XM effect commands.
+const (
+	fxArpeggio          = 0x00
+	fxPortaUp           = 0x01
+	fxPortaDown         = 0x02
+	fxTonePorta         = 0x03
+	fxVibrato           = 0x04
+	fxTonePortaVolSlide = 0x05
+	fxVibratoVolSlide   = 0x06
+	fxTremolo           = 0x07
+	fxPanning           = 0x08
+	fxOffset            = 0x09
+	fxVolSlide          = 0x0A
+	fxJump              = 0x0B
+	fxVolume            = 0x0C
+	fxBreak             = 0x0D
+	fxExtended          = 0x0E
+	fxSpeed             = 0x0F
+	fxGlobalVolume      = 0x10 // G
+	fxGlobalVolSlide    = 0x11 // H
+	fxKeyOff            = 0x14 // K
+	fxEnvelopePos       = 0x15 // L
+	fxPanSlide          = 0x19 // P
+	fxRetrig            = 0x1B // R
+	fxTremor            = 0x1D // T
+	fxExtraFinePorta    = 0x21 // X
+)
+
+// song is a module in a format neutral form.
+type song struct {
+	name        string
+	channels    int
+	speed, bpm  int
+	linear      bool // pitch slides use the XM linear frequency table
+	order       []int
+	patterns    []pattern
+	instruments []instrument
+}
+
+// pattern holds the cells of a pattern, indexed by row and channel.
+type pattern [][]cell
+
+// cell is a pattern cell.
+type cell struct {
+	note       byte // 1-96 for C-0 to B-7, noteOff, or 0 for none
+	instrument byte
+	volume     int    // 0-64, or -1 for none
+	volEffect  effect // an effect from the XM volume column
+	effect     effect
+}
+
+// effect is an XM effect command. The zero value is no effect.
+type effect struct {
+	command, param byte
+}
+
+// instrument is a single sampled instrument.
+type instrument struct {
+	name       string
+	data       []int16
+	sixteenBit bool
+	volume     byte
+	panning    byte
+	tune       float64 // semitones relative to C-4 playing at 8363 Hz
+	loopStart  int     // in samples
+	loopLength int     // in samples, or 0 for no loop
+	pingPong   bool
+}
+
+// Convert converts m to the given target format. The returned module is a
+// *protracker.ModFile, *s3m.S3M or *xm.Module. The warnings describe what
+// could not be converted exactly. A module that already is in the target
+// format is returned unchanged.
+func Convert(m module.Module, format string) (module.Module, []string, error) {
+	format = strings.ToLower(format)
+	switch m.(type) {
+	case *protracker.ModFile:
+		if format == MOD {
+			return m, nil, nil
+		}
+	case *s3m.S3M:
+		if format == S3M {
+			return m, nil, nil
+		}
+	case *xm.Module:
+		if format == XM {
+			return m, nil, nil
+		}
+	}
+
+	var w warnings
+	s, err := read(m, &w)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var out module.Module
+	switch format {
+	case MOD:
+		out, err = toMOD(s, &w)
+	case S3M:
+		out, err = toS3M(s, &w)
+	case XM:
+		out, err = toXM(s, &w)
+	default:
+		return nil, nil, fmt.Errorf("unsupported target format %q", format)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, w.list(), nil
+}
+
+// Write converts m to the given target format and writes it to out.
+func Write(out io.Writer, m module.Module, format string) ([]string, error) {
+	converted, warnings, err := Convert(m, format)
+	if err != nil {
+		return nil, err
+	}
+	switch c := converted.(type) {
+	case *protracker.ModFile:
+		err = protracker.Write(out, c)
+	case *s3m.S3M:
+		err = s3m.Write(out, c)
+	case *xm.Module:
+		err = xm.Write(out, c)
+	}
+	return warnings, err
+}
+
+// warnings collects conversion warnings, counting repeated ones.
+type warnings struct {
+	messages []string
+	counts   map[string]int
+}
+
+func (w *warnings) add(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if w.counts == nil {
+		w.counts = make(map[string]int)
+	}
+	if w.counts[msg] == 0 {
+		w.messages = append(w.messages, msg)
+	}
+	w.counts[msg]++
+}
+
+func (w *warnings) list() []string {
+	list := make([]string, len(w.messages))
+	for i, msg := range w.messages {
+		list[i] = msg
+		if n := w.counts[msg]; n > 1 {
+			list[i] = fmt.Sprintf("%s (%d times)", msg, n)
+		}
+	}
+	return list
+}
+
+// newPattern returns a pattern of empty cells.
+func newPattern(rows, channels int) pattern {
+	p := make(pattern, rows)
+	for r := range p {
+		p[r] = make([]cell, channels)
+		for ch := range p[r] {
+			p[r][ch].volume = -1
+		}
+	}
+	return p
+}
+
+// setEffect stores e in the first cell of row without an effect, and reports
+// whether there was one.
+func setEffect(row []cell, e effect) bool {
+	for ch := range row {
+		if row[ch].effect == (effect{}) {
+			row[ch].effect = e
+			return true
+		}
+	}
+	return false
+}
+
+// hasEffect reports whether a cell of row uses the given effect command.
+func hasEffect(row []cell, command byte) bool {
+	for _, c := range row {
+		if c.effect != (effect{}) && c.effect.command == command {
+			return true
+		}
+	}
+	return false
+}
+
+// fitPatterns rearranges the song into patterns of exactly rows rows, as
+// required by MOD and S3M. Longer patterns are split, and shorter patterns
+// are padded and end with a pattern break.
+func (s *song) fitPatterns(rows int, w *warnings) {
+	var patterns []pattern
+	parts := make([][]int, len(s.patterns))
+	for i, p := range s.patterns {
+		if len(p) > rows {
+			w.add("patterns longer than %d rows were split", rows)
+		}
+		for start := 0; start == 0 || start < len(p); start += rows {
+			end := min(start+rows, len(p))
+			part := newPattern(rows, s.channels)
+			for r := start; r < end; r++ {
+				copy(part[r-start], p[r])
+			}
+			if last := end - start - 1; last >= 0 && last < rows-1 {
+				if !hasEffect(part[last], fxBreak) && !hasEffect(part[last], fxJump) && !setEffect(part[last], effect{fxBreak, 0}) {
+					w.add("a pattern shorter than %d rows could not be ended early", rows)
+				}
+			}
+			if end < len(p) {
+				for r := start; r < end; r++ {
+					if hasEffect(p[r], fxBreak) {
+						w.add("pattern breaks in split patterns continue with the rest of the pattern")
+						break
+					}
+				}
+			}
+			parts[i] = append(parts[i], len(patterns))
+			patterns = append(patterns, part)
+		}
+	}
+
+	positions := make([]int, len(s.order))
+	var order []int
+	for i, o := range s.order {
+		positions[i] = len(order)
+		order = append(order, parts[o]...)
+	}
+	if len(order) != len(s.order) {
+		for _, p := range patterns {
+			for _, row := range p {
+				for ch := range row {
+					e := &row[ch].effect
+					if e.command == fxJump && *e != (effect{}) && int(e.param) < len(positions) {
+						e.param = byte(min(positions[e.param], 255))
+					}
+				}
+			}
+		}
+	}
+	s.patterns, s.order = patterns, order
+}
+
+// effectName returns a description of an XM effect command for warnings.
+func effectName(command byte) string {
+	names := map[byte]string{
+		fxPanning:        "set panning (8xx)",
+		fxVolume:         "set volume (Cxx)",
+		fxGlobalVolume:   "global volume (Gxx)",
+		fxGlobalVolSlide: "global volume slide (Hxy)",
+		fxKeyOff:         "key off (Kxx)",
+		fxEnvelopePos:    "set envelope position (Lxx)",
+		fxPanSlide:       "panning slide (Pxy)",
+		fxRetrig:         "multi retrig (Rxy)",
+		fxTremor:         "tremor (Txy)",
+		fxExtraFinePorta: "extra fine portamento (Xxy)",
+	}
+	if name, ok := names[command]; ok {
+		return name
+	}
+	if command < 36 {
+		return fmt.Sprintf("effect %s", string("0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"[command]))
+	}
+	return fmt.Sprintf("effect %d", command)
+}
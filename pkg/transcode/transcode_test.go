@@ -0,0 +1,204 @@
+package transcode
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/jesseward/impulse/pkg/loader"
+	"github.com/jesseward/impulse/pkg/module"
+)
+
+func loadExample(t *testing.T, name string) module.Module {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "..", "examples", name))
+	if err != nil {
+		t.Fatalf("failed to read test file: %v", err)
+	}
+	m, err := loader.LoadBytes(data)
+	if err != nil {
+		t.Fatalf("failed to load %s: %v", name, err)
+	}
+	return m
+}
+
+func TestWrite_Examples(t *testing.T) {
+	examples := []string{
+		"space_debris.mod",
+		"acid_atmosphere_q-sou.s3m",
+		"volume-envelope.xm",
+		"creations_of_thurs_-_tranceplanted.xm",
+	}
+	for _, name := range examples {
+		for _, format := range []string{MOD, S3M, XM} {
+			t.Run(name+"/"+format, func(t *testing.T) {
+				m := loadExample(t, name)
+				var buf bytes.Buffer
+				if _, err := Write(&buf, m, format); err != nil {
+					t.Fatalf("Write() failed: %v", err)
+				}
+				out, err := loader.LoadBytes(buf.Bytes())
+				if err != nil {
+					t.Fatalf("failed to load the converted module: %v", err)
+				}
+				if out.NumChannels() != m.NumChannels() {
+					t.Errorf("NumChannels() = %d, want %d", out.NumChannels(), m.NumChannels())
+				}
+				if out.SongLength() < m.SongLength() {
+					t.Errorf("SongLength() = %d, want at least %d", out.SongLength(), m.SongLength())
+				}
+			})
+		}
+	}
+}
+
+func TestConvert_MODToXMAndBack(t *testing.T) {
+	m := loadExample(t, "space_debris.mod")
+	converted, warnings, err := Convert(m, XM)
+	if err != nil {
+		t.Fatalf("Convert() to XM failed: %v", err)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("unexpected warnings converting to XM: %v", warnings)
+	}
+	back, _, err := Convert(converted, MOD)
+	if err != nil {
+		t.Fatalf("Convert() to MOD failed: %v", err)
+	}
+
+	for i, o := range m.PatternOrder()[:m.SongLength()] {
+		if got := back.PatternOrder()[i]; got != o {
+			t.Fatalf("order %d = %d, want %d", i, got, o)
+		}
+	}
+	for p := 0; p < m.NumPatterns(); p++ {
+		for row := 0; row < 64; row++ {
+			for ch := 0; ch < m.NumChannels(); ch++ {
+				want, got := m.PatternCell(p, row, ch), back.PatternCell(p, row, ch)
+				if got.Period != want.Period || got.SampleNumber != want.SampleNumber ||
+					got.Effect != want.Effect || got.EffectParam != want.EffectParam {
+					t.Fatalf("pattern %d row %d channel %d = %+v, want %+v", p, row, ch, got, want)
+				}
+			}
+		}
+	}
+	for i, s := range m.Samples() {
+		got := back.Samples()[i]
+		if got.Finetune() != s.Finetune() || got.Volume() != s.Volume() || got.LoopStart() != s.LoopStart() {
+			t.Errorf("sample %d differs after conversion", i+1)
+		}
+	}
+}
+
+func TestS3MEffect(t *testing.T) {
+	tests := []struct {
+		letter byte
+		param  byte
+		want   effect
+		warns  bool
+	}{
+		{'A', 0x06, effect{fxSpeed, 0x06}, false},
+		{'T', 0x7D, effect{fxSpeed, 0x7D}, false},
+		{'D', 0x04, effect{fxVolSlide, 0x04}, false},
+		{'D', 0x30, effect{fxVolSlide, 0x30}, false},
+		{'D', 0x3F, effect{fxExtended, 0xA3}, false},
+		{'D', 0xF2, effect{fxExtended, 0xB2}, false},
+		{'E', 0x12, effect{fxPortaDown, 0x12}, false},
+		{'E', 0xF3, effect{fxExtended, 0x23}, false},
+		{'F', 0xE2, effect{fxExtraFinePorta, 0x12}, false},
+		{'K', 0x20, effect{fxVibratoVolSlide, 0x20}, false},
+		{'Q', 0x13, effect{fxRetrig, 0x13}, false},
+		{'S', 0xB2, effect{fxExtended, 0x62}, false},
+		{'S', 0x8F, effect{fxPanning, 0xFF}, false},
+		{'V', 0x20, effect{fxGlobalVolume, 0x20}, false},
+		{'X', 0x40, effect{fxPanning, 0x80}, false},
+		{'U', 0x48, effect{fxVibrato, 0x42}, true},
+		{'Y', 0x11, effect{}, true},
+	}
+	for _, tt := range tests {
+		var w warnings
+		got := s3mEffect(tt.letter-'A'+1, tt.param, &w)
+		if got != tt.want {
+			t.Errorf("s3mEffect(%c%02X) = %+v, want %+v", tt.letter, tt.param, got, tt.want)
+		}
+		if warned := len(w.list()) > 0; warned != tt.warns {
+			t.Errorf("s3mEffect(%c%02X) warned = %v, want %v", tt.letter, tt.param, warned, tt.warns)
+		}
+	}
+}
+
+func TestS3MEffectFrom(t *testing.T) {
+	tests := []struct {
+		e      effect
+		letter byte
+		param  byte
+		warns  bool
+	}{
+		{effect{fxArpeggio, 0x37}, 'J', 0x37, false},
+		{effect{fxVolSlide, 0x0F}, 'D', 0x0F, false},
+		{effect{fxVolSlide, 0x24}, 'D', 0x20, false},
+		{effect{fxPortaUp, 0xF0}, 'F', 0xDF, true},
+		{effect{fxExtended, 0x13}, 'F', 0xF3, false},
+		{effect{fxExtended, 0xA2}, 'D', 0x2F, false},
+		{effect{fxSpeed, 0x1F}, 'A', 0x1F, false},
+		{effect{fxSpeed, 0x20}, 'T', 0x20, false},
+		{effect{fxPanning, 0xFF}, 'X', 0x80, false},
+		{effect{fxRetrig, 0x13}, 'Q', 0x13, false},
+		{effect{fxPanSlide, 0x10}, 0, 0, true},
+	}
+	for _, tt := range tests {
+		var w warnings
+		letter, param := s3mEffectFrom(tt.e, &w)
+		if letter != tt.letter || param != tt.param {
+			t.Errorf("s3mEffectFrom(%+v) = %c%02X, want %c%02X", tt.e, letter, param, tt.letter, tt.param)
+		}
+		if warned := len(w.list()) > 0; warned != tt.warns {
+			t.Errorf("s3mEffectFrom(%+v) warned = %v, want %v", tt.e, warned, tt.warns)
+		}
+	}
+}
+
+func TestNoteToPeriod(t *testing.T) {
+	var w warnings
+	for i, period := range modPeriods {
+		note := periodToNote(period)
+		if note != byte(modFirstNote+i) {
+			t.Errorf("periodToNote(%d) = %d, want %d", period, note, modFirstNote+i)
+		}
+		if got := noteToPeriod(note, &w); got != period {
+			t.Errorf("noteToPeriod(%d) = %d, want %d", note, got, period)
+		}
+	}
+	if len(w.list()) != 0 {
+		t.Errorf("unexpected warnings: %v", w.list())
+	}
+	if got := noteToPeriod(modFirstNote-12, &w); got != modPeriods[0] {
+		t.Errorf("noteToPeriod(%d) = %d, want %d", modFirstNote-12, got, modPeriods[0])
+	}
+	if len(w.list()) != 1 {
+		t.Errorf("expected a warning for a transposed note, got %v", w.list())
+	}
+}
+
+func TestFitPatterns(t *testing.T) {
+	s := &song{channels: 2, order: []int{0, 1}}
+	s.patterns = []pattern{newPattern(128, 2), newPattern(32, 2)}
+	s.patterns[0][100][0].effect = effect{fxJump, 1}
+
+	var w warnings
+	s.fitPatterns(64, &w)
+	if len(s.patterns) != 3 {
+		t.Fatalf("got %d patterns, want 3", len(s.patterns))
+	}
+	if want := []int{0, 1, 2}; !slices.Equal(s.order, want) {
+		t.Errorf("order = %v, want %v", s.order, want)
+	}
+	if got := s.patterns[1][36][0].effect; got != (effect{fxJump, 2}) {
+		t.Errorf("jump = %+v, want it to refer to order 2", got)
+	}
+	if got := s.patterns[2][31][0].effect; got != (effect{fxBreak, 0}) {
+		t.Errorf("short pattern ends with %+v, want a pattern break", got)
+	}
+}
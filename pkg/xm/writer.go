@@ -0,0 +1,339 @@
+package xm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Write serializes an XM module to w. Patterns are packed the way FastTracker
+// II packs them, so a module returned by Read is written back byte for byte as
+// long as it has not been modified.
+func Write(w io.Writer, m *Module) error {
+	bw := bufio.NewWriter(w)
+
+	if err := m.Header.write(bw, len(m.Patterns), len(m.Instruments)); err != nil {
+		return err
+	}
+	for i, p := range m.Patterns {
+		if err := p.write(bw, int(m.Header.NumChannels)); err != nil {
+			return fmt.Errorf("failed to write pattern %d: %w", i, err)
+		}
+	}
+	for i, inst := range m.Instruments {
+		if err := inst.write(bw); err != nil {
+			return fmt.Errorf("failed to write instrument %d: %w", i, err)
+		}
+	}
+
+	return bw.Flush()
+}
+
+func (h *Header) write(w io.Writer, numPatterns, numInstruments int) error {
+	id := h.IDText
+	if id == "" {
+		id = "Extended Module: "
+	}
+	magic := h.magic
+	if magic == 0 {
+		magic = 0x1A
+	}
+	headerSize := h.HeaderSize
+	if headerSize == 0 {
+		headerSize = 276
+	}
+
+	w.Write(fixedString(id, 17))
+	w.Write(fixedString(h.ModuleName, 20))
+	w.Write([]byte{magic})
+	w.Write(fixedString(h.TrackerName, 20))
+	binary.Write(w, binary.LittleEndian, h.Version)
+	binary.Write(w, binary.LittleEndian, headerSize)
+
+	var rest bytes.Buffer
+	binary.Write(&rest, binary.LittleEndian, []uint16{
+		h.SongLength,
+		h.RestartPosition,
+		h.NumChannels,
+		uint16(numPatterns),
+		uint16(numInstruments),
+		h.Flags,
+		h.DefaultTempo,
+		h.DefaultBPM,
+	})
+	var order [256]byte
+	copy(order[:], h.patternOrder)
+	rest.Write(order[:])
+	rest.Write(h.extra)
+	if int(headerSize)-4 < 20 {
+		return fmt.Errorf("header size %d is too small", headerSize)
+	}
+	_, err := w.Write(fixedString(rest.String(), int(headerSize)-4))
+	return err
+}
+
+func (p *Pattern) write(w io.Writer, numChannels int) error {
+	var packed bytes.Buffer
+	if !p.isEmpty() {
+		for row := 0; row < int(p.NumRows); row++ {
+			for ch := 0; ch < numChannels; ch++ {
+				var note Note
+				if row < len(p.Notes) && ch < len(p.Notes[row]) {
+					note = p.Notes[row][ch]
+				}
+				note.pack(&packed)
+			}
+		}
+	}
+	if packed.Len() > 0xFFFF {
+		return fmt.Errorf("packed pattern is %d bytes", packed.Len())
+	}
+
+	binary.Write(w, binary.LittleEndian, uint32(9+len(p.extra)))
+	binary.Write(w, binary.LittleEndian, p.PackingType)
+	binary.Write(w, binary.LittleEndian, p.NumRows)
+	binary.Write(w, binary.LittleEndian, uint16(packed.Len()))
+	w.Write(p.extra)
+	_, err := w.Write(packed.Bytes())
+	return err
+}
+
+// isEmpty reports whether every note of a pattern is empty. FastTracker II
+// stores no data at all for such a pattern.
+func (p *Pattern) isEmpty() bool {
+	for _, row := range p.Notes {
+		for _, note := range row {
+			if note != (Note{}) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// pack appends a note to buf. As in FastTracker II, a note with a note,
+// instrument, volume and effect is stored unpacked, whatever its effect
+// parameter, otherwise a flag byte is followed by the fields that are set.
+func (n Note) pack(buf *bytes.Buffer) {
+	fields := []byte{n.Note, n.Instrument, n.Volume, n.EffectType, n.EffectParam}
+	if n.Note != 0 && n.Note&0x80 == 0 && n.Instrument != 0 && n.Volume != 0 && n.EffectType != 0 {
+		buf.Write(fields)
+		return
+	}
+	flags := byte(0x80)
+	for i, v := range fields {
+		if v != 0 {
+			flags |= 1 << i
+		}
+	}
+	buf.WriteByte(flags)
+	for _, v := range fields {
+		if v != 0 {
+			buf.WriteByte(v)
+		}
+	}
+}
+
+func (i *Instrument) write(w io.Writer) error {
+	var header bytes.Buffer
+	header.Write(fixedString(i.Name, 22))
+	header.WriteByte(i.Type)
+	binary.Write(&header, binary.LittleEndian, uint16(len(i.Samples)))
+	if len(i.Samples) > 0 {
+		binary.Write(&header, binary.LittleEndian, i.SampleHeaderSize)
+		header.Write(i.SampleKeymap[:])
+		binary.Write(&header, binary.LittleEndian, i.VolumeEnvelopePoints)
+		binary.Write(&header, binary.LittleEndian, i.PanningEnvelopePoints)
+		header.Write([]byte{
+			i.NumVolumePoints,
+			i.NumPanningPoints,
+			i.VolumeSustainPoint,
+			i.VolumeLoopStartPoint,
+			i.VolumeLoopEndPoint,
+			i.PanningSustainPoint,
+			i.PanningLoopStartPoint,
+			i.PanningLoopEndPoint,
+			i.VolumeType,
+			i.PanningType,
+			i.VibratoType,
+			i.VibratoSweep,
+			i.VibratoDepth,
+			i.VibratoRate,
+		})
+		binary.Write(&header, binary.LittleEndian, i.VolumeFadeout)
+		binary.Write(&header, binary.LittleEndian, i.Reserved)
+	}
+	header.Write(i.extra)
+
+	binary.Write(w, binary.LittleEndian, uint32(4+header.Len()))
+	w.Write(header.Bytes())
+
+	for _, s := range i.Samples {
+		if err := s.writeHeader(w); err != nil {
+			return err
+		}
+	}
+	for _, s := range i.Samples {
+		if _, err := w.Write(s.encodeData()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Sample) writeHeader(w io.Writer) error {
+	var header struct {
+		Length       uint32
+		LoopStart    uint32
+		LoopLength   uint32
+		Volume       byte
+		Finetune     int8
+		Type         byte
+		Panning      byte
+		RelativeNote int8
+		Reserved     byte
+		Name         [22]byte
+	}
+	header.Length = s.length
+	header.LoopStart = s.loopStart
+	header.LoopLength = s.loopLength
+	header.Volume = s.volume
+	header.Finetune = s.finetune
+	header.Type = s.Type
+	header.Panning = s.panning
+	header.RelativeNote = s.relativeNote
+	header.Reserved = s.Reserved
+	copy(header.Name[:], s.name)
+	return binary.Write(w, binary.LittleEndian, &header)
+}
+
+// encodeData delta encodes the sample data the way it is stored in the file.
+func (s *Sample) encodeData() []byte {
+	data := make([]byte, 0, s.length)
+	if s.Type&0x10 != 0 {
+		var old int16
+		for _, v := range s.data {
+			data = binary.LittleEndian.AppendUint16(data, uint16(v-old))
+			old = v
+		}
+	} else {
+		var old int8
+		for _, v := range s.data {
+			cur := int8(v >> 8)
+			data = append(data, byte(cur-old))
+			old = cur
+		}
+	}
+	// Pad a truncated trailing byte of 16-bit data.
+	for uint32(len(data)) < s.length {
+		data = append(data, 0)
+	}
+	return data
+}
+
+// fixedString returns s truncated or padded with NUL bytes to n bytes.
+func fixedString(s string, n int) []byte {
+	b := make([]byte, n)
+	copy(b, s)
+	return b
+}
+
+// NewSample returns a sample holding data, which uses the 16-bit scale of
+// Sample.Data. flags is the XM sample type: bits 0-1 select no loop, a
+// forward loop or a ping-pong loop, and bit 4 stores the sample with 16
+// bits. The loop points are in samples.
+func NewSample(name string, data []int16, flags, volume byte, finetune, relativeNote int8, panning byte, loopStart, loopLength uint32) *Sample {
+	s := &Sample{
+		loopStart:    loopStart,
+		loopLength:   loopLength,
+		volume:       min(volume, 64),
+		finetune:     finetune,
+		Type:         flags,
+		panning:      panning,
+		relativeNote: relativeNote,
+		name:         name,
+		data:         make([]int16, len(data)),
+		flags:        flags,
+	}
+	copy(s.data, data)
+	s.length = uint32(len(data))
+	if flags&0x10 != 0 {
+		s.length *= 2
+		s.loopStart *= 2
+		s.loopLength *= 2
+	} else {
+		for i := range s.data {
+			s.data[i] &^= 0xFF
+		}
+	}
+	return s
+}
+
+// NewInstrument returns an instrument without envelopes that plays the first
+// of its samples for every note.
+func NewInstrument(name string, samples []*Sample) *Instrument {
+	inst := &Instrument{
+		Name:       name,
+		NumSamples: uint16(len(samples)),
+		Samples:    samples,
+	}
+	if len(samples) > 0 {
+		inst.SampleHeaderSize = 40
+		// FastTracker II pads the instrument header to 263 bytes.
+		inst.extra = make([]byte, 20)
+	}
+	return inst
+}
+
+// New returns a module using the given order list, patterns and instruments.
+// The module uses the linear frequency table; clear bit 0 of Header.Flags to
+// use Amiga periods instead.
+func New(name string, numChannels int, speed, bpm uint16, order []byte, patterns []*Pattern, instruments []*Instrument) (*Module, error) {
+	if numChannels < 1 || numChannels > 32 {
+		return nil, fmt.Errorf("unsupported number of channels: %d", numChannels)
+	}
+	if len(order) == 0 || len(order) > 256 {
+		return nil, fmt.Errorf("unsupported song length: %d", len(order))
+	}
+	if len(patterns) > 256 {
+		return nil, fmt.Errorf("too many patterns: %d", len(patterns))
+	}
+	if len(instruments) > 128 {
+		return nil, fmt.Errorf("too many instruments: %d", len(instruments))
+	}
+	for i, p := range patterns {
+		if p.NumRows == 0 || p.NumRows > 256 || len(p.Notes) != int(p.NumRows) {
+			return nil, fmt.Errorf("pattern %d has %d rows of notes, want 1-256", i, len(p.Notes))
+		}
+		for _, row := range p.Notes {
+			if len(row) != numChannels {
+				return nil, fmt.Errorf("pattern %d has %d channels, want %d", i, len(row), numChannels)
+			}
+		}
+	}
+
+	m := &Module{
+		Header: Header{
+			IDText:         "Extended Module: ",
+			ModuleName:     name,
+			TrackerName:    "impulse",
+			Version:        0x0104,
+			HeaderSize:     276,
+			SongLength:     uint16(len(order)),
+			NumChannels:    uint16(numChannels),
+			NumPatterns:    uint16(len(patterns)),
+			NumInstruments: uint16(len(instruments)),
+			Flags:          1,
+			DefaultTempo:   speed,
+			DefaultBPM:     bpm,
+			patternOrder:   make([]byte, 256),
+			magic:          0x1A,
+		},
+		Patterns:    patterns,
+		Instruments: instruments,
+	}
+	copy(m.Header.patternOrder, order)
+	return m, nil
+}
@@ -33,6 +33,8 @@ type Header struct {
 	DefaultTempo    uint16
 	DefaultBPM      uint16
 	patternOrder    []byte
+	magic           byte
+	extra           []byte // header bytes following the pattern order table
 }
 
 // Pattern represents a single pattern.
@@ -42,6 +44,7 @@ type Pattern struct {
 	NumRows        uint16
 	PackedDataSize uint16
 	Notes          [][]Note
+	extra          []byte // pattern header bytes following PackedDataSize
 }
 
 // Note represents a single note in a pattern.
@@ -53,6 +56,22 @@ type Note struct {
 	EffectParam byte
 }
 
+// NoteKeyOff is the pattern note that releases the playing note.
+const NoteKeyOff = 97
+
+var noteTable = [12]string{"C-", "C#", "D-", "D#", "E-", "F-", "F#", "G-", "G#", "A-", "A#", "B-"}
+
+// NoteToString returns the tracker representation of a pattern note.
+func NoteToString(note byte) string {
+	switch {
+	case note == NoteKeyOff:
+		return "==="
+	case note == 0 || note > NoteKeyOff:
+		return module.EmptyNote
+	}
+	return fmt.Sprintf("%s%d", noteTable[(note-1)%12], (note-1)/12)
+}
+
 // EnvelopePoint represents a single point in an envelope.
 type EnvelopePoint struct {
 	Frame uint16
@@ -86,6 +105,7 @@ type Instrument struct {
 	VolumeFadeout         uint16
 	Reserved              uint16
 	Samples               []*Sample
+	extra                 []byte // instrument header bytes following the parsed fields
 }
 
 // Sample represents a single sample.
@@ -104,6 +124,15 @@ type Sample struct {
 	flags        byte
 }
 
+// Probe returns a confidence score from 0 to 100 that header, the start of a
+// file, is an XM module.
+func Probe(header []byte) int {
+	if len(header) >= 17 && string(header[:17]) == "Extended Module: " {
+		return 100
+	}
+	return 0
+}
+
 // Read reads an XM module from the given reader.
 func Read(r io.Reader) (*Module, error) {
 	mod := &Module{}
@@ -153,6 +182,7 @@ func (h *Header) parse(r io.Reader) error {
 	if _, err := io.ReadFull(r, magic[:]); err != nil {
 		return fmt.Errorf("reading magic byte: %w", err)
 	}
+	h.magic = magic[0]
 
 	var trackerName [20]byte
 	if _, err := io.ReadFull(r, trackerName[:]); err != nil {
@@ -192,6 +222,7 @@ func (h *Header) parse(r io.Reader) error {
 			return fmt.Errorf("reading pattern order: %w", err)
 		}
 	}
+	h.extra, _ = io.ReadAll(restReader)
 
 	return nil
 }
@@ -212,8 +243,9 @@ func (p *Pattern) parse(r io.Reader, numChannels int) error {
 	p.PackedDataSize = header.PackedDataSize
 
 	if p.HeaderLength > 9 {
-		if _, err := io.CopyN(io.Discard, r, int64(p.HeaderLength-9)); err != nil {
-			return fmt.Errorf("skipping rest of pattern header: %w", err)
+		p.extra = make([]byte, p.HeaderLength-9)
+		if _, err := io.ReadFull(r, p.extra); err != nil {
+			return fmt.Errorf("reading rest of pattern header: %w", err)
 		}
 	}
 
@@ -344,6 +376,7 @@ func (i *Instrument) parse(r io.Reader) error {
 		binary.Read(hr, binary.LittleEndian, &i.VolumeFadeout)
 		binary.Read(hr, binary.LittleEndian, &i.Reserved)
 	}
+	i.extra, _ = io.ReadAll(hr)
 
 	i.Samples = make([]*Sample, i.NumSamples)
 	for j := 0; j < int(i.NumSamples); j++ {
@@ -489,6 +522,7 @@ func (m *Module) PatternCell(pattern, row, channel int) module.Cell {
 	}
 	note := m.Patterns[pattern].Notes[row][channel]
 	return module.Cell{
+		HumanNote:   NoteToString(note.Note),
 		Note:        note.Note,
 		Instrument:  note.Instrument,
 		Volume:      note.Volume,
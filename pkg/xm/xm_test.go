@@ -1,6 +1,7 @@
 package xm
 
 import (
+	"bytes"
 	"os"
 	"path/filepath"
 	"testing"
@@ -19,3 +20,99 @@ func TestRead(t *testing.T) {
 		t.Fatalf("Read() failed: %v", err)
 	}
 }
+
+func TestWrite_RoundTrip(t *testing.T) {
+	for _, name := range []string{"volume-envelope.xm", "creations_of_thurs_-_tranceplanted.xm"} {
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("..", "..", "examples", name))
+			if err != nil {
+				t.Fatalf("failed to read test file: %v", err)
+			}
+			m, err := Read(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("Read() failed: %v", err)
+			}
+
+			var buf bytes.Buffer
+			if err := Write(&buf, m); err != nil {
+				t.Fatalf("Write() failed: %v", err)
+			}
+			got := buf.Bytes()
+			if !bytes.Equal(got, data) {
+				for i := range got {
+					if i >= len(data) || got[i] != data[i] {
+						t.Fatalf("written module differs at offset %d (got %d bytes, want %d)", i, len(got), len(data))
+					}
+				}
+				t.Fatalf("written module is %d bytes, want %d", len(got), len(data))
+			}
+		})
+	}
+}
+
+func TestNote_pack(t *testing.T) {
+	tests := []struct {
+		name string
+		note Note
+		want []byte
+	}{
+		{"empty", Note{}, []byte{0x80}},
+		{"parameter only", Note{EffectParam: 0x37}, []byte{0x90, 0x37}},
+		{"arpeggio", Note{Note: 49, EffectParam: 0x37}, []byte{0x91, 49, 0x37}},
+		{"every field", Note{49, 1, 0x40, 0x0C, 0x20}, []byte{49, 1, 0x40, 0x0C, 0x20}},
+		{"no parameter", Note{49, 1, 0x40, 0x0C, 0}, []byte{49, 1, 0x40, 0x0C, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tt.note.pack(&buf)
+			if !bytes.Equal(buf.Bytes(), tt.want) {
+				t.Errorf("pack() = % x, want % x", buf.Bytes(), tt.want)
+			}
+		})
+	}
+}
+
+func TestWrite_ParameterOnlyNote(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "examples", "volume-envelope.xm"))
+	if err != nil {
+		t.Fatalf("failed to read test file: %v", err)
+	}
+	m, err := Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Read() failed: %v", err)
+	}
+	want := Note{EffectParam: 0x37}
+	m.Patterns[0].Notes[1][0] = want
+
+	var buf bytes.Buffer
+	if err := Write(&buf, m); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	m, err = Read(&buf)
+	if err != nil {
+		t.Fatalf("Read() of the written module failed: %v", err)
+	}
+	if got := m.Patterns[0].Notes[1][0]; got != want {
+		t.Errorf("note = %+v, want %+v", got, want)
+	}
+}
+
+func TestNoteToString(t *testing.T) {
+	tests := []struct {
+		note     byte
+		expected string
+	}{
+		{0, "..."},
+		{1, "C-0"},
+		{49, "C-4"},
+		{96, "B-7"},
+		{NoteKeyOff, "==="},
+		{98, "..."},
+	}
+	for _, tt := range tests {
+		if got := NoteToString(tt.note); got != tt.expected {
+			t.Errorf("NoteToString(%d) = %q, want %q", tt.note, got, tt.expected)
+		}
+	}
+}